@@ -0,0 +1,147 @@
+package main
+
+import (
+	"devcamper/config"
+	"devcamper/models"
+	"devcamper/utils"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// bootcamps of the dataset, one page, and courses of each bootcamp
+const (
+	benchBootcamps = 100
+	benchCourses   = 5
+)
+
+// compare GetBootcamps with expand=courses, one aggregation, against the listing followed by
+// one course query per bootcamp (the listing before $lookup), on the memory store and on mongo
+// when MONGO_URI is set
+func BenchmarkGetBootcamps(b *testing.B) {
+	b.Run("memory", func(b *testing.B) {
+		benchmarkGetBootcamps(b, models.NewMemoryStore())
+	})
+
+	b.Run("mongo", func(b *testing.B) {
+		uri := os.Getenv("MONGO_URI")
+		if uri == "" {
+			b.Skip("MONGO_URI is not set")
+		}
+		db := os.Getenv("MONGO_DB")
+		if db == "" {
+			db = "devcamper"
+		}
+		// never touch the documents of the app
+		cfg := &config.Config{MongoURI: uri, MongoDB: db + "_benchmark"}
+		conn := config.ConnDB(cfg)
+		defer conn.Close()
+		defer conn.Session.DB(cfg.MongoDB).DropDatabase()
+
+		models.RegisterModels(conn)
+		benchmarkGetBootcamps(b, models.NewMongoStore(conn))
+	})
+}
+
+func benchmarkGetBootcamps(b *testing.B, store models.Store) {
+	repos := models.NewRepos(store)
+	err := fillBenchmark(repos, benchBootcamps, benchCourses)
+	if err != nil {
+		b.Fatal(err)
+	}
+	handler := newTestRouter(b, testConfig(), store, repos, utils.DefaultAttemptPolicy)
+	path := fmt.Sprintf("/api/v1/bootcamps?limit=%d", benchBootcamps)
+
+	// the listing, then the courses of each bootcamp with its own query
+	b.Run("per-bootcamp", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var res struct {
+				Data []struct {
+					Id bson.ObjectId `json:"id"`
+				} `json:"data"`
+			}
+			getBenchmark(b, handler, path, &res)
+			for _, bootcamp := range res.Data {
+				_, err := repos.Courses.Find(models.Query{Filter: bson.M{
+					"bootcamp": bootcamp.Id,
+					"deleted":  false,
+				}})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	// the courses joined by the listing
+	b.Run("lookup", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var res struct {
+				Data []struct {
+					Courses []interface{} `json:"courses"`
+				} `json:"data"`
+			}
+			getBenchmark(b, handler, path+"&expand=courses", &res)
+			if len(res.Data) != benchBootcamps || len(res.Data[0].Courses) != benchCourses {
+				b.Fatalf("got %d bootcamps, want %d with %d courses", len(res.Data), benchBootcamps, benchCourses)
+			}
+		}
+	})
+}
+
+// serve the GET request and decode its JSON body
+func getBenchmark(b *testing.B, handler http.Handler, path string, v interface{}) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if w.Code != http.StatusOK {
+		b.Fatalf("GET %s: status %d: %s", path, w.Code, w.Body)
+	}
+	err := json.NewDecoder(w.Body).Decode(v)
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// bootcamps with their courses, all of one publisher
+func fillBenchmark(repos *models.Repos, bootcamps int, courses int) error {
+	user := repos.Users.New()
+	user.Name = "Publisher"
+	user.Email = "publisher@devcamper.io"
+	user.Role = "publisher"
+	err := repos.Users.Save(user)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < bootcamps; i++ {
+		bootcamp := repos.Bootcamps.New()
+		bootcamp.Name = fmt.Sprintf("Bootcamp %d", i)
+		bootcamp.Slug = fmt.Sprintf("bootcamp-%d", i)
+		bootcamp.Careers = []string{"Web Development"}
+		bootcamp.User = user.Id
+		err := repos.Bootcamps.Save(bootcamp)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < courses; j++ {
+			course := repos.Courses.New()
+			course.Title = fmt.Sprintf("Course %d", j)
+			course.Weeks = 8
+			course.Tuition = float64(1000 * (j + 1))
+			course.MinimumSkill = "beginner"
+			course.Bootcamp = bootcamp.Id
+			course.User = user.Id
+			err := repos.Courses.Save(course)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"devcamper/config"
+	"devcamper/models"
+	"devcamper/utils"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fixture file name and the registered model it is loaded into
+var fixtures = []struct {
+	file  string
+	model string
+}{
+	{"users.json", "User"},
+	{"bootcamps.json", "Bootcamp"},
+	{"courses.json", "Course"},
+	{"reviews.json", "Review"},
+}
+
+func main() {
+	importData := flag.Bool("import", false, "import the fixtures into the database")
+	destroyData := flag.Bool("destroy", false, "delete every document of the seeded collections")
+	dataDir := flag.String("data", "_data", "directory of the fixture files")
+	// the settings of the app (-config, -mongo-uri, etc.) are accepted too
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *importData == *destroyData {
+		fmt.Println("usage: seeder -import | -destroy [-data dir]")
+		return
+	}
+
+	// locate the bootcamps offline so every developer gets the same dataset
+	zipcodes, err := utils.LoadGazetteer("./config/zipcodes.csv")
+	if err != nil {
+		log.Fatalf("Gazetteer error: %v\n", err)
+	}
+	geocoder, err := utils.LoadOfflineGeocoder("./config/geocodes.json", zipcodes)
+	if err != nil {
+		log.Fatalf("Geocoder error: %v\n", err)
+	}
+
+	// connect to DB
+	conn := config.ConnDB(cfg)
+	defer conn.Close()
+
+	// mount models to DB
+	models.RegisterModels(conn)
+
+	store := models.NewMongoStore(conn)
+	if *destroyData {
+		destroy(store)
+		return
+	}
+	seed(store, *dataDir, geocoder)
+}
+
+// insert every fixture then recompute the bootcamp averages
+func seed(store models.Store, dir string, geocoder utils.Geocoder) {
+	now := time.Now()
+	for _, f := range fixtures {
+		file, err := ioutil.ReadFile(filepath.Join(dir, f.file))
+		if err != nil {
+			log.Fatalf("cannot read %s: %v\n", f.file, err)
+		}
+		var records []map[string]interface{}
+		err = json.Unmarshal(file, &records)
+		if err != nil {
+			log.Fatalf("cannot decode %s: %v\n", f.file, err)
+		}
+
+		for _, record := range records {
+			doc, err := newDocument(store.C(f.model), f.model, record)
+			if err != nil {
+				log.Fatalf("bad record in %s: %v\n", f.file, err)
+			}
+			doc.SetCreatedAt(now)
+			if bootcamp, ok := doc.(*models.Bootcamp); ok {
+				res, err := geocoder.Geocode(bootcamp.Address)
+				if err != nil {
+					log.Fatalf("cannot locate %q: %v\n", bootcamp.Address, err)
+				}
+				bootcamp.Location = models.NewGeoJson(res)
+				bootcamp.Address = ""
+			}
+			if user, ok := doc.(*models.User); ok {
+				err = user.HashPassword()
+				if err != nil {
+					log.Fatalf("cannot hash password of %s: %v\n", user.Email, err)
+				}
+				// fixture emails are trusted
+				user.EmailVerified = true
+			}
+			err = store.C(f.model).Save(doc)
+			if err != nil {
+				log.Fatalf("cannot save %s %s: %v\n", f.model, doc.GetId().Hex(), err)
+			}
+		}
+		fmt.Printf("Imported %d %s\n", len(records), strings.TrimSuffix(f.file, ".json"))
+	}
+
+	// recompute the same averages the API maintains
+	repos := models.NewRepos(store)
+	bootcamps, err := repos.Bootcamps.Find(models.Query{Filter: bson.M{"deleted": false}})
+	if err != nil {
+		log.Fatalf("cannot load bootcamps: %v\n", err)
+	}
+	for _, bootcamp := range bootcamps {
+		bootcamp.AverageCost, err = repos.Courses.AverageCost(bootcamp.Id)
+		if err == nil {
+			bootcamp.AverageRating, err = repos.Reviews.AverageRating(bootcamp.Id)
+		}
+		if err == nil {
+			err = repos.Bootcamps.Save(bootcamp)
+		}
+		if err != nil {
+			log.Fatalf("cannot update averages of bootcamp %s: %v\n", bootcamp.Id.Hex(), err)
+		}
+	}
+	fmt.Println("Data imported")
+}
+
+// remove every document (deleted or not) of the seeded collections
+func destroy(store models.Store) {
+	for _, f := range fixtures {
+		n, err := store.C(f.model).RemoveAll(nil)
+		if err != nil {
+			log.Fatalf("cannot destroy %s: %v\n", f.model, err)
+		}
+		fmt.Printf("Removed %d %s\n", n, strings.TrimSuffix(f.file, ".json"))
+	}
+	fmt.Println("Data destroyed")
+}
+
+// build a document of the given model from a fixture record, keeping its _id
+func newDocument(c models.Collection, modelName string, record map[string]interface{}) (mongodm.IDocumentBase, error) {
+	var doc mongodm.IDocumentBase
+	switch modelName {
+	case "Bootcamp":
+		doc = &models.Bootcamp{Photo: "no-photo.jpg"}
+	case "Course":
+		doc = &models.Course{}
+	case "Review":
+		doc = &models.Review{}
+	case "User":
+		doc = &models.User{}
+	default:
+		return nil, fmt.Errorf("unknown model %s", modelName)
+	}
+	c.Init(doc)
+
+	id, ok := record["_id"].(string)
+	if !ok || !bson.IsObjectIdHex(id) {
+		return nil, fmt.Errorf("invalid _id %v", record["_id"])
+	}
+	delete(record, "_id")
+
+	// the fixtures were written for the nodejs version, coerce them to the go models
+	if v, ok := record["rating"].(string); ok {
+		rating, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rating %q", v)
+		}
+		record["rating"] = rating
+	}
+	if v, ok := record["scholarhipsAvailable"]; ok {
+		record["scholarshipAvailable"] = v
+		delete(record, "scholarhipsAvailable")
+	}
+
+	err, _ := doc.Update(record)
+	if err != nil {
+		return nil, err
+	}
+	doc.SetId(bson.ObjectIdHex(id))
+
+	// store relations as object ids
+	switch d := doc.(type) {
+	case *models.Bootcamp:
+		d.User, err = toObjectId(d.User)
+		if err == nil {
+			d.Slug = utils.Slugify(d.Name)
+		}
+	case *models.Course:
+		if d.Bootcamp, err = toObjectId(d.Bootcamp); err == nil {
+			d.User, err = toObjectId(d.User)
+		}
+	case *models.Review:
+		if d.Bootcamp, err = toObjectId(d.Bootcamp); err == nil {
+			d.User, err = toObjectId(d.User)
+		}
+	}
+	return doc, err
+}
+
+func toObjectId(v interface{}) (bson.ObjectId, error) {
+	s, ok := v.(string)
+	if !ok || !bson.IsObjectIdHex(s) {
+		return "", fmt.Errorf("invalid object id %v", v)
+	}
+	return bson.ObjectIdHex(s), nil
+}
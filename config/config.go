@@ -0,0 +1,324 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runtime settings of the app, loaded once at boot with Load
+type Config struct {
+	// public url of the app, used in the links sent by email
+	Scheme string
+	Host   string
+	Port   int
+	// use X-Forwarded-For as client ip, only behind a trusted proxy
+	TrustProxy bool
+
+	// where the documents are kept, mongo or memory (lost on restart)
+	Store    string
+	MongoURI string
+	MongoDB  string
+
+	JWTSecret          string
+	JWTExpire          time.Duration
+	RefreshTokenExpire time.Duration
+	TOTPIssuer         string
+	// where failed login attempts are kept, mongo or memory
+	LoginAttemptStore string
+	// promoted to admin on start when there is no admin
+	AdminEmail string
+	// how long the deleted documents are kept before they are purged
+	TrashRetention time.Duration
+
+	// offline or mapquest
+	GeocoderProvider string
+	GeocoderURL      string
+	GeocoderAPIKey   string
+
+	// local or s3
+	StorageDriver string
+	MaxFileUpload int64
+	S3            S3Config
+
+	SMTP SMTPConfig
+}
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PublicURL string
+}
+
+type SMTPConfig struct {
+	Host      string
+	Port      int
+	Email     string
+	Password  string
+	FromEmail string
+	FromName  string
+}
+
+// a setting is read from the variable key of the environment or config file,
+// and from the flag of the same name in lower case with dashes (e.g. JWT_SECRET and -jwt-secret)
+type setting struct {
+	key   string
+	def   string
+	usage string
+}
+
+var settings = []setting{
+	{"SCHEME", "http", "scheme of the public url"},
+	{"HOST", "localhost:8080", "host of the public url"},
+	{"PORT", "8080", "port to listen on"},
+	{"TRUST_PROXY", "false", "use X-Forwarded-For as client ip"},
+	{"STORE", "mongo", "store of the documents: mongo or memory"},
+	{"MONGO_URI", "localhost:27017", "mongodb host"},
+	{"MONGO_DB", "devcamper", "mongodb database"},
+	{"JWT_SECRET", "", "secret signing the tokens (required)"},
+	{"JWT_EXPIRE", "10", "access token lifetime in minutes"},
+	{"REFRESH_TOKEN_EXPIRE", "30", "refresh token lifetime in days"},
+	{"TOTP_ISSUER", "DevCamper", "name shown in authenticator apps"},
+	{"LOGIN_ATTEMPT_STORE", "mongo", "store of failed login attempts: mongo or memory"},
+	{"ADMIN_EMAIL", "", "email promoted to admin on start when there is no admin"},
+	{"TRASH_RETENTION", "30", "days the deleted documents are kept before they are purged"},
+	{"GEOCODER_PROVIDER", "offline", "geocoder: offline or mapquest"},
+	{"GEOCODER_URL", "", "mapquest api url"},
+	{"GEOCODER_API_KEY", "", "mapquest api key"},
+	{"STORAGE_DRIVER", "local", "photo storage: local or s3"},
+	{"MAX_FILE_UPLOAD", "1000000", "max photo size in bytes"},
+	{"S3_ENDPOINT", "", "s3 endpoint"},
+	{"S3_REGION", "us-east-1", "s3 region"},
+	{"S3_BUCKET", "", "s3 bucket"},
+	{"S3_ACCESS_KEY", "", "s3 access key"},
+	{"S3_SECRET_KEY", "", "s3 secret key"},
+	{"S3_PUBLIC_URL", "", "url the bucket is served from"},
+	{"SMTP_HOST", "", "smtp host"},
+	{"SMTP_PORT", "587", "smtp port"},
+	{"SMTP_EMAIL", "", "smtp user"},
+	{"SMTP_PASSWORD", "", "smtp password"},
+	{"FROM_EMAIL", "noreply@devcamper.io", "sender of the emails"},
+	{"FROM_NAME", "devcamper", "sender name of the emails"},
+}
+
+// read the settings from the defaults, then the config file, the environment and the command-line flags,
+// a later source overrides an earlier one
+// the flags are added to fs so a command can define its own flags in the same set
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	file := fs.String("config", "./config/config.env", "optional file of KEY=VALUE settings")
+	flags := map[string]*string{}
+	for _, s := range settings {
+		flags[s.key] = fs.String(flagName(s.key), "", s.usage)
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, s := range settings {
+		values[s.key] = s.def
+	}
+	fileValues, err := readEnvFile(*file)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range settings {
+		if v, ok := fileValues[s.key]; ok {
+			values[s.key] = v
+		}
+		if v, ok := os.LookupEnv(s.key); ok {
+			values[s.key] = v
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if flagName(s.key) == f.Name {
+				values[s.key] = *flags[s.key]
+			}
+		}
+	})
+
+	c, err := parse(values)
+	if err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
+
+// check the settings can be used, every problem is reported at once
+func (c *Config) Validate() error {
+	var problems []string
+	if c.JWTSecret == "" {
+		problems = append(problems, "JWT_SECRET is required")
+	} else if len(c.JWTSecret) < 32 {
+		problems = append(problems, "JWT_SECRET should be at least 32 characters")
+	}
+	if c.Scheme != "http" && c.Scheme != "https" {
+		problems = append(problems, "SCHEME should be http or https")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		problems = append(problems, "PORT should be between 1 and 65535")
+	}
+	if c.Store != "mongo" && c.Store != "memory" {
+		problems = append(problems, "STORE should be mongo or memory")
+	}
+	if c.MongoURI == "" || c.MongoDB == "" {
+		problems = append(problems, "MONGO_URI and MONGO_DB are required")
+	}
+	if c.JWTExpire <= 0 || c.RefreshTokenExpire <= 0 {
+		problems = append(problems, "JWT_EXPIRE and REFRESH_TOKEN_EXPIRE should be positive")
+	}
+	if c.LoginAttemptStore != "mongo" && c.LoginAttemptStore != "memory" {
+		problems = append(problems, "LOGIN_ATTEMPT_STORE should be mongo or memory")
+	}
+	switch c.GeocoderProvider {
+	case "offline":
+	case "mapquest":
+		if c.GeocoderURL == "" || c.GeocoderAPIKey == "" {
+			problems = append(problems, "GEOCODER_URL and GEOCODER_API_KEY are required by mapquest")
+		}
+	default:
+		problems = append(problems, "GEOCODER_PROVIDER should be offline or mapquest")
+	}
+	switch c.StorageDriver {
+	case "local":
+	case "s3":
+		if c.S3.Endpoint == "" || c.S3.Bucket == "" {
+			problems = append(problems, "S3_ENDPOINT and S3_BUCKET are required by s3 storage")
+		}
+	default:
+		problems = append(problems, "STORAGE_DRIVER should be local or s3")
+	}
+	if c.MaxFileUpload <= 0 {
+		problems = append(problems, "MAX_FILE_UPLOAD should be positive")
+	}
+	if c.TrashRetention <= 0 {
+		problems = append(problems, "TRASH_RETENTION should be positive")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// address the server listens on
+func (c *Config) Addr() string {
+	return fmt.Sprint(":", c.Port)
+}
+
+func parse(values map[string]string) (*Config, error) {
+	p := parser{values: values}
+	c := &Config{
+		Scheme:             values["SCHEME"],
+		Host:               values["HOST"],
+		Port:               p.int("PORT"),
+		TrustProxy:         p.bool("TRUST_PROXY"),
+		Store:              values["STORE"],
+		MongoURI:           values["MONGO_URI"],
+		MongoDB:            values["MONGO_DB"],
+		JWTSecret:          values["JWT_SECRET"],
+		JWTExpire:          time.Minute * time.Duration(p.int("JWT_EXPIRE")),
+		RefreshTokenExpire: time.Hour * 24 * time.Duration(p.int("REFRESH_TOKEN_EXPIRE")),
+		TOTPIssuer:         values["TOTP_ISSUER"],
+		LoginAttemptStore:  values["LOGIN_ATTEMPT_STORE"],
+		AdminEmail:         values["ADMIN_EMAIL"],
+		TrashRetention:     time.Hour * 24 * time.Duration(p.int("TRASH_RETENTION")),
+		GeocoderProvider:   values["GEOCODER_PROVIDER"],
+		GeocoderURL:        values["GEOCODER_URL"],
+		GeocoderAPIKey:     values["GEOCODER_API_KEY"],
+		StorageDriver:      values["STORAGE_DRIVER"],
+		MaxFileUpload:      int64(p.int("MAX_FILE_UPLOAD")),
+		S3: S3Config{
+			Endpoint:  values["S3_ENDPOINT"],
+			Region:    values["S3_REGION"],
+			Bucket:    values["S3_BUCKET"],
+			AccessKey: values["S3_ACCESS_KEY"],
+			SecretKey: values["S3_SECRET_KEY"],
+			PublicURL: values["S3_PUBLIC_URL"],
+		},
+		SMTP: SMTPConfig{
+			Host:      values["SMTP_HOST"],
+			Port:      p.int("SMTP_PORT"),
+			Email:     values["SMTP_EMAIL"],
+			Password:  values["SMTP_PASSWORD"],
+			FromEmail: values["FROM_EMAIL"],
+			FromName:  values["FROM_NAME"],
+		},
+	}
+	if len(p.problems) > 0 {
+		return nil, fmt.Errorf("invalid config: %s", strings.Join(p.problems, ", "))
+	}
+	return c, nil
+}
+
+// convert the settings and collect the values that cannot be converted
+type parser struct {
+	values   map[string]string
+	problems []string
+}
+
+func (p *parser) int(key string) int {
+	n, err := strconv.Atoi(p.values[key])
+	if err != nil {
+		p.problems = append(p.problems, fmt.Sprintf("%s should be a number", key))
+	}
+	return n
+}
+
+func (p *parser) bool(key string) bool {
+	b, err := strconv.ParseBool(p.values[key])
+	if err != nil {
+		p.problems = append(p.problems, fmt.Sprintf("%s should be true or false", key))
+	}
+	return b
+}
+
+// read a file of KEY=VALUE lines, the shell syntax of config.env.env is accepted
+// (export prefix, quotes and trailing # comments), a missing file is ignored
+func readEnvFile(path string) (map[string]string, error) {
+	values := map[string]string{}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return values, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot open config file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if j := strings.Index(value, "#"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+		values[strings.TrimSpace(line[:i])] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+	return values, nil
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
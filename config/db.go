@@ -10,7 +10,7 @@ import (
 	"github.com/zebresel-com/mongodm"
 )
 
-func ConnDB() *mongodm.Connection {
+func ConnDB(c *Config) *mongodm.Connection {
 
 	// Load prompt text when validate data before save fail
 	file, err := ioutil.ReadFile("./config/locals.json")
@@ -21,10 +21,10 @@ func ConnDB() *mongodm.Connection {
 	var localMap map[string]map[string]string
 
 	json.Unmarshal(file, &localMap)
-	uri := os.Getenv("MONGO_URI")
+	uri := c.MongoURI
 	dbConfig := &mongodm.Config{
 		DatabaseHosts: []string{uri},
-		DatabaseName:  os.Getenv("MONGO_DB"),
+		DatabaseName:  c.MongoDB,
 
 		// Mount validation prompt text
 		Locals: localMap["en-US"],
@@ -1,28 +1,32 @@
 package controllers
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
+	"devcamper/config"
+	"devcamper/middleware"
 	"devcamper/models"
 	"devcamper/utils"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/zebresel-com/mongodm"
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 type User struct {
-	connection *mongodm.Connection
+	users    models.UserRepo
+	sessions models.SessionRepo
+	policies models.RolePolicyRepo
+	config   *config.Config
+	tokens   *utils.JWT
+	mailer   *utils.Mailer
+	// failed login attempts, see lockout.go
+	attempts utils.AttemptTracker
 }
 
 type LoginDetails struct {
@@ -48,9 +52,23 @@ type ResetPassword struct {
 	Pwd string `json:"password"`
 }
 
-func NewUser(conn *mongodm.Connection) *User {
+type RefreshToken struct {
+	Token string `json:"refreshToken"`
+}
+
+type AssignRole struct {
+	Role string `json:"role"`
+}
+
+func NewUser(users models.UserRepo, sessions models.SessionRepo, policies models.RolePolicyRepo, c *config.Config, attempts utils.AttemptTracker) *User {
 	return &User{
-		connection: conn,
+		users:    users,
+		sessions: sessions,
+		policies: policies,
+		config:   c,
+		tokens:   utils.NewJWT(c.JWTSecret, c.JWTExpire),
+		mailer:   utils.NewMailer(c.SMTP.Host, c.SMTP.Port, c.SMTP.Email, c.SMTP.Password, c.SMTP.FromEmail, c.SMTP.FromName),
+		attempts: attempts,
 	}
 }
 
@@ -58,9 +76,7 @@ func NewUser(conn *mongodm.Connection) *User {
 // @route   POST /api/v1/auth/register
 // @access  Public
 func (u *User) Register(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	User := u.connection.Model("User")
-	user := &models.User{}
-	User.New(user)
+	user := u.users.New()
 
 	err := json.NewDecoder(r.Body).Decode(user)
 	if err != nil {
@@ -68,13 +84,19 @@ func (u *User) Register(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		return
 	}
 
+	// admin role is assigned by an admin only
+	if !models.CanRegisterAs(user.Role) {
+		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot register with %s role", user.Role))
+		return
+	}
+
 	if valid, issues := user.ValidateCreate(); !valid {
 		utils.ErrorResponse(w, http.StatusBadRequest, issues...)
 		return
 	}
 
 	// check if the email is unique (the email of deleted user should not be reuse for resore account feature)
-	if n, _ := User.Find(bson.M{"email": user.Email}).Count(); n > 0 {
+	if n, _ := u.users.Count(bson.M{"email": user.Email}); n > 0 {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("email %s was taken, please use the new one", user.Email))
 		return
 	}
@@ -84,12 +106,21 @@ func (u *User) Register(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		utils.ErrorResponse(w, http.StatusBadRequest, err)
 		return
 	}
-	err = user.Save()
+	// the email is verified by the link sent below
+	user.EmailVerified = false
+	user.TwoFactor = models.TwoFactor{}
+	user.Quotas = nil
+	token := user.GenVerifyEmailToken(u.config.JWTSecret)
+	err = u.users.Save(user)
 	if err != nil {
 		utils.ErrorHandler(w, err)
 		return
 	}
-	sendToken(w, user)
+	// the user can ask for a new link, so the registration is not failed
+	if !u.sendVerifyEmail(user.Email, token) {
+		log.Printf("cannot send verification email to %s\n", user.Email)
+	}
+	u.sendToken(w, r, user)
 }
 
 // @desc    Login user
@@ -109,11 +140,19 @@ func (u *User) Login(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 		return
 	}
 
-	User := u.connection.Model("User")
-	user := &models.User{}
+	// slow down password guessing
+	keys := u.loginAttemptKeys(r, loginDetails.Email)
+	if u.tooManyAttempts(w, keys...) {
+		return
+	}
 
-	err = User.FindOne(bson.M{"email": loginDetails.Email}).Exec(user)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	query := bson.M{
+		"email":   loginDetails.Email,
+		"deleted": false,
+	}
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
+		u.attemptFailed(keys...)
 		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid email or password"))
 		return
 	} else if err != nil {
@@ -122,17 +161,56 @@ func (u *User) Login(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 	}
 
 	if !user.MatchPassword(loginDetails.Password) {
+		u.attemptFailed(keys...)
 		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid email or password"))
 		return
 	}
 
-	sendToken(w, user)
+	// second step with the code, see LoginTwoFactor, the failures are counted until it succeeds
+	if user.TwoFactor.Enabled {
+		challenge, err := u.tokens.SignChallenge(user.Id.Hex())
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
+			return
+		}
+		utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+			"success":           true,
+			"twoFactorRequired": true,
+			"challengeToken":    challenge,
+		})
+		return
+	}
+
+	u.attemptSucceeded(keys[0])
+	u.sendToken(w, r, user)
 }
 
-// @desc    Log user out / clear cookie
+// @desc    Log user out / revoke session and clear cookie
 // @route   GET /api/v1/auth/logout
 // @access  Private
 func (u *User) Logout(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// revoke the session of the refresh token, or of the access token
+	var session *models.Session
+	var err error
+	if refreshToken := getRefreshToken(r); len(refreshToken) > 0 {
+		hash, _ := models.HashToken(u.config.JWTSecret, refreshToken)
+		session, err = u.sessions.FindOne(bson.M{"tokenHash": hash})
+	} else if c, cErr := r.Cookie("token"); cErr == nil {
+		session, err = u.findTokenSession(c.Value)
+	} else if auth := strings.Fields(r.Header.Get("Authorization")); len(auth) == 2 && auth[0] == "Bearer" {
+		session, err = u.findTokenSession(auth[1])
+	} else {
+		err = models.ErrSessionNotFound
+	}
+	if err == nil && !session.Revoked {
+		session.Revoke()
+		err = u.sessions.Save(session)
+		if err != nil {
+			utils.ErrorHandler(w, err)
+			return
+		}
+	}
+
 	token := "none"
 	// delete cookie
 	http.SetCookie(w, &http.Cookie{
@@ -141,21 +219,169 @@ func (u *User) Logout(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 		HttpOnly: true,
 		MaxAge:   -1,
 	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refreshToken",
+		Value:    token,
+		Path:     "/api/v1/auth",
+		HttpOnly: true,
+		MaxAge:   -1,
+	})
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"token":   token,
 	})
 }
 
+// find the session of an access token, expired token is accepted as it only identifies the session
+func (u *User) findTokenSession(token string) (*models.Session, error) {
+	payload, err := u.tokens.ParseExpired(token)
+	if err != nil {
+		return nil, err
+	}
+	if !bson.IsObjectIdHex(payload.SessionId) {
+		return nil, models.ErrSessionNotFound
+	}
+	return u.sessions.FindId(bson.ObjectIdHex(payload.SessionId))
+}
+
+// @desc    Get new access token using refresh token
+// @route   POST /api/v1/auth/refresh
+// @access  Public
+func (u *User) Refresh(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	refreshToken := getRefreshToken(r)
+	if len(refreshToken) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide refresh token"))
+		return
+	}
+	hash, err := models.HashToken(u.config.JWTSecret, refreshToken)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid refresh token"))
+		return
+	}
+
+	// swap to a new refresh token
+	rotated := &models.Session{}
+	newToken := rotated.GenRefreshToken(u.config.JWTSecret)
+	session, err := u.sessions.Rotate(hash, rotated.TokenHash)
+	if err == models.ErrSessionNotFound {
+		// a rotated token is presented again, someone else has a copy, revoke the whole session
+		reused, err := u.sessions.FindOne(bson.M{"previousHashes": hash})
+		if err == nil && !reused.Revoked {
+			reused.Revoke()
+			u.sessions.Save(reused)
+			log.Printf("refresh token reuse detected on session %s\n", reused.Id.Hex())
+			utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("refresh token was already used, please login again"))
+			return
+		}
+		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid refresh token"))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// make sure the user still exists
+	query := bson.M{
+		"_id":     session.User,
+		"deleted": false,
+	}
+	if n, _ := u.users.Count(query); n == 0 {
+		session.Revoke()
+		u.sessions.Save(session)
+		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid refresh token"))
+		return
+	}
+
+	u.sendSessionToken(w, http.StatusOK, session.User.(bson.ObjectId), session, newToken)
+}
+
+// @desc    Get active sessions of current user
+// @route   GET /api/v1/auth/sessions
+// @access  Private
+func (u *User) GetSessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := middleware.CurrentUser(r)
+	current := middleware.CurrentSession(r)
+
+	query := bson.M{
+		"user":    user.Id,
+		"revoked": false,
+		"deleted": false,
+		"expiredAt": bson.M{
+			"$gt": time.Now(),
+		},
+	}
+	sessions, err := u.sessions.Find(models.Query{
+		Filter: query,
+		Sort:   []string{"-lastUsedAt"},
+	})
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	data := make([]map[string]interface{}, len(sessions))
+	for i, v := range sessions {
+		data[i] = map[string]interface{}{
+			"id":         v.Id,
+			"userAgent":  v.UserAgent,
+			"ip":         v.IP,
+			"createdAt":  v.CreatedAt,
+			"lastUsedAt": v.LastUsedAt,
+			"expiredAt":  v.ExpiredAt,
+			"current":    current != nil && v.Id == current.Id,
+		}
+	}
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"count":   len(data),
+		"data":    data,
+	})
+}
+
+// @desc    Revoke session of current user
+// @route   DELETE /api/v1/auth/sessions/:id
+// @access  Private
+func (u *User) RevokeSession(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := middleware.CurrentUser(r)
+
+	id := ps.ByName("id")
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid session id format"))
+		return
+	}
+
+	query := bson.M{
+		"_id":     bson.ObjectIdHex(id),
+		"user":    user.Id,
+		"revoked": false,
+	}
+	session, err := u.sessions.FindOne(query)
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no active session with id of %s", id))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	session.Revoke()
+	err = u.sessions.Save(session)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    nil,
+	})
+}
+
 // @desc    Get current logged in user
 // @route   GET /api/v1/auth/me
 // @access  Private
 func (u *User) GetMe(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	user := getCurrentUser(u.connection, r)
-	if user == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
+	user := middleware.CurrentUser(r)
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"data":    user,
@@ -166,17 +392,15 @@ func (u *User) GetMe(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 // @route   PUT /api/v1/auth/updatedetails
 // @access  Private
 func (u *User) UpdateDetails(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	user := getCurrentUser(u.connection, r)
-	if user == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
+	user := middleware.CurrentUser(r)
 
 	updateDetails := UpdateDetails{}
 	json.NewDecoder(r.Body).Decode(&updateDetails)
 
-	if len(updateDetails.Email) > 0 {
+	emailChanged := false
+	if len(updateDetails.Email) > 0 && updateDetails.Email != user.Email {
 		user.Email = updateDetails.Email
+		emailChanged = true
 	}
 	if len(updateDetails.Name) > 0 {
 		user.Name = updateDetails.Name
@@ -187,7 +411,6 @@ func (u *User) UpdateDetails(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 
-	User := u.connection.Model("User")
 	// check if the email is unique (the email of deleted user should not be reuse for resore account feature)
 	query := bson.M{
 		"email": user.Email,
@@ -195,15 +418,24 @@ func (u *User) UpdateDetails(w http.ResponseWriter, r *http.Request, ps httprout
 			"$ne": user.Id,
 		},
 	}
-	if n, _ := User.Find(query).Count(); n > 0 {
+	if n, _ := u.users.Count(query); n > 0 {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("email %s was taken, please use the new one", user.Email))
 		return
 	}
-	err := user.Save()
+	// the new email has to be verified again
+	var token string
+	if emailChanged {
+		user.EmailVerified = false
+		token = user.GenVerifyEmailToken(u.config.JWTSecret)
+	}
+	err := u.users.Save(user)
 	if err != nil {
 		utils.ErrorHandler(w, err)
 		return
 	}
+	if emailChanged && !u.sendVerifyEmail(user.Email, token) {
+		log.Printf("cannot send verification email to %s\n", user.Email)
+	}
 
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -215,11 +447,7 @@ func (u *User) UpdateDetails(w http.ResponseWriter, r *http.Request, ps httprout
 // @route   PUT /api/v1/auth/updatepassword
 // @access  Private
 func (u *User) UpdatePassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	user := getCurrentUser(u.connection, r)
-	if user == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
+	user := middleware.CurrentUser(r)
 	updatePwd := UpdatePassword{}
 	json.NewDecoder(r.Body).Decode(&updatePwd)
 	// check if the data is provided
@@ -241,7 +469,18 @@ func (u *User) UpdatePassword(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	err = user.Save()
+	err = u.users.Save(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// log out every other device
+	var current bson.ObjectId
+	if session := middleware.CurrentSession(r); session != nil {
+		current = session.Id
+	}
+	err = u.sessions.RevokeAll(user.Id, current)
 	if err != nil {
 		utils.ErrorHandler(w, err)
 		return
@@ -265,64 +504,143 @@ func (u *User) ForgotPassword(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	User := u.connection.Model("User")
-	user := &models.User{}
+	// every request sends an email, so each one counts as an attempt
+	keys := u.forgotAttemptKeys(r, forgotPwd.Email)
+	if u.tooManyAttempts(w, keys...) {
+		return
+	}
+	u.attemptFailed(keys...)
+
+	// the response is the same whether the email exists or not
+	resp := map[string]interface{}{
+		"success": true,
+		"data":    fmt.Sprintf("if an account with email %s exists, the reset password url was sent to it", forgotPwd.Email),
+	}
 
 	query := bson.M{
 		"email":   forgotPwd.Email,
 		"deleted": false,
 	}
-	err := User.FindOne(query).Exec(user)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
-		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("no user with email %s", forgotPwd.Email))
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
+		utils.SendJSON(w, http.StatusOK, resp)
 		return
 	} else if err != nil {
 		utils.ErrorHandler(w, err)
 		return
 	}
-	token := user.GenResetPwdToken()
-	err = user.Save()
+	token := user.GenResetPwdToken(u.config.JWTSecret)
+	err = u.users.Save(user)
 	if err != nil {
 		utils.ErrorHandler(w, err)
+		return
 	}
 
 	resetPwdURL := url.URL{
-		Scheme: os.Getenv("SCHEME"),
-		Host:   os.Getenv("HOST"),
+		Scheme: u.config.Scheme,
+		Host:   u.config.Host,
 		Path:   fmt.Sprintf("/api/v1/auth/resetpassword/%s", token),
 	}
 
 	msg := "You are receiving this email because you (or someone else) has requested the reste of a password. Please make a PUT request to:" + "\r\n" + resetPwdURL.String()
-	if !utils.SendMail(forgotPwd.Email, "Reset password", msg) {
+	if !u.mailer.SendMail(user.Email, "Reset password", msg) {
+		// an error would tell the email exists
+		log.Printf("cannot send reset password email to %s\n", user.Email)
+	}
+	utils.SendJSON(w, http.StatusOK, resp)
+}
+
+// @desc    Verify email
+// @route   GET /api/v1/auth/verifyemail/:token
+// @access  Public
+func (u *User) VerifyEmail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	token := ps.ByName("token")
+	x, err := models.HashToken(u.config.JWTSecret, token)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid token"))
+		return
+	}
+
+	query := bson.M{
+		"verifyEmailToken": x,
+		"verifyEmailExpired": bson.M{
+			"$gt": time.Now(),
+		},
+		"deleted": false,
+	}
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("your token is invalid or expired"))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// the token is used only once
+	user.EmailVerified = true
+	user.VerifyEmailToken = ""
+	user.VerifyEmailExpired = time.Time{}
+	err = u.users.Save(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    user,
+	})
+}
+
+// @desc    Resend verification email
+// @route   POST /api/v1/auth/verifyemail
+// @access  Private
+func (u *User) ResendVerifyEmail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := middleware.CurrentUser(r)
+	if user.EmailVerified {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("your email is already verified"))
+		return
+	}
+
+	token := user.GenVerifyEmailToken(u.config.JWTSecret)
+	err := u.users.Save(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	if !u.sendVerifyEmail(user.Email, token) {
 		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
 		return
 	}
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
-		"sueecee": true,
-		"data":    fmt.Sprintf("the reset password url was sent to email %s", forgotPwd.Email),
+		"success": true,
+		"data":    fmt.Sprintf("the verification url was sent to email %s", user.Email),
 	})
 }
 
+func (u *User) sendVerifyEmail(email string, token string) bool {
+	verifyURL := url.URL{
+		Scheme: u.config.Scheme,
+		Host:   u.config.Host,
+		Path:   fmt.Sprintf("/api/v1/auth/verifyemail/%s", token),
+	}
+
+	msg := "Please confirm your email address by making a GET request to:" + "\r\n" + verifyURL.String()
+	return u.mailer.SendMail(email, "Verify email", msg)
+}
+
 // @desc    Reset password
 // @route   PUT /api/v1/auth/resetpassword/:token
 // @access  Public
 func (u *User) ResetPassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	token := ps.ByName("token")
-	h := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
-	bs, err := hex.DecodeString(token)
-	if err != nil {
-		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
-		return
-	}
-	_, err = h.Write(bs)
+	x, err := models.HashToken(u.config.JWTSecret, token)
 	if err != nil {
-		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("your token is expired"))
 		return
 	}
-	x := fmt.Sprintf("%x", h.Sum(nil))
-
-	User := u.connection.Model("User")
-	user := &models.User{}
 
 	query := bson.M{
 		"resetPasswordToken": x,
@@ -331,8 +649,8 @@ func (u *User) ResetPassword(w http.ResponseWriter, r *http.Request, ps httprout
 		},
 		"deleted": false,
 	}
-	err = User.FindOne(query).Exec(user)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("your token is expired"))
 		return
 	} else if err != nil {
@@ -354,30 +672,45 @@ func (u *User) ResetPassword(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 
-	err = user.Save()
+	// the token is used only once
+	user.ResetPasswordToken = ""
+	user.ResetPasswordExpired = time.Time{}
+	err = u.users.Save(user)
 	if err != nil {
 		utils.ErrorHandler(w, err)
+		return
 	}
-	// remove field resetPasswordToken and resetPasswordExpired from DB
-	change := mgo.Change{
-		Update: bson.M{
-			//TO DO
-			"$unset": bson.M{
-				"resetPasswordToken":   "",
-				"resetPasswordExpired": "",
-			},
-		},
+	// the password may be leaked, log out every device
+	err = u.sessions.RevokeAll(user.Id, "")
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
 	}
-	u.connection.Session.DB(os.Getenv("MONGO_DB")).C("users").FindId(user.Id).Apply(change, user)
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"data":    user,
 	})
 }
 
-func sendToken(w http.ResponseWriter, user *models.User) {
+// create a login session then send access token and refresh token via cookie
+func (u *User) sendToken(w http.ResponseWriter, r *http.Request, user *models.User) {
+	session := u.sessions.New()
+	session.User = user.Id
+	session.UserAgent = r.UserAgent()
+	session.IP = utils.ClientIP(r, u.config.TrustProxy)
+	session.ExpiredAt = time.Now().Add(u.config.RefreshTokenExpire)
+	refreshToken := session.GenRefreshToken(u.config.JWTSecret)
+	err := u.sessions.Save(session)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	u.sendSessionToken(w, http.StatusCreated, user.Id, session, refreshToken)
+}
+
+func (u *User) sendSessionToken(w http.ResponseWriter, status int, userId bson.ObjectId, session *models.Session, refreshToken string) {
 	// send jwt via cookie
-	ss, err := utils.GetJwt(user.Id.Hex())
+	ss, err := u.tokens.Sign(userId.Hex(), session.Id.Hex())
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
 		return
@@ -387,45 +720,30 @@ func sendToken(w http.ResponseWriter, user *models.User) {
 		Value:    ss,
 		HttpOnly: true,
 	})
-	utils.SendJSON(w, http.StatusCreated, map[string]interface{}{
-		"success": true,
-		"token":   ss,
+	// refresh token is sent only to the auth routes
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refreshToken",
+		Value:    refreshToken,
+		Path:     "/api/v1/auth",
+		Expires:  session.ExpiredAt,
+		HttpOnly: true,
+	})
+	utils.SendJSON(w, status, map[string]interface{}{
+		"success":      true,
+		"token":        ss,
+		"refreshToken": refreshToken,
 	})
 }
 
-func getCurrentUser(conn *mongodm.Connection, r *http.Request) *models.User {
-	var token string
-	// grab token from header
-	if auth := strings.Split(r.Header.Get("Authorization"), " "); auth[0] == "Bearer" {
-		token = auth[1]
-		// grab token from cookie
-	} else if c, err := r.Cookie("token"); err == nil {
-		token = c.Value
-	}
-
-	// no token
-	if len(token) == 0 {
-		return nil
-	}
-
-	// validate token
-	payload, err := utils.ParseJwt(token)
-	if err != nil {
-		return nil
-	}
-
-	// find user
-	userId := payload.(*utils.Payload).Id
-	User := conn.Model("User")
-	user := &models.User{}
-
-	query := bson.M{
-		"_id":     bson.ObjectIdHex(userId),
-		"deleted": false,
+// grab refresh token from body or cookie
+func getRefreshToken(r *http.Request) string {
+	refresh := RefreshToken{}
+	json.NewDecoder(r.Body).Decode(&refresh)
+	if len(refresh.Token) > 0 {
+		return refresh.Token
 	}
-	err = User.FindOne(query).Exec(user)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
-		return nil
+	if c, err := r.Cookie("refreshToken"); err == nil {
+		return c.Value
 	}
-	return user
+	return ""
 }
@@ -1,28 +1,50 @@
 package controllers
 
 import (
+	"devcamper/config"
+	"devcamper/middleware"
 	"devcamper/models"
 	"devcamper/utils"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
-	"strings"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/zebresel-com/mongodm"
 	"gopkg.in/mgo.v2/bson"
 )
 
 type Bootcamp struct {
-	connection *mongodm.Connection
+	bootcamps models.BootcampRepo
+	orgs      models.OrganizationRepo
+	zipcodes  utils.ZipcodeLookup
+	geocoder  utils.Geocoder
+	storage   utils.Storage
+	config    *config.Config
 }
 
-func NewBootcamp(conn *mongodm.Connection) *Bootcamp {
+// accepted photo types and their file extension
+var photoTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func NewBootcamp(bootcamps models.BootcampRepo, orgs models.OrganizationRepo, c *config.Config, zipcodes utils.ZipcodeLookup, geocoder utils.Geocoder, storage utils.Storage) *Bootcamp {
 	return &Bootcamp{
-		connection: conn,
+		bootcamps: bootcamps,
+		orgs:      orgs,
+		zipcodes:  zipcodes,
+		geocoder:  geocoder,
+		storage:   storage,
+		config:    c,
 	}
 }
 
@@ -38,38 +60,33 @@ func (bc *Bootcamp) GetBootcamps(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// create advance query
-	query, pagination, err := models.AdvanceQuery(r.Form, bc.connection.Model("Bootcamp"))
+	query, pagination, err := models.AdvanceQuery(r.Form, bc.bootcamps)
 	if err != nil {
-		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad request data"))
+		utils.ErrorHandler(w, err)
 		return
 	}
 
 	// execute query
-	bootcamps := []*models.Bootcamp{}
-	err = query.Exec(&bootcamps)
+	bootcamps, err := bc.bootcamps.Find(query)
 	if err != nil {
 		log.Println(err)
 		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
 		return
 	}
 
-	// grab courses for each bootcamp (virtual field)
-	Course := bc.connection.Model("Course")
-	for _, bootcamp := range bootcamps {
-		courses := []*models.Course{}
-		query := bson.M{
-			"bootcamp": bootcamp.Id,
-			"deleted":  false,
-		}
-		err := Course.Find(query).Exec(&courses)
-		if err != nil {
-			continue
-		}
-		tmp := []interface{}{}
-		for _, v := range courses {
-			tmp = append(tmp, v)
-		}
-		bootcamp.Courses = tmp
+	// cursors and links of the next and previous pages
+	err = pagination.Cursors(bootcamps)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	pagination.SetHeaders(w, r.URL)
+
+	// related documents asked by expand
+	err = bc.bootcamps.Expand(bootcamps, r.Form)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
 	}
 
 	// prepare response data
@@ -79,14 +96,13 @@ func (bc *Bootcamp) GetBootcamps(w http.ResponseWriter, r *http.Request, ps http
 		"pagination": pagination,
 	}
 
-	// hide data that user not request
-	selectField := r.Form["select"]
-	if len(selectField) != 0 {
-		selects := strings.Split(selectField[0], ",")
-		respData["data"] = models.ExtractSelectField(bootcamps, selects)
-	} else {
-		respData["data"] = bootcamps
+	// only the fields asked by select
+	data, err := query.Project(bootcamps)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
 	}
+	respData["data"] = data
 
 	utils.SendJSON(w, http.StatusOK, respData)
 }
@@ -95,25 +111,69 @@ func (bc *Bootcamp) GetBootcamps(w http.ResponseWriter, r *http.Request, ps http
 // @route   GET /api/v1/bootcamps/:id
 // @access  Public
 func (bc *Bootcamp) GetBootcamp(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	Bootcamp := bc.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
-
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid bootcamp id format"))
 		return
 	}
-	err := Bootcamp.FindId(bson.ObjectIdHex(id)).Exec(bootcamp)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	bootcamp, err := bc.bootcamps.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("not found bootcamp with id of %s", id))
 		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
 	} else if bootcamp.Deleted {
 		utils.ErrorResponse(w, http.StatusNotFound, errors.New("this bootcamp was deleted"))
 		return
+	}
+	// related documents asked by expand
+	err = bc.bootcamps.Expand(bootcamp, r.URL.Query())
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    bootcamp,
+	})
+}
+
+// @desc    Get single bootcamp by slug
+// @route   GET /api/v1/bootcamps/slug/:slug
+// @access  Public
+func (bc *Bootcamp) GetBootcampBySlug(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	slug := ps.ByName("slug")
+	bootcamp, moved, err := bc.bootcamps.FindSlug(slug)
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("not found bootcamp with slug of %s", slug))
+		return
 	} else if err != nil {
 		utils.ErrorHandler(w, err)
 		return
+	} else if bootcamp.Deleted {
+		utils.ErrorResponse(w, http.StatusNotFound, errors.New("this bootcamp was deleted"))
+		return
 	}
+
+	// the bootcamp was renamed, the old links lead to its current slug
+	if moved {
+		target := url.URL{
+			Path:     path.Join(path.Dir(r.URL.Path), bootcamp.Slug),
+			RawQuery: r.URL.RawQuery,
+		}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+		return
+	}
+
+	// related documents asked by expand
+	err = bc.bootcamps.Expand(bootcamp, r.URL.Query())
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"data":    bootcamp,
@@ -124,21 +184,21 @@ func (bc *Bootcamp) GetBootcamp(w http.ResponseWriter, r *http.Request, ps httpr
 // @route   POST /api/v1/bootcamps
 // @access  Private
 func (bc *Bootcamp) CreateBootcamp(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(bc.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	cUser := middleware.CurrentUser(r)
+
+	// bootcamps the user can own, one for a publisher by default
+	n, err := bc.bootcamps.Count(bson.M{"user": cUser.Id, "deleted": false})
+	if err != nil {
+		utils.ErrorHandler(w, err)
 		return
 	}
-	if !cUser.IsUserInRoles("publisher", "admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
+	if err := cUser.CheckQuota(models.QuotaBootcamps, n); err != nil {
+		utils.ErrorHandler(w, err)
 		return
 	}
 
-	Bootcamp := bc.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
-
-	Bootcamp.New(bootcamp)
-	err := json.NewDecoder(r.Body).Decode(bootcamp)
+	bootcamp := bc.bootcamps.New()
+	err = json.NewDecoder(r.Body).Decode(bootcamp)
 	if err != nil {
 		log.Println("bad data")
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad data"))
@@ -146,29 +206,26 @@ func (bc *Bootcamp) CreateBootcamp(w http.ResponseWriter, r *http.Request, ps ht
 	}
 
 	bootcamp.User = cUser.Id
+	bootcamp.Organization = ""
 	if valid, issues := bootcamp.ValidateCreate(); !valid {
 		utils.ErrorResponse(w, http.StatusBadRequest, issues...)
 		return
 	}
 
-	loc := utils.GetLocation(bootcamp.Address)
-	tmp := loc.Results[0].Locations[0]
-	geo := &models.GeoJson{
-		Type:             "Point",
-		Coordinates:      []float64{tmp.LatLng.Lng, tmp.LatLng.Lat},
-		FormattedAddress: fmt.Sprintf("%s, %s, %s %s, %s", tmp.Street, tmp.City, tmp.State, tmp.Zipcode, tmp.Country),
-		Street:           tmp.Street,
-		City:             tmp.City,
-		State:            tmp.State,
-		Zipcode:          tmp.Zipcode,
-		Country:          tmp.Country,
+	err = bc.geocode(bootcamp)
+	if err == utils.ErrLocationNotFound {
+		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot find location of address %s", bootcamp.Address))
+		return
 	}
-	bootcamp.Location = geo
-	bootcamp.Address = ""
 	bootcamp.Photo = "no-photo.jpg"
-	bootcamp.Slug = strings.Join(strings.Split(strings.ToLower(bootcamp.Name), " "), "-")
+	bootcamp.Slug = ""
+	err = bc.bootcamps.SetSlug(bootcamp)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
 
-	err = bootcamp.Save()
+	err = bc.bootcamps.Save(bootcamp)
 	if err != nil {
 		utils.ErrorHandler(w, err)
 		return
@@ -185,15 +242,7 @@ func (bc *Bootcamp) CreateBootcamp(w http.ResponseWriter, r *http.Request, ps ht
 // @route   PUT /api/v1/bootcamps/:id
 // @access  Private
 func (bc *Bootcamp) UpdateBootcamp(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(bc.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("publisher", "admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
+	cUser := middleware.CurrentUser(r)
 
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
@@ -201,11 +250,8 @@ func (bc *Bootcamp) UpdateBootcamp(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
-	Bootcamp := bc.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
-
-	err := Bootcamp.FindId(bson.ObjectIdHex(id)).Exec(bootcamp)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	bootcamp, err := bc.bootcamps.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no bootcamp with id of %s", id))
 		return
 	} else if err != nil {
@@ -218,7 +264,7 @@ func (bc *Bootcamp) UpdateBootcamp(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
-	if bootcamp.User != cUser.Id && cUser.Role != "admin" {
+	if !canOnBootcamp(bc.orgs, cUser, models.BootcampUpdate, bootcamp) {
 		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
 		return
 	}
@@ -228,6 +274,14 @@ func (bc *Bootcamp) UpdateBootcamp(w http.ResponseWriter, r *http.Request, ps ht
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad data"))
 	}
+	// delete unexpected field
+	delete(d, "location")
+	delete(d, "geocodeStatus")
+	delete(d, "photo")
+	delete(d, "slug")
+	// the owner changes by a transfer only, the organization by its own route
+	delete(d, "user")
+	delete(d, "organization")
 
 	// The Update method is incompleted so the error is not handled
 	// see https://github.com/zebresel-com/mongodm/issues/20
@@ -238,7 +292,25 @@ func (bc *Bootcamp) UpdateBootcamp(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
-	err = bootcamp.Save()
+	// locate the new address
+	if _, ok := d["address"]; ok {
+		err = bc.geocode(bootcamp)
+		if err == utils.ErrLocationNotFound {
+			utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot find location of address %s", bootcamp.Address))
+			return
+		}
+	}
+
+	// a renamed bootcamp gets a new slug
+	if _, ok := d["name"]; ok {
+		err = bc.bootcamps.SetSlug(bootcamp)
+		if err != nil {
+			utils.ErrorHandler(w, err)
+			return
+		}
+	}
+
+	err = bc.bootcamps.Save(bootcamp)
 	if err != nil {
 		utils.ErrorHandler(w, err)
 		return
@@ -251,31 +323,74 @@ func (bc *Bootcamp) UpdateBootcamp(w http.ResponseWriter, r *http.Request, ps ht
 
 }
 
-// @desc    Delete bootcamp
-// @route   DELETE /api/v1/bootcamps/:id
+// @desc    Delete bootcamp with its courses and reviews, dryRun=true only reports them
+// @route   DELETE /api/v1/bootcamps/:id?dryRun=
 // @access  Private
 func (bc *Bootcamp) DeleteBootcamp(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(bc.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	cUser := middleware.CurrentUser(r)
+
+	id := ps.ByName("id")
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid bootcamp id format"))
+		return
+	}
+
+	dryRun := false
+	if s := r.URL.Query().Get("dryRun"); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, errors.New("dryRun must be true or false"))
+			return
+		}
+		dryRun = v
+	}
+
+	bootcamp, err := bc.bootcamps.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no bootcamp with id of %s", id))
+		return
+	} else if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
 		return
 	}
-	if !cUser.IsUserInRoles("publisher", "admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
+
+	if bootcamp.Deleted {
+		// should not found the deleted bootcamp
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no bootcamp with id of %s", id))
 		return
 	}
 
+	if !canOnBootcamp(bc.orgs, cUser, models.BootcampDelete, bootcamp) {
+		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
+		return
+	}
+
+	// the documents deleted
+	report, err := bc.bootcamps.SoftDelete(bootcamp, cUser.Id, dryRun)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// @desc    Upload photo for bootcamp
+// @route   PUT /api/v1/bootcamps/:id/photo
+// @access  Private
+func (bc *Bootcamp) UploadBootcampPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid bootcamp id format"))
 		return
 	}
 
-	Bootcamp := bc.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
-
-	err := Bootcamp.FindId(bson.ObjectIdHex(id)).Exec(bootcamp)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	bootcamp, err := bc.bootcamps.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no bootcamp with id of %s", id))
 		return
 	} else if err != nil {
@@ -284,55 +399,134 @@ func (bc *Bootcamp) DeleteBootcamp(w http.ResponseWriter, r *http.Request, ps ht
 	}
 
 	if bootcamp.Deleted {
-		// should not found the deleted bootcamp
-		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no bootcamp with id of %s", id))
+		utils.ErrorResponse(w, http.StatusNotFound, errors.New("this bootcamp was deleted"))
 		return
 	}
 
-	if bootcamp.User != cUser.Id && cUser.Role != "admin" {
+	if !canOnBootcamp(bc.orgs, cUser, models.BootcampUpdate, bootcamp) {
 		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
 		return
 	}
 
-	bootcamp.SetDeleted(true)
-	bootcamp.Save()
+	// limit the request body, leave some room for the multipart headers
+	r.Body = http.MaxBytesReader(w, r.Body, bc.config.MaxFileUpload+1<<20)
+	err = r.ParseMultipartForm(bc.config.MaxFileUpload)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("please upload an image less than %d bytes", bc.config.MaxFileUpload))
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please upload a file"))
+		return
+	}
+	defer file.Close()
+
+	if header.Size > bc.config.MaxFileUpload {
+		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("please upload an image less than %d bytes", bc.config.MaxFileUpload))
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(file, bc.config.MaxFileUpload+1))
+	if err != nil || int64(len(data)) > bc.config.MaxFileUpload {
+		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("please upload an image less than %d bytes", bc.config.MaxFileUpload))
+		return
+	}
+
+	// check the real content type, the client provided one is not trusted
+	contentType := http.DetectContentType(data)
+	ext, ok := photoTypes[contentType]
+	if !ok {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please upload an image file (jpeg, png, gif or webp)"))
+		return
+	}
+
+	photo, err := bc.storage.Put(fmt.Sprintf("photo_%s%s", bootcamp.Id.Hex(), ext), contentType, data)
+	if err != nil {
+		log.Println("store photo: ", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("problem with file upload"))
+		return
+	}
+
+	// remove the previous photo when it was stored under another name
+	if old := bootcamp.Photo; old != "no-photo.jpg" && path.Base(old) != path.Base(photo) {
+		err = bc.storage.Delete(path.Base(old))
+		if err != nil {
+			log.Println("delete old photo: ", err)
+		}
+	}
+
+	bootcamp.Photo = photo
+	err = bc.bootcamps.Save(bootcamp)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
-		"data":    nil,
+		"data":    bootcamp.Photo,
 	})
 }
 
 // @desc    Get bootcamps within radius
-// @route   GET /api/v1/bootcamps/radius/:zipcode/:distance
+// @route   GET /api/v1/radius/bootcamps?zipcode=&distance=&unit=mi|km
 // @access  Public
 func (bc *Bootcamp) GetBootcampsInRadius(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	zipcode := ps.ByName("zipcode")
-	distance, err := strconv.ParseFloat(ps.ByName("distance"), 64)
+	// parse form
+	err := r.ParseForm()
 	if err != nil {
-		utils.SendJSON(w, http.StatusBadRequest, errors.New("distance should be number"))
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad request data"))
+		return
 	}
-	fmt.Printf("zipcode: %s, distance: %v\n", zipcode, distance)
 
-	// get lat/lng from mapquest usint zipcode
-	// TO DO
-	lng := -71.1
-	lat := 42.34
+	zipcode := r.Form.Get("zipcode")
+	if len(zipcode) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide zipcode"))
+		return
+	}
+	distance, err := strconv.ParseFloat(r.Form.Get("distance"), 64)
+	if err != nil || distance <= 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("distance should be a positive number"))
+		return
+	}
 
 	// Calc radius using radians
 	// Divide dist by radius of earth
 	// earth radius = 3,963mi (6,378km)
-	radius := distance / 3963.0
+	var radius float64
+	switch unit := r.Form.Get("unit"); unit {
+	case "", "mi":
+		radius = distance / 3963.0
+	case "km":
+		radius = distance / 6378.0
+	default:
+		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("unit should be mi or km, got %s", unit))
+		return
+	}
 
-	Bootcamp := bc.connection.Model("Bootcamp")
-	bootcamps := []*models.Bootcamp{}
+	// get lat/lng of the zipcode
+	coord, err := bc.zipcodes.Lookup(zipcode)
+	if err == utils.ErrZipcodeNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no location found for zipcode %s", zipcode))
+		return
+	} else if err != nil {
+		log.Println("zipcode lookup: ", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
+		return
+	}
 
-	query := bson.M{
+	// remove radius params so they are not used as filter
+	delete(r.Form, "zipcode")
+	delete(r.Form, "distance")
+	delete(r.Form, "unit")
+
+	within := bson.M{
 		"location": bson.M{
 			"$geoWithin": bson.M{
 				"$centerSphere": []interface{}{
 					[]float64{
-						lng,
-						lat,
+						coord.Lng,
+						coord.Lat,
 					},
 					radius,
 				},
@@ -340,11 +534,95 @@ func (bc *Bootcamp) GetBootcampsInRadius(w http.ResponseWriter, r *http.Request,
 		},
 	}
 
-	Bootcamp.Find(query).Exec(&bootcamps)
-	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"count":   len(bootcamps),
-		"data":    bootcamps,
-	})
+	// create advance query
+	query, pagination, err := models.AdvanceQuery(r.Form, bc.bootcamps, within)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// execute query
+	bootcamps, err := bc.bootcamps.Find(query)
+	if err != nil {
+		log.Println(err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
+		return
+	}
+
+	// cursors and links of the next and previous pages
+	err = pagination.Cursors(bootcamps)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	pagination.SetHeaders(w, r.URL)
+
+	// related documents asked by expand
+	err = bc.bootcamps.Expand(bootcamps, r.Form)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// prepare response data
+	respData := map[string]interface{}{
+		"success":    true,
+		"count":      len(bootcamps),
+		"pagination": pagination,
+	}
+
+	// only the fields asked by select
+	data, err := query.Project(bootcamps)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	respData["data"] = data
+
+	utils.SendJSON(w, http.StatusOK, respData)
+}
+
+// locate the bootcamp address
+// when the geocoder is unavailable the bootcamp is marked as pending and keeps its address for a retry
+func (bc *Bootcamp) geocode(bootcamp *models.Bootcamp) error {
+	res, err := bc.geocoder.Geocode(bootcamp.Address)
+	if err == utils.ErrLocationNotFound {
+		return err
+	} else if err != nil {
+		log.Printf("geocode %q: %v\n", bootcamp.Address, err)
+		bootcamp.GeocodeStatus = models.GeocodePending
+		return nil
+	}
+	bootcamp.Location = models.NewGeoJson(res)
+	bootcamp.Address = ""
+	bootcamp.GeocodeStatus = ""
+	return nil
+}
 
+// geocode again the bootcamps that were saved while the geocoder was unavailable
+func (bc *Bootcamp) RetryPendingGeocodes() {
+	query := bson.M{
+		"geocodeStatus": models.GeocodePending,
+		"deleted":       false,
+	}
+	bootcamps, err := bc.bootcamps.Find(models.Query{Filter: query})
+	if err != nil {
+		log.Println("find pending geocodes: ", err)
+		return
+	}
+	for _, bootcamp := range bootcamps {
+		err := bc.geocode(bootcamp)
+		if err != nil {
+			log.Printf("geocode bootcamp %s: %v\n", bootcamp.Id.Hex(), err)
+			continue
+		}
+		if bootcamp.GeocodeStatus == models.GeocodePending {
+			// geocoder is still unavailable, try again later
+			return
+		}
+		err = bc.bootcamps.Save(bootcamp)
+		if err != nil {
+			log.Printf("save bootcamp %s: %v\n", bootcamp.Id.Hex(), err)
+		}
+	}
 }
@@ -1,26 +1,35 @@
 package controllers
 
 import (
+	"devcamper/config"
+	"devcamper/middleware"
 	"devcamper/models"
 	"devcamper/utils"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"strings"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/zebresel-com/mongodm"
 	"gopkg.in/mgo.v2/bson"
 )
 
 type Course struct {
-	connection *mongodm.Connection
+	courses   models.CourseRepo
+	bootcamps models.BootcampRepo
+	users     models.UserRepo
+	orgs      models.OrganizationRepo
+	config    *config.Config
 }
 
-func NewCourse(conn *mongodm.Connection) *Course {
+func NewCourse(courses models.CourseRepo, bootcamps models.BootcampRepo, users models.UserRepo, orgs models.OrganizationRepo, c *config.Config) *Course {
 	return &Course{
-		connection: conn,
+		courses:   courses,
+		bootcamps: bootcamps,
+		users:     users,
+		orgs:      orgs,
+		config:    c,
 	}
 }
 
@@ -36,21 +45,34 @@ func (c *Course) GetCourses(w http.ResponseWriter, r *http.Request, ps httproute
 	}
 
 	// create advance query
-	query, pagination, err := models.AdvanceQuery(r.Form, c.connection.Model("Course"))
+	query, pagination, err := models.AdvanceQuery(r.Form, c.courses)
 	if err != nil {
-		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad request data"))
+		utils.ErrorHandler(w, err)
 		return
 	}
 
-	courses := []*models.Course{}
-
 	// execute query
-	err = query.Exec(&courses)
+	courses, err := c.courses.Find(query)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
 		return
 	}
 
+	// cursors and links of the next and previous pages
+	err = pagination.Cursors(courses)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	pagination.SetHeaders(w, r.URL)
+
+	// related documents asked by expand
+	err = c.courses.Expand(courses, r.Form)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
 	// prepare response data
 	respData := map[string]interface{}{
 		"success":    true,
@@ -58,14 +80,13 @@ func (c *Course) GetCourses(w http.ResponseWriter, r *http.Request, ps httproute
 		"pagination": pagination,
 	}
 
-	// hide data that user not request
-	selectField := r.Form["select"]
-	if len(selectField) != 0 {
-		selects := strings.Split(selectField[0], ",")
-		respData["data"] = models.ExtractSelectField(courses, selects)
-	} else {
-		respData["data"] = courses
+	// only the fields asked by select
+	data, err := query.Project(courses)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
 	}
+	respData["data"] = data
 
 	utils.SendJSON(w, http.StatusOK, respData)
 }
@@ -80,10 +101,8 @@ func (c *Course) GetCoursesInBootcamp(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
-	Bootcamp := c.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
-	err := Bootcamp.FindId(bson.ObjectIdHex(bootcampId)).Exec(bootcamp)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	bootcamp, err := c.bootcamps.FindId(bson.ObjectIdHex(bootcampId))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("not found bootcamp with id of %s", bootcampId))
 		return
 	} else if err != nil {
@@ -95,19 +114,23 @@ func (c *Course) GetCoursesInBootcamp(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
-	Course := c.connection.Model("Course")
-	courses := []*models.Course{}
-
 	query := bson.M{
 		"bootcamp": bson.ObjectIdHex(bootcampId),
 		"deleted":  false,
 	}
-	err = Course.Find(query).Exec(&courses)
+	courses, err := c.courses.Find(models.Query{Filter: query})
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
 		return
 	}
 
+	// related documents asked by expand
+	err = c.courses.Expand(courses, r.URL.Query())
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"count":   len(courses),
@@ -125,11 +148,8 @@ func (c *Course) GetCourse(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 
-	Course := c.connection.Model("Course")
-	course := &models.Course{}
-
-	err := Course.FindId(bson.ObjectIdHex(id)).Exec(course)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	course, err := c.courses.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("not found course with id of %s", id))
 		return
 	} else if err != nil {
@@ -141,6 +161,13 @@ func (c *Course) GetCourse(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 
+	// related documents asked by expand
+	err = c.courses.Expand(course, r.URL.Query())
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"data":    course,
@@ -151,15 +178,7 @@ func (c *Course) GetCourse(w http.ResponseWriter, r *http.Request, ps httprouter
 // @route   POST /api/v1/bootcamps/:id/courses
 // @access  Private
 func (c *Course) AddCourse(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(c.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("publisher", "admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
+	cUser := middleware.CurrentUser(r)
 
 	bootcampId := ps.ByName("id")
 	if !bson.IsObjectIdHex(bootcampId) {
@@ -167,10 +186,8 @@ func (c *Course) AddCourse(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 
-	Bootcamp := c.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
-	err := Bootcamp.FindId(bson.ObjectIdHex(bootcampId)).Exec(bootcamp)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	bootcamp, err := c.bootcamps.FindId(bson.ObjectIdHex(bootcampId))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("not found bootcamp with id of %s", bootcampId))
 		return
 	} else if err != nil {
@@ -182,14 +199,34 @@ func (c *Course) AddCourse(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 
-	if bootcamp.User != cUser.Id && cUser.Role != "admin" {
+	if !canOnBootcamp(c.orgs, cUser, models.CourseCreate, bootcamp) {
 		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
 		return
 	}
 
-	Course := c.connection.Model("Course")
-	course := &models.Course{}
-	Course.New(course)
+	// the courses count against the quota of the owner of the bootcamp,
+	// also when an admin or a member of its organization adds them
+	ownerId, ok := bootcamp.User.(bson.ObjectId)
+	if !ok {
+		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
+		return
+	}
+	owner, err := c.users.FindId(ownerId)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	n, err := c.courses.Count(bson.M{"bootcamp": bootcamp.Id, "deleted": false})
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	if err := owner.CheckQuota(models.QuotaCoursesPerBootcamp, n); err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	course := c.courses.New()
 
 	json.NewDecoder(r.Body).Decode(course)
 	course.Bootcamp = bson.ObjectIdHex(bootcampId)
@@ -198,11 +235,14 @@ func (c *Course) AddCourse(w http.ResponseWriter, r *http.Request, ps httprouter
 		utils.ErrorResponse(w, http.StatusBadRequest, issue...)
 		return
 	}
-	course.Save()
+	err = c.courses.Save(course)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
 
 	// update averageCost for bootcamp
-	bootcamp.AverageCost = getAvgCost(c.connection, bootcamp.Id)
-	bootcamp.Save()
+	c.updateAverageCost(bootcamp.Id)
 
 	utils.SendJSON(w, http.StatusCreated, map[string]interface{}{
 		"success": true,
@@ -214,15 +254,7 @@ func (c *Course) AddCourse(w http.ResponseWriter, r *http.Request, ps httprouter
 // @route   PUT /api/v1/courses/:id
 // @access  Private
 func (c *Course) UpdateCourse(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(c.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("publisher", "admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
+	cUser := middleware.CurrentUser(r)
 
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
@@ -230,11 +262,8 @@ func (c *Course) UpdateCourse(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	Course := c.connection.Model("Course")
-	course := &models.Course{}
-
-	err := Course.FindId(bson.ObjectIdHex(id)).Exec(course)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	course, err := c.courses.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("not found course with id of %s", id))
 		return
 	} else if err != nil {
@@ -246,7 +275,7 @@ func (c *Course) UpdateCourse(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	if course.User != cUser.Id && cUser.Role != "admin" {
+	if !c.canOnCourse(cUser, models.CourseUpdate, course) {
 		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
 		return
 	}
@@ -270,16 +299,15 @@ func (c *Course) UpdateCourse(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	course.Save()
+	err = c.courses.Save(course)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
 
 	if _, ok := data["tuition"]; ok {
 		// update averageCost for bootcamp
-		Bootcamp := c.connection.Model("Bootcamp")
-		bootcamp := &models.Bootcamp{}
-
-		Bootcamp.FindId(course.Bootcamp.(bson.ObjectId)).Exec(bootcamp)
-		bootcamp.AverageCost = getAvgCost(c.connection, bootcamp.Id)
-		bootcamp.Save()
+		c.updateAverageCost(course.Bootcamp.(bson.ObjectId))
 	}
 
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
@@ -292,15 +320,7 @@ func (c *Course) UpdateCourse(w http.ResponseWriter, r *http.Request, ps httprou
 // @route   DELETE /api/v1/courses/:id
 // @access  Private
 func (c *Course) DeleteCourse(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(c.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("publisher", "admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
+	cUser := middleware.CurrentUser(r)
 
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
@@ -308,11 +328,8 @@ func (c *Course) DeleteCourse(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	Course := c.connection.Model("Course")
-	course := &models.Course{}
-
-	err := Course.FindId(bson.ObjectIdHex(id)).Exec(course)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	course, err := c.courses.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no course with id of %s", id))
 		return
 	} else if err != nil {
@@ -325,21 +342,19 @@ func (c *Course) DeleteCourse(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	if course.User != cUser.Id && cUser.Role != "admin" {
+	if !c.canOnCourse(cUser, models.CourseDelete, course) {
 		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
 		return
 	}
 
-	course.SetDeleted(true)
-	course.Save()
+	err = c.courses.SoftDelete(course)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
 
 	// update averageCost for bootcamp
-	Bootcamp := c.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
-
-	Bootcamp.FindId(course.Bootcamp.(bson.ObjectId)).Exec(bootcamp)
-	bootcamp.AverageCost = getAvgCost(c.connection, bootcamp.Id)
-	bootcamp.Save()
+	c.updateAverageCost(course.Bootcamp.(bson.ObjectId))
 
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -347,25 +362,34 @@ func (c *Course) DeleteCourse(w http.ResponseWriter, r *http.Request, ps httprou
 	})
 }
 
-func getAvgCost(conn *mongodm.Connection, bootcampId bson.ObjectId) int {
-	var avg int
-	Course := conn.Model("Course")
-	courses := []*models.Course{}
-
-	query := bson.M{
-		"bootcamp": bootcampId,
-		"deleted":  false,
+// refresh the averageCost of the bootcamp after its courses changed
+func (c *Course) updateAverageCost(bootcampId bson.ObjectId) {
+	bootcamp, err := c.bootcamps.FindId(bootcampId)
+	if err != nil {
+		log.Printf("find bootcamp %s: %v\n", bootcampId.Hex(), err)
+		return
+	}
+	bootcamp.AverageCost, err = c.courses.AverageCost(bootcampId)
+	if err != nil {
+		log.Printf("average cost of bootcamp %s: %v\n", bootcampId.Hex(), err)
+		return
 	}
-	err := Course.Find(query).Exec(&courses)
+	err = c.bootcamps.Save(bootcamp)
 	if err != nil {
-		return avg
+		log.Printf("save bootcamp %s: %v\n", bootcampId.Hex(), err)
 	}
+}
 
-	sum := float64(0)
-	for _, v := range courses {
-		sum += v.Tuition
+// check if the user can do the action on the course, as its author or with the
+// permissions on its bootcamp
+func (c *Course) canOnCourse(user *models.User, action string, course *models.Course) bool {
+	if user.CanOn(action, course.User) {
+		return true
+	}
+	bootcampId, ok := course.Bootcamp.(bson.ObjectId)
+	if !ok {
+		return false
 	}
-	// force last digit to be zero
-	avg = int((sum/float64(len(courses)))/10) * 10
-	return avg
+	bootcamp, err := c.bootcamps.FindId(bootcampId)
+	return err == nil && canOnBootcamp(c.orgs, user, action, bootcamp)
 }
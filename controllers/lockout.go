@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"devcamper/utils"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// prefix of the attempt keys, the email key is the one locked out and notified
+const (
+	emailAttemptKey  = "email:"
+	ipAttemptKey     = "ip:"
+	forgotAttemptKey = "forgot:"
+)
+
+func (u *User) loginAttemptKeys(r *http.Request, email string) []string {
+	return []string{
+		emailAttemptKey + normalizeEmail(email),
+		ipAttemptKey + utils.ClientIP(r, u.config.TrustProxy),
+	}
+}
+
+func (u *User) forgotAttemptKeys(r *http.Request, email string) []string {
+	return []string{
+		forgotAttemptKey + emailAttemptKey + normalizeEmail(email),
+		forgotAttemptKey + ipAttemptKey + utils.ClientIP(r, u.config.TrustProxy),
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// longest time to wait before one of the keys can try again
+func (u *User) retryAfter(keys ...string) time.Duration {
+	var wait time.Duration
+	now := time.Now()
+	for _, key := range keys {
+		attempt, err := u.attempts.Get(key)
+		if err != nil {
+			// the tracker is a protection layer, keep the login available without it
+			log.Println("login attempt lookup: ", err)
+			continue
+		}
+		if d := attempt.RetryAfter(now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// reply 429 when one of the keys has to wait, return true if the request is blocked
+func (u *User) tooManyAttempts(w http.ResponseWriter, keys ...string) bool {
+	wait := u.retryAfter(keys...)
+	if wait <= 0 {
+		return false
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	utils.ErrorResponse(w, http.StatusTooManyRequests, fmt.Errorf("too many attempts, please retry in %d seconds", seconds))
+	return true
+}
+
+// record a failure on every key and notify the owner of a locked email
+func (u *User) attemptFailed(keys ...string) {
+	for _, key := range keys {
+		attempt, locked, err := u.attempts.Fail(key)
+		if err != nil {
+			log.Println("login attempt record: ", err)
+			continue
+		}
+		if locked && strings.HasPrefix(key, emailAttemptKey) {
+			u.notifyLockout(strings.TrimPrefix(key, emailAttemptKey), attempt)
+		}
+	}
+}
+
+func (u *User) attemptSucceeded(keys ...string) {
+	for _, key := range keys {
+		if err := u.attempts.Reset(key); err != nil {
+			log.Println("login attempt reset: ", err)
+		}
+	}
+}
+
+// end the lockouts that are over and tell the users their account is usable again
+func (u *User) ReleaseLockouts() {
+	released, err := u.attempts.ReleaseExpired()
+	if err != nil {
+		log.Println("release lockouts: ", err)
+	}
+	for _, attempt := range released {
+		if !strings.HasPrefix(attempt.Key, emailAttemptKey) {
+			continue
+		}
+		email, ok := u.accountEmail(strings.TrimPrefix(attempt.Key, emailAttemptKey))
+		if !ok {
+			continue
+		}
+		msg := "Your account was unlocked, you can login again. If you did not try to login recently, please reset your password."
+		if !u.mailer.SendMail(email, "Account unlocked", msg) {
+			log.Printf("cannot send unlock email to %s\n", email)
+		}
+	}
+}
+
+func (u *User) notifyLockout(email string, attempt *utils.LoginAttempt) {
+	// attempts on unknown emails are locked too, but there is nobody to tell
+	email, ok := u.accountEmail(email)
+	if !ok {
+		return
+	}
+	msg := fmt.Sprintf("Your account was locked after %d failed login attempts, it will be unlocked at %s. If it was not you, please reset your password.",
+		attempt.Failures, attempt.BlockedUntil.UTC().Format(time.RFC1123))
+	if !u.mailer.SendMail(email, "Account locked", msg) {
+		log.Printf("cannot send lockout email to %s\n", email)
+	}
+}
+
+// email of the account as it was stored, the attempt keys hold the normalized email
+func (u *User) accountEmail(email string) (string, bool) {
+	query := bson.M{
+		"email":   bson.RegEx{Pattern: "^" + regexp.QuoteMeta(normalizeEmail(email)) + "$", Options: "i"},
+		"deleted": false,
+	}
+	user, err := u.users.FindOne(query)
+	if err != nil {
+		return "", false
+	}
+	return user.Email, true
+}
@@ -0,0 +1,433 @@
+package controllers
+
+import (
+	"devcamper/config"
+	"devcamper/middleware"
+	"devcamper/models"
+	"devcamper/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Organization struct {
+	orgs      models.OrganizationRepo
+	bootcamps models.BootcampRepo
+	config    *config.Config
+	mailer    *utils.Mailer
+}
+
+type InviteMember struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type JoinOrganization struct {
+	Token string `json:"token"`
+}
+
+type SetMemberRole struct {
+	Role string `json:"role"`
+}
+
+type SetBootcampOrganization struct {
+	// empty to take the bootcamp out of its organization
+	Organization string `json:"organization"`
+}
+
+func NewOrganization(orgs models.OrganizationRepo, bootcamps models.BootcampRepo, c *config.Config) *Organization {
+	return &Organization{
+		orgs:      orgs,
+		bootcamps: bootcamps,
+		config:    c,
+		mailer:    utils.NewMailer(c.SMTP.Host, c.SMTP.Port, c.SMTP.Email, c.SMTP.Password, c.SMTP.FromEmail, c.SMTP.FromName),
+	}
+}
+
+// @desc    Create organization, the user is its first owner
+// @route   POST /api/v1/organizations
+// @access  Private
+func (o *Organization) CreateOrganization(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	org := o.orgs.New()
+	data := struct {
+		Name string `json:"name"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad data"))
+		return
+	}
+	org.Name = data.Name
+	if valid, issues := org.ValidateCreate(); !valid {
+		utils.ErrorResponse(w, http.StatusBadRequest, issues...)
+		return
+	}
+	org.SetMember(cUser.Id, models.OrgOwner)
+
+	err = o.orgs.Save(org)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"data":    org,
+	})
+}
+
+// @desc    Get organizations of the user
+// @route   GET /api/v1/organizations
+// @access  Private
+func (o *Organization) GetOrganizations(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	orgs, err := o.orgs.FindByMember(cUser.Id)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"count":   len(orgs),
+		"data":    orgs,
+	})
+}
+
+// @desc    Get organization with its members and invites
+// @route   GET /api/v1/organizations/:id
+// @access  Private
+func (o *Organization) GetOrganization(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	org, ok := o.memberOrganization(w, cUser, ps.ByName("id"), false)
+	if !ok {
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    org,
+	})
+}
+
+// @desc    Rename organization
+// @route   PUT /api/v1/organizations/:id
+// @access  Private
+func (o *Organization) UpdateOrganization(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	org, ok := o.memberOrganization(w, cUser, ps.ByName("id"), true)
+	if !ok {
+		return
+	}
+
+	data := struct {
+		Name string `json:"name"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad data"))
+		return
+	}
+	org.Name = data.Name
+	if valid, issues := org.ValidateCreate(); !valid {
+		utils.ErrorResponse(w, http.StatusBadRequest, issues...)
+		return
+	}
+
+	err = o.orgs.Save(org)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    org,
+	})
+}
+
+// @desc    Invite email to join organization with role
+// @route   POST /api/v1/organizations/:id/invites
+// @access  Private
+func (o *Organization) InviteMember(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	org, ok := o.memberOrganization(w, cUser, ps.ByName("id"), true)
+	if !ok {
+		return
+	}
+
+	invite := InviteMember{}
+	json.NewDecoder(r.Body).Decode(&invite)
+	if len(invite.Email) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide the email to invite"))
+		return
+	}
+	if !models.IsOrgRole(invite.Role) {
+		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("role should be one of %v", models.OrgRoles))
+		return
+	}
+
+	token := org.Invite(invite.Email, invite.Role, cUser.Id, o.config.JWTSecret)
+	err := o.orgs.Save(org)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	joinURL := url.URL{
+		Scheme: o.config.Scheme,
+		Host:   o.config.Host,
+		Path:   fmt.Sprintf("/api/v1/organizations/%s/join", org.Id.Hex()),
+	}
+	msg := fmt.Sprintf("You are receiving this email because you are invited to join the organization %s as %s. To join it, login with this email and make a POST request to:", org.Name, invite.Role) +
+		"\r\n" + joinURL.String() + "\r\n" + fmt.Sprintf(`with the body {"token": "%s"} before %s`, token, time.Now().Add(models.InviteExpire).Format(time.RFC1123))
+	if !o.mailer.SendMail(invite.Email, "Organization invite", msg) {
+		// the invite is kept, it is sent again by inviting the email again
+		log.Printf("cannot send invite email to %s\n", invite.Email)
+	}
+
+	utils.SendJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"data":    org,
+	})
+}
+
+// @desc    Join organization with the emailed token
+// @route   POST /api/v1/organizations/:id/join
+// @access  Private
+func (o *Organization) JoinOrganization(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	id := ps.ByName("id")
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid organization id format"))
+		return
+	}
+
+	join := JoinOrganization{}
+	json.NewDecoder(r.Body).Decode(&join)
+	x, err := models.HashToken(o.config.JWTSecret, join.Token)
+	if len(join.Token) == 0 || err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide the emailed token"))
+		return
+	}
+
+	org, err := o.orgs.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no organization with id of %s", id))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	err = org.Join(cUser, x)
+	if err == nil {
+		err = o.orgs.Save(org)
+	}
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    org,
+	})
+}
+
+// @desc    Change role of member
+// @route   PUT /api/v1/organizations/:id/members/:user
+// @access  Private
+func (o *Organization) SetMemberRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	org, ok := o.memberOrganization(w, cUser, ps.ByName("id"), true)
+	if !ok {
+		return
+	}
+
+	userId, ok := orgMember(w, org, ps.ByName("user"))
+	if !ok {
+		return
+	}
+
+	data := SetMemberRole{}
+	json.NewDecoder(r.Body).Decode(&data)
+	if !models.IsOrgRole(data.Role) {
+		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("role should be one of %v", models.OrgRoles))
+		return
+	}
+
+	err := org.SetMember(userId, data.Role)
+	if err == nil {
+		err = o.orgs.Save(org)
+	}
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    org,
+	})
+}
+
+// @desc    Remove member from organization, a member can leave by itself
+// @route   DELETE /api/v1/organizations/:id/members/:user
+// @access  Private
+func (o *Organization) RemoveMember(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	org, ok := o.memberOrganization(w, cUser, ps.ByName("id"), false)
+	if !ok {
+		return
+	}
+
+	userId, ok := orgMember(w, org, ps.ByName("user"))
+	if !ok {
+		return
+	}
+	if userId != cUser.Id && !canManage(cUser, org) {
+		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
+		return
+	}
+
+	_, err := org.RemoveMember(userId)
+	if err == nil {
+		err = o.orgs.Save(org)
+	}
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    org,
+	})
+}
+
+// @desc    Move bootcamp into an organization the user owns, or out of its organization
+// @route   PUT /api/v1/bootcamps/:id/organization
+// @access  Private
+func (o *Organization) SetBootcampOrganization(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	id := ps.ByName("id")
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid bootcamp id format"))
+		return
+	}
+
+	bootcamp, err := o.bootcamps.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound || (err == nil && bootcamp.Deleted) {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no bootcamp with id of %s", id))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// the owner of the bootcamp or an owner of its organization
+	if !canOnBootcamp(o.orgs, cUser, models.BootcampTransfer, bootcamp) {
+		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
+		return
+	}
+
+	data := SetBootcampOrganization{}
+	json.NewDecoder(r.Body).Decode(&data)
+	if len(data.Organization) == 0 {
+		bootcamp.Organization = ""
+	} else {
+		org, ok := o.memberOrganization(w, cUser, data.Organization, true)
+		if !ok {
+			return
+		}
+		bootcamp.Organization = org.Id
+	}
+
+	err = o.bootcamps.Save(bootcamp)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    bootcamp,
+	})
+}
+
+// live organization with id the user is a member of, an owner of when owner is true,
+// the error is sent otherwise (admins see and manage every organization)
+func (o *Organization) memberOrganization(w http.ResponseWriter, user *models.User, id string, owner bool) (*models.Organization, bool) {
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid organization id format"))
+		return nil, false
+	}
+
+	org, err := o.orgs.FindId(bson.ObjectIdHex(id))
+	// the organizations of the others are not found
+	if err == models.ErrNotFound || (err == nil && org.Role(user.Id) == "" && !user.Can(models.OrganizationManage)) {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no organization with id of %s", id))
+		return nil, false
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return nil, false
+	}
+
+	if owner && !canManage(user, org) {
+		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
+		return nil, false
+	}
+	return org, true
+}
+
+// id of the member of the organization, the error is sent otherwise
+func orgMember(w http.ResponseWriter, org *models.Organization, id string) (bson.ObjectId, bool) {
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid user id format"))
+		return "", false
+	}
+	if org.Role(bson.ObjectIdHex(id)) == "" {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no member with id of %s", id))
+		return "", false
+	}
+	return bson.ObjectIdHex(id), true
+}
+
+// check if the user can manage the members and the bootcamps of the organization
+func canManage(user *models.User, org *models.Organization) bool {
+	return org.Role(user.Id) == models.OrgOwner || user.Can(models.OrganizationManage)
+}
+
+// check if the user can do the action on the bootcamp, as its owner or as a member
+// of its organization, the organization is loaded only when needed
+func canOnBootcamp(orgs models.OrganizationRepo, user *models.User, action string, bootcamp *models.Bootcamp) bool {
+	if user.CanOn(action, bootcamp.User) {
+		return true
+	}
+	if bootcamp.Organization == "" {
+		return false
+	}
+	org, err := orgs.FindId(bootcamp.Organization)
+	if err != nil {
+		return false
+	}
+	return user.CanOnBootcamp(action, bootcamp, org)
+}
@@ -1,26 +1,31 @@
 package controllers
 
 import (
+	"devcamper/config"
+	"devcamper/middleware"
 	"devcamper/models"
 	"devcamper/utils"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"strings"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/zebresel-com/mongodm"
 	"gopkg.in/mgo.v2/bson"
 )
 
 type Review struct {
-	connection *mongodm.Connection
+	reviews   models.ReviewRepo
+	bootcamps models.BootcampRepo
+	config    *config.Config
 }
 
-func NewReview(conn *mongodm.Connection) *Review {
+func NewReview(reviews models.ReviewRepo, bootcamps models.BootcampRepo, c *config.Config) *Review {
 	return &Review{
-		connection: conn,
+		reviews:   reviews,
+		bootcamps: bootcamps,
+		config:    c,
 	}
 }
 
@@ -36,20 +41,33 @@ func (rw *Review) GetReviews(w http.ResponseWriter, r *http.Request, ps httprout
 	}
 
 	// create advance query
-	query, pagination, err := models.AdvanceQuery(r.Form, rw.connection.Model("Review"))
+	query, pagination, err := models.AdvanceQuery(r.Form, rw.reviews)
 	if err != nil {
-		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad request data"))
+		utils.ErrorHandler(w, err)
 		return
 	}
 
-	reviews := []*models.Review{}
-
-	err = query.Exec(&reviews)
+	reviews, err := rw.reviews.Find(query)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
 		return
 	}
 
+	// cursors and links of the next and previous pages
+	err = pagination.Cursors(reviews)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	pagination.SetHeaders(w, r.URL)
+
+	// related documents asked by expand
+	err = rw.reviews.Expand(reviews, r.Form)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
 	// prepare response data
 	respData := map[string]interface{}{
 		"success":    true,
@@ -57,14 +75,13 @@ func (rw *Review) GetReviews(w http.ResponseWriter, r *http.Request, ps httprout
 		"pagination": pagination,
 	}
 
-	// hide data that user not request
-	selectField := r.Form["select"]
-	if len(selectField) != 0 {
-		selects := strings.Split(selectField[0], ",")
-		respData["data"] = models.ExtractSelectField(reviews, selects)
-	} else {
-		respData["data"] = reviews
+	// only the fields asked by select
+	data, err := query.Project(reviews)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
 	}
+	respData["data"] = data
 
 	utils.SendJSON(w, http.StatusOK, respData)
 }
@@ -79,10 +96,8 @@ func (rw *Review) GetReviewsInBootcamp(w http.ResponseWriter, r *http.Request, p
 		return
 	}
 
-	Bootcamp := rw.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
-	err := Bootcamp.FindId(bson.ObjectIdHex(bootcampId)).Exec(bootcamp)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	bootcamp, err := rw.bootcamps.FindId(bson.ObjectIdHex(bootcampId))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("not found bootcamp with id of %s", bootcampId))
 		return
 	} else if err != nil {
@@ -94,19 +109,23 @@ func (rw *Review) GetReviewsInBootcamp(w http.ResponseWriter, r *http.Request, p
 		return
 	}
 
-	Review := rw.connection.Model("Review")
-	reviews := []*models.Course{}
-
 	query := bson.M{
 		"bootcamp": bson.ObjectIdHex(bootcampId),
 		"deleted":  false,
 	}
-	err = Review.Find(query).Exec(&reviews)
+	reviews, err := rw.reviews.Find(models.Query{Filter: query})
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
 		return
 	}
 
+	// related documents asked by expand
+	err = rw.reviews.Expand(reviews, r.URL.Query())
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"count":   len(reviews),
@@ -124,11 +143,8 @@ func (rw *Review) GetReview(w http.ResponseWriter, r *http.Request, ps httproute
 		return
 	}
 
-	Review := rw.connection.Model("Review")
-	review := &models.Review{}
-
-	err := Review.FindId(bson.ObjectIdHex(id)).Exec(review)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	review, err := rw.reviews.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("not found review with id of %s", id))
 		return
 	} else if err != nil {
@@ -140,6 +156,13 @@ func (rw *Review) GetReview(w http.ResponseWriter, r *http.Request, ps httproute
 		return
 	}
 
+	// related documents asked by expand
+	err = rw.reviews.Expand(review, r.URL.Query())
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"data":    review,
@@ -150,15 +173,7 @@ func (rw *Review) GetReview(w http.ResponseWriter, r *http.Request, ps httproute
 // @route   GET /api/v1/bootcamps/:id/reviews
 // @access  Private
 func (rw *Review) AddReview(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(rw.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("user", "admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
+	cUser := middleware.CurrentUser(r)
 
 	bootcampId := ps.ByName("id")
 	if !bson.IsObjectIdHex(bootcampId) {
@@ -166,10 +181,8 @@ func (rw *Review) AddReview(w http.ResponseWriter, r *http.Request, ps httproute
 		return
 	}
 
-	Bootcamp := rw.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
-	err := Bootcamp.FindId(bson.ObjectIdHex(bootcampId)).Exec(bootcamp)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	bootcamp, err := rw.bootcamps.FindId(bson.ObjectIdHex(bootcampId))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("not found bootcamp with id of %s", bootcampId))
 		return
 	} else if err != nil {
@@ -181,9 +194,18 @@ func (rw *Review) AddReview(w http.ResponseWriter, r *http.Request, ps httproute
 		return
 	}
 
-	Review := rw.connection.Model("Review")
-	review := &models.Review{}
-	Review.New(review)
+	// one review of a bootcamp by user by default
+	n, err := rw.reviews.Count(bson.M{"bootcamp": bootcamp.Id, "user": cUser.Id, "deleted": false})
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	if err := cUser.CheckQuota(models.QuotaReviewsPerBootcamp, n); err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	review := rw.reviews.New()
 
 	json.NewDecoder(r.Body).Decode(review)
 	review.Bootcamp = bson.ObjectIdHex(bootcampId)
@@ -192,11 +214,14 @@ func (rw *Review) AddReview(w http.ResponseWriter, r *http.Request, ps httproute
 		utils.ErrorResponse(w, http.StatusBadRequest, issue...)
 		return
 	}
-	review.Save()
+	err = rw.reviews.Save(review)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
 
 	// update averageRating for bootcamp
-	bootcamp.AverageRating = getAvgRating(rw.connection, bootcamp.Id)
-	bootcamp.Save()
+	rw.updateAverageRating(bootcamp.Id)
 
 	utils.SendJSON(w, http.StatusCreated, map[string]interface{}{
 		"success": true,
@@ -208,15 +233,7 @@ func (rw *Review) AddReview(w http.ResponseWriter, r *http.Request, ps httproute
 // @route   PUT /api/v1/reviews/:id
 // @access  Private
 func (rw *Review) UpdateReview(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(rw.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("user", "admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
+	cUser := middleware.CurrentUser(r)
 
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
@@ -224,11 +241,8 @@ func (rw *Review) UpdateReview(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	Review := rw.connection.Model("Review")
-	review := &models.Review{}
-
-	err := Review.FindId(bson.ObjectIdHex(id)).Exec(review)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	review, err := rw.reviews.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("not found review with id of %s", id))
 		return
 	} else if err != nil {
@@ -240,7 +254,7 @@ func (rw *Review) UpdateReview(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	if review.User != cUser.Id && cUser.Role != "admin" {
+	if !cUser.CanOn(models.ReviewUpdate, review.User) {
 		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
 		return
 	}
@@ -264,16 +278,15 @@ func (rw *Review) UpdateReview(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	review.Save()
+	err = rw.reviews.Save(review)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
 
 	if _, ok := data["rating"]; ok {
-		// update averageCost for bootcamp
-		Bootcamp := rw.connection.Model("Bootcamp")
-		bootcamp := &models.Bootcamp{}
-
-		Bootcamp.FindId(review.Bootcamp.(bson.ObjectId)).Exec(bootcamp)
-		bootcamp.AverageRating = getAvgRating(rw.connection, bootcamp.Id)
-		bootcamp.Save()
+		// update averageRating for bootcamp
+		rw.updateAverageRating(review.Bootcamp.(bson.ObjectId))
 	}
 
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
@@ -286,15 +299,7 @@ func (rw *Review) UpdateReview(w http.ResponseWriter, r *http.Request, ps httpro
 // @route   DELETE /api/v1/reviews/:id
 // @access  Private
 func (rw *Review) DeleteReview(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(rw.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("user", "admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
+	cUser := middleware.CurrentUser(r)
 
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
@@ -302,11 +307,8 @@ func (rw *Review) DeleteReview(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	Review := rw.connection.Model("Review")
-	review := &models.Review{}
-
-	err := Review.FindId(bson.ObjectIdHex(id)).Exec(review)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	review, err := rw.reviews.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no review with id of %s", id))
 		return
 	} else if err != nil {
@@ -319,21 +321,19 @@ func (rw *Review) DeleteReview(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	if review.User != cUser.Id && cUser.Role != "admin" {
+	if !cUser.CanOn(models.ReviewDelete, review.User) {
 		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
 		return
 	}
 
-	review.SetDeleted(true)
-	review.Save()
-
-	// update averageCost for bootcamp
-	Bootcamp := rw.connection.Model("Bootcamp")
-	bootcamp := &models.Bootcamp{}
+	err = rw.reviews.SoftDelete(review)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
 
-	Bootcamp.FindId(review.Bootcamp.(bson.ObjectId)).Exec(bootcamp)
-	bootcamp.AverageRating = getAvgRating(rw.connection, bootcamp.Id)
-	bootcamp.Save()
+	// update averageRating for bootcamp
+	rw.updateAverageRating(review.Bootcamp.(bson.ObjectId))
 
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -341,25 +341,20 @@ func (rw *Review) DeleteReview(w http.ResponseWriter, r *http.Request, ps httpro
 	})
 }
 
-func getAvgRating(conn *mongodm.Connection, bootcampId bson.ObjectId) int {
-	var avg int
-	Review := conn.Model("Review")
-	reviews := []*models.Review{}
-
-	query := bson.M{
-		"bootcamp": bootcampId,
-		"deleted":  false,
+// refresh the averageRating of the bootcamp after its reviews changed
+func (rw *Review) updateAverageRating(bootcampId bson.ObjectId) {
+	bootcamp, err := rw.bootcamps.FindId(bootcampId)
+	if err != nil {
+		log.Printf("find bootcamp %s: %v\n", bootcampId.Hex(), err)
+		return
 	}
-	err := Review.Find(query).Exec(&reviews)
+	bootcamp.AverageRating, err = rw.reviews.AverageRating(bootcampId)
 	if err != nil {
-		return avg
+		log.Printf("average rating of bootcamp %s: %v\n", bootcampId.Hex(), err)
+		return
 	}
-
-	sum := 0
-	for _, v := range reviews {
-		sum += v.Rating
+	err = rw.bootcamps.Save(bootcamp)
+	if err != nil {
+		log.Printf("save bootcamp %s: %v\n", bootcampId.Hex(), err)
 	}
-
-	avg = sum / len(reviews)
-	return avg
 }
@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"devcamper/config"
+	"devcamper/middleware"
+	"devcamper/models"
+	"devcamper/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Transfer struct {
+	transfers models.TransferRepo
+	bootcamps models.BootcampRepo
+	users     models.UserRepo
+	orgs      models.OrganizationRepo
+	config    *config.Config
+	mailer    *utils.Mailer
+}
+
+type StartTransfer struct {
+	Email string `json:"email"`
+}
+
+type AcceptTransfer struct {
+	Token string `json:"token"`
+}
+
+func NewTransfer(transfers models.TransferRepo, bootcamps models.BootcampRepo, users models.UserRepo, orgs models.OrganizationRepo, c *config.Config) *Transfer {
+	return &Transfer{
+		transfers: transfers,
+		bootcamps: bootcamps,
+		users:     users,
+		orgs:      orgs,
+		config:    c,
+		mailer:    utils.NewMailer(c.SMTP.Host, c.SMTP.Port, c.SMTP.Email, c.SMTP.Password, c.SMTP.FromEmail, c.SMTP.FromName),
+	}
+}
+
+// @desc    Start the transfer of bootcamp to another publisher
+// @route   POST /api/v1/bootcamps/:id/transfers
+// @access  Private
+func (t *Transfer) StartTransfer(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	bootcamp, ok := t.ownedBootcamp(w, cUser, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	start := StartTransfer{}
+	json.NewDecoder(r.Body).Decode(&start)
+	if len(start.Email) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide the email of the new owner"))
+		return
+	}
+
+	query := bson.M{
+		"email":   start.Email,
+		"deleted": false,
+	}
+	to, err := t.users.FindOne(query)
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no user with email %s", start.Email))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	if to.Id == bootcamp.User {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("the user already owns the bootcamp"))
+		return
+	}
+	if !t.canOwn(w, to) {
+		return
+	}
+
+	transfer := t.transfers.New()
+	transfer.Bootcamp = bootcamp.Id
+	transfer.From = bootcamp.User.(bson.ObjectId)
+	transfer.To = to.Id
+	transfer.StartedBy = cUser.Id
+	token := transfer.GenToken(t.config.JWTSecret)
+	err = t.transfers.Start(transfer)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// the new owner is told once, the owner cancels and starts again to send a new email
+	acceptURL := url.URL{
+		Scheme: t.config.Scheme,
+		Host:   t.config.Host,
+		Path:   fmt.Sprintf("/api/v1/transfers/%s/accept", transfer.Id.Hex()),
+	}
+	msg := fmt.Sprintf("You are receiving this email because the bootcamp %s is transferred to you. To become its owner, login and make a POST request to:", bootcamp.Name) +
+		"\r\n" + acceptURL.String() + "\r\n" + fmt.Sprintf(`with the body {"token": "%s"} before %s`, token, transfer.ExpiredAt.Format(time.RFC1123))
+	action := models.TransferEmailed
+	if !t.mailer.SendMail(to.Email, "Bootcamp transfer", msg) {
+		log.Printf("cannot send transfer email to %s\n", to.Email)
+		action = models.TransferEmailFailed
+	}
+	err = t.transfers.Record(transfer, action, "", "")
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"data":    transfer,
+	})
+}
+
+// @desc    Get transfers of bootcamp with their history
+// @route   GET /api/v1/bootcamps/:id/transfers
+// @access  Private
+func (t *Transfer) GetTransfers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	bootcamp, ok := t.ownedBootcamp(w, cUser, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	transfers, err := t.transfers.FindByBootcamp(bootcamp.Id)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"count":   len(transfers),
+		"data":    transfers,
+	})
+}
+
+// @desc    Accept transfer with the emailed token, the bootcamp and its courses are reassigned
+// @route   POST /api/v1/transfers/:id/accept
+// @access  Private
+func (t *Transfer) AcceptTransfer(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	transfer, ok := t.receivedTransfer(w, cUser, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	accept := AcceptTransfer{}
+	json.NewDecoder(r.Body).Decode(&accept)
+	x, err := models.HashToken(t.config.JWTSecret, accept.Token)
+	if len(accept.Token) == 0 || err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide the emailed token"))
+		return
+	}
+
+	if transfer.Status != models.TransferPending && transfer.Status != models.TransferAccepting {
+		utils.ErrorHandler(w, models.ErrTransferClosed)
+		return
+	}
+	// the expiry is recorded by the first try after it
+	if transfer.Status == models.TransferPending && transfer.ExpiredAt.Before(time.Now()) {
+		err = t.transfers.Close(transfer, models.TransferExpired, "", "")
+		if err != nil && err != models.ErrTransferClosed {
+			utils.ErrorHandler(w, err)
+			return
+		}
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("the transfer expired"))
+		return
+	}
+	if transfer.Status == models.TransferPending && !t.canOwn(w, cUser) {
+		return
+	}
+
+	err = t.transfers.Accept(transfer, x, cUser.Id)
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("your token is invalid or expired"))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    transfer,
+	})
+}
+
+// @desc    Decline transfer
+// @route   POST /api/v1/transfers/:id/decline
+// @access  Private
+func (t *Transfer) DeclineTransfer(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	transfer, ok := t.receivedTransfer(w, cUser, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	err := t.transfers.Close(transfer, models.TransferDeclined, cUser.Id, "")
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    transfer,
+	})
+}
+
+// @desc    Cancel transfer
+// @route   POST /api/v1/transfers/:id/cancel
+// @access  Private
+func (t *Transfer) CancelTransfer(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
+	id := ps.ByName("id")
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid transfer id format"))
+		return
+	}
+	transfer, err := t.transfers.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no transfer with id of %s", id))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// the owner who started it or an admin
+	if !cUser.CanOn(models.BootcampTransfer, transfer.From) {
+		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
+		return
+	}
+
+	err = t.transfers.Close(transfer, models.TransferCancelled, cUser.Id, "")
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    transfer,
+	})
+}
+
+// live bootcamp with id that the user can transfer, the error is sent otherwise
+func (t *Transfer) ownedBootcamp(w http.ResponseWriter, user *models.User, id string) (*models.Bootcamp, bool) {
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid bootcamp id format"))
+		return nil, false
+	}
+
+	bootcamp, err := t.bootcamps.FindId(bson.ObjectIdHex(id))
+	if err == models.ErrNotFound || (err == nil && bootcamp.Deleted) {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no bootcamp with id of %s", id))
+		return nil, false
+	} else if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
+		return nil, false
+	}
+
+	if !canOnBootcamp(t.orgs, user, models.BootcampTransfer, bootcamp) {
+		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission"))
+		return nil, false
+	}
+	return bootcamp, true
+}
+
+// transfer with id to the user, the error is sent otherwise
+func (t *Transfer) receivedTransfer(w http.ResponseWriter, user *models.User, id string) (*models.Transfer, bool) {
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid transfer id format"))
+		return nil, false
+	}
+
+	transfer, err := t.transfers.FindId(bson.ObjectIdHex(id))
+	// the transfers of the others are not found
+	if err == models.ErrNotFound || (err == nil && transfer.To != user.Id) {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no transfer with id of %s", id))
+		return nil, false
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return nil, false
+	}
+	return transfer, true
+}
+
+// check the user can own one more bootcamp, the error is sent otherwise
+func (t *Transfer) canOwn(w http.ResponseWriter, user *models.User) bool {
+	if !user.Can(models.BootcampCreate) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("the new owner must be a publisher"))
+		return false
+	}
+	n, err := t.bootcamps.Count(bson.M{"user": user.Id, "deleted": false})
+	if err == nil {
+		err = user.CheckQuota(models.QuotaBootcamps, n)
+	}
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"devcamper/models"
+	"devcamper/utils"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Trash struct {
+	trash     models.TrashRepo
+	bootcamps models.BootcampRepo
+	courses   models.CourseRepo
+	reviews   models.ReviewRepo
+	users     models.UserRepo
+}
+
+func NewTrash(trash models.TrashRepo, bootcamps models.BootcampRepo, courses models.CourseRepo, reviews models.ReviewRepo, users models.UserRepo) *Trash {
+	return &Trash{
+		trash:     trash,
+		bootcamps: bootcamps,
+		courses:   courses,
+		reviews:   reviews,
+		users:     users,
+	}
+}
+
+// @desc    Get deleted bootcamps, courses, reviews or users
+// @route   GET /api/v1/trash/:collection
+// @access  Private/Admin
+func (t *Trash) GetTrash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// parse form
+	err := r.ParseForm()
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad request data"))
+		return
+	}
+
+	collection := ps.ByName("collection")
+	var repo models.Queryable
+	switch collection {
+	case "bootcamps":
+		repo = t.bootcamps
+	case "courses":
+		repo = t.courses
+	case "reviews":
+		repo = t.reviews
+	case "users":
+		repo = t.users
+	default:
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no trash for %s", collection))
+		return
+	}
+
+	// create advance query, on the deleted documents only
+	query, pagination, err := models.AdvanceQuery(r.Form, repo, bson.M{"deleted": true})
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	var docs interface{}
+	count := 0
+	switch collection {
+	case "bootcamps":
+		bootcamps, e := t.bootcamps.Find(query)
+		docs, count, err = bootcamps, len(bootcamps), e
+	case "courses":
+		courses, e := t.courses.Find(query)
+		docs, count, err = courses, len(courses), e
+	case "reviews":
+		reviews, e := t.reviews.Find(query)
+		docs, count, err = reviews, len(reviews), e
+	case "users":
+		users, e := t.users.Find(query)
+		docs, count, err = users, len(users), e
+	}
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// cursors and links of the next and previous pages
+	err = pagination.Cursors(docs)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	pagination.SetHeaders(w, r.URL)
+
+	// prepare response data
+	respData := map[string]interface{}{
+		"success":    true,
+		"count":      count,
+		"pagination": pagination,
+	}
+
+	// only the fields asked by select
+	data, err := query.Project(docs)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	respData["data"] = data
+
+	utils.SendJSON(w, http.StatusOK, respData)
+}
+
+// @desc    Restore a deleted document with the documents deleted with it
+// @route   POST /api/v1/trash/:collection/:id/restore
+// @access  Private/Admin
+// the restored documents count against the quotas of their owners, also for an admin
+func (t *Trash) RestoreTrash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	collection := ps.ByName("collection")
+	id := ps.ByName("id")
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid id format"))
+		return
+	}
+
+	err := t.checkQuotas(collection, bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no deleted document in %s with id of %s", collection, id))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	report, err := t.trash.Restore(collection, bson.ObjectIdHex(id))
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no deleted document in %s with id of %s", collection, id))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// the averages of the bootcamp count the restored courses and reviews
+	switch collection {
+	case "bootcamps":
+		t.updateAverages(bson.ObjectIdHex(id))
+	case "courses":
+		if course, err := t.courses.FindId(bson.ObjectIdHex(id)); err == nil {
+			t.updateAverages(course.Bootcamp.(bson.ObjectId))
+		}
+	case "reviews":
+		if review, err := t.reviews.FindId(bson.ObjectIdHex(id)); err == nil {
+			t.updateAverages(review.Bootcamp.(bson.ObjectId))
+		}
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// check the restored documents fit in the quotas of their owners, a *models.QuotaError otherwise
+func (t *Trash) checkQuotas(collection string, id bson.ObjectId) error {
+	switch collection {
+	case "bootcamps":
+		bootcamp, err := t.bootcamps.FindId(id)
+		if err == nil && !bootcamp.Deleted {
+			err = models.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		owner, err := t.quotaUser(bootcamp.User)
+		if owner == nil || err != nil {
+			return err
+		}
+		n, err := t.bootcamps.Count(bson.M{"user": owner.Id, "deleted": false})
+		if err != nil {
+			return err
+		}
+		err = owner.CheckQuota(models.QuotaBootcamps, n)
+		if err != nil || bootcamp.DeletionBatch == "" {
+			return err
+		}
+		// the courses of its deletion batch come back with it
+		n, err = t.courses.Count(bson.M{"bootcamp": id, "deleted": false})
+		if err != nil {
+			return err
+		}
+		restored, err := t.courses.Count(bson.M{"deletionBatch": bootcamp.DeletionBatch, "deleted": true})
+		if err != nil {
+			return err
+		}
+		return owner.CheckQuotaN(models.QuotaCoursesPerBootcamp, n, restored)
+	case "courses":
+		course, err := t.courses.FindId(id)
+		if err == nil && !course.Deleted {
+			err = models.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		bootcampId, ok := course.Bootcamp.(bson.ObjectId)
+		if !ok {
+			return nil
+		}
+		bootcamp, err := t.bootcamps.FindId(bootcampId)
+		if err != nil {
+			return err
+		}
+		owner, err := t.quotaUser(bootcamp.User)
+		if owner == nil || err != nil {
+			return err
+		}
+		n, err := t.courses.Count(bson.M{"bootcamp": bootcampId, "deleted": false})
+		if err != nil {
+			return err
+		}
+		return owner.CheckQuota(models.QuotaCoursesPerBootcamp, n)
+	case "reviews":
+		review, err := t.reviews.FindId(id)
+		if err == nil && !review.Deleted {
+			err = models.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		user, err := t.quotaUser(review.User)
+		if user == nil || err != nil {
+			return err
+		}
+		n, err := t.reviews.Count(bson.M{"bootcamp": review.Bootcamp, "user": user.Id, "deleted": false})
+		if err != nil {
+			return err
+		}
+		return user.CheckQuota(models.QuotaReviewsPerBootcamp, n)
+	}
+	return nil
+}
+
+// user whose quota counts the document, nil when it is gone
+func (t *Trash) quotaUser(userId interface{}) (*models.User, error) {
+	id, ok := userId.(bson.ObjectId)
+	if !ok {
+		return nil, nil
+	}
+	user, err := t.users.FindId(id)
+	if err == models.ErrNotFound {
+		return nil, nil
+	}
+	return user, err
+}
+
+// update average cost and rating of bootcamp
+func (t *Trash) updateAverages(bootcampId bson.ObjectId) {
+	bootcamp, err := t.bootcamps.FindId(bootcampId)
+	if err != nil {
+		log.Printf("find bootcamp %s: %v\n", bootcampId.Hex(), err)
+		return
+	}
+	bootcamp.AverageCost, err = t.courses.AverageCost(bootcampId)
+	if err != nil {
+		log.Printf("average cost of bootcamp %s: %v\n", bootcampId.Hex(), err)
+		return
+	}
+	bootcamp.AverageRating, err = t.reviews.AverageRating(bootcampId)
+	if err != nil {
+		log.Printf("average rating of bootcamp %s: %v\n", bootcampId.Hex(), err)
+		return
+	}
+	err = t.bootcamps.Save(bootcamp)
+	if err != nil {
+		log.Printf("save bootcamp %s: %v\n", bootcampId.Hex(), err)
+	}
+}
@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"devcamper/middleware"
+	"devcamper/models"
+	"devcamper/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorLogin struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+type DisableTwoFactor struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type TwoFactorPolicy struct {
+	RequireTwoFactor *bool `json:"requireTwoFactor"`
+}
+
+// @desc    Login with two factor code after password check
+// @route   POST /api/v1/auth/login/2fa
+// @access  Public
+func (u *User) LoginTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	login := TwoFactorLogin{}
+	json.NewDecoder(r.Body).Decode(&login)
+	if len(login.ChallengeToken) == 0 || (len(login.Code) == 0 && len(login.RecoveryCode) == 0) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide challenge token and code or recovery code"))
+		return
+	}
+
+	payload, err := u.tokens.ParseChallenge(login.ChallengeToken)
+	if err != nil || !bson.IsObjectIdHex(payload.Id) {
+		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid or expired challenge token, please login again"))
+		return
+	}
+
+	query := bson.M{
+		"_id":     bson.ObjectIdHex(payload.Id),
+		"deleted": false,
+	}
+	user, err := u.users.FindOne(query)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid or expired challenge token, please login again"))
+		return
+	}
+
+	// codes are guessed the same way as passwords
+	keys := u.loginAttemptKeys(r, user.Email)
+	if u.tooManyAttempts(w, keys...) {
+		return
+	}
+
+	var ok bool
+	if len(login.Code) > 0 {
+		ok = user.VerifyTwoFactorCode(login.Code)
+	} else {
+		ok = user.UseRecoveryCode(u.config.JWTSecret, login.RecoveryCode)
+	}
+	if !ok {
+		u.attemptFailed(keys...)
+		utils.ErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidTwoFactorCode)
+		return
+	}
+	// keep the used step and recovery codes
+	err = u.users.Save(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	u.attemptSucceeded(keys[0])
+	u.sendToken(w, r, user)
+}
+
+// @desc    Start two factor enrollment
+// @route   POST /api/v1/auth/2fa/setup
+// @access  Private
+func (u *User) SetupTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := middleware.CurrentUser(r)
+	if user.TwoFactor.Enabled {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("two factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := user.SetupTwoFactor()
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, errors.New("server error"))
+		return
+	}
+	err = u.users.Save(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"secret": secret,
+			"uri":    utils.TOTPURI(u.config.TOTPIssuer, user.Email, secret),
+		},
+	})
+}
+
+// @desc    Confirm two factor enrollment with the first code
+// @route   POST /api/v1/auth/2fa/confirm
+// @access  Private
+func (u *User) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := middleware.CurrentUser(r)
+	if user.TwoFactor.Enabled {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("two factor authentication is already enabled"))
+		return
+	}
+
+	code := TwoFactorCode{}
+	json.NewDecoder(r.Body).Decode(&code)
+	if len(code.Code) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide code"))
+		return
+	}
+
+	recoveryCodes, err := user.ConfirmTwoFactor(u.config.JWTSecret, code.Code)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	err = u.users.Save(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	// recovery codes are shown only once
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"recoveryCodes": recoveryCodes,
+		},
+	})
+}
+
+// @desc    Replace recovery codes
+// @route   POST /api/v1/auth/2fa/recoverycodes
+// @access  Private
+func (u *User) RegenRecoveryCodes(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := middleware.CurrentUser(r)
+
+	code := TwoFactorCode{}
+	json.NewDecoder(r.Body).Decode(&code)
+	if !user.VerifyTwoFactorCode(code.Code) {
+		utils.ErrorResponse(w, http.StatusBadRequest, models.ErrInvalidTwoFactorCode)
+		return
+	}
+
+	recoveryCodes := user.GenRecoveryCodes(u.config.JWTSecret)
+	err := u.users.Save(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"recoveryCodes": recoveryCodes,
+		},
+	})
+}
+
+// @desc    Disable two factor authentication
+// @route   DELETE /api/v1/auth/2fa
+// @access  Private
+func (u *User) DisableTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := middleware.CurrentUser(r)
+	if !user.TwoFactor.Enabled {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("two factor authentication is not enabled"))
+		return
+	}
+
+	required, err := u.policies.IsTwoFactorRequired(user.Role)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+	if required {
+		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("two factor authentication is required for %s role", user.Role))
+		return
+	}
+
+	disable := DisableTwoFactor{}
+	json.NewDecoder(r.Body).Decode(&disable)
+	if !user.MatchPassword(disable.Password) || !user.VerifyTwoFactorCode(disable.Code) {
+		utils.ErrorResponse(w, http.StatusForbidden, errors.New("invalid password or code"))
+		return
+	}
+
+	user.DisableTwoFactor()
+	err = u.users.Save(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    user,
+	})
+}
+
+// @desc    Get two factor policy of role
+// @route   GET /api/v1/roles/:role/twofactor
+// @access  Private/Admin
+func (u *User) GetTwoFactorPolicy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	role := ps.ByName("role")
+	if !isRole(role) {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no role %s", role))
+		return
+	}
+
+	required, err := u.policies.IsTwoFactorRequired(role)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"role":             role,
+			"requireTwoFactor": required,
+		},
+	})
+}
+
+// @desc    Require two factor authentication for role
+// @route   PUT /api/v1/roles/:role/twofactor
+// @access  Private/Admin
+func (u *User) SetTwoFactorPolicy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	role := ps.ByName("role")
+	if !isRole(role) {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no role %s", role))
+		return
+	}
+
+	policy := TwoFactorPolicy{}
+	json.NewDecoder(r.Body).Decode(&policy)
+	if policy.RequireTwoFactor == nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide requireTwoFactor"))
+		return
+	}
+
+	rolePolicy, err := u.policies.SetTwoFactorRequired(role, *policy.RequireTwoFactor)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    rolePolicy,
+	})
+}
+
+func isRole(role string) bool {
+	for _, v := range models.Roles {
+		if v == role {
+			return true
+		}
+	}
+	return false
+}
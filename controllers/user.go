@@ -1,16 +1,15 @@
 package controllers
 
 import (
+	"devcamper/middleware"
 	"devcamper/models"
 	"devcamper/utils"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/zebresel-com/mongodm"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -18,16 +17,6 @@ import (
 // @route   GET /api/v1/users
 // @access  Private/Admin
 func (u *User) GetUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(u.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
-
 	// parse form
 	err := r.ParseForm()
 	if err != nil {
@@ -36,19 +25,25 @@ func (u *User) GetUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 	}
 
 	// create advance query
-	query, pagination, err := models.AdvanceQuery(r.Form, u.connection.Model("User"))
+	query, pagination, err := models.AdvanceQuery(r.Form, u.users)
 	if err != nil {
-		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad request data"))
+		utils.ErrorHandler(w, err)
 		return
 	}
 
-	users := []*models.User{}
+	users, err := u.users.Find(query)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
 
-	err = query.Exec(&users)
+	// cursors and links of the next and previous pages
+	err = pagination.Cursors(users)
 	if err != nil {
 		utils.ErrorHandler(w, err)
 		return
 	}
+	pagination.SetHeaders(w, r.URL)
 
 	// prepare response data
 	respData := map[string]interface{}{
@@ -57,14 +52,13 @@ func (u *User) GetUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		"pagination": pagination,
 	}
 
-	// hide data that user not request
-	selectField := r.Form["select"]
-	if len(selectField) != 0 {
-		selects := strings.Split(selectField[0], ",")
-		respData["data"] = models.ExtractSelectField(users, selects)
-	} else {
-		respData["data"] = users
+	// only the fields asked by select
+	data, err := query.Project(users)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
 	}
+	respData["data"] = data
 
 	utils.SendJSON(w, http.StatusOK, respData)
 
@@ -74,31 +68,18 @@ func (u *User) GetUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 // @route   GET /api/v1/users/:id
 // @access  Private/Admin
 func (u *User) GetUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(u.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
-
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid user id format"))
 		return
 	}
 
-	User := u.connection.Model("User")
-	user := &models.User{}
-
 	query := bson.M{
 		"_id":     bson.ObjectIdHex(id),
 		"deleted": false,
 	}
-	err := User.FindOne(query).Exec(user)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no user with id of %s", id))
 		return
 	} else if err != nil {
@@ -116,31 +97,26 @@ func (u *User) GetUser(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 // @route   POST /api/v1/users
 // @access  Private/Admin
 func (u *User) CreateUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(u.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
-
-	User := u.connection.Model("User")
-	user := &models.User{}
-	User.New(user)
+	user := u.users.New()
 
 	err := json.NewDecoder(r.Body).Decode(user)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad data request"))
 		return
 	}
+	if !models.CanRegisterAs(user.Role) && !middleware.CurrentUser(r).Can(models.RoleAssign) {
+		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission to assign role"))
+		return
+	}
+	// 2FA is enrolled by the user, the quotas are set by their own route
+	user.TwoFactor = models.TwoFactor{}
+	user.Quotas = nil
 	if valid, issues := user.ValidateCreate(); !valid {
 		utils.ErrorResponse(w, http.StatusBadRequest, issues...)
 		return
 	}
 	// check if the email is unique (the email of deleted user should not be reuse for resore account feature)
-	if n, _ := User.Find(bson.M{"email": user.Email}).Count(); n > 0 {
+	if n, _ := u.users.Count(bson.M{"email": user.Email}); n > 0 {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("email %s was taken, please use the new one", user.Email))
 		return
 	}
@@ -149,7 +125,7 @@ func (u *User) CreateUser(w http.ResponseWriter, r *http.Request, ps httprouter.
 		utils.ErrorResponse(w, http.StatusBadRequest, err)
 		return
 	}
-	err = user.Save()
+	err = u.users.Save(user)
 	if err != nil {
 		utils.ErrorHandler(w, err)
 		return
@@ -165,31 +141,19 @@ func (u *User) CreateUser(w http.ResponseWriter, r *http.Request, ps httprouter.
 // @route   PUT /api/v1/users/:id
 // @access  Private/Admin
 func (u *User) UpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(u.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
-	}
-	if !cUser.IsUserInRoles("admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
-		return
-	}
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid user id format"))
 		return
 	}
 
-	User := u.connection.Model("User")
-	user := &models.User{}
-
 	query := bson.M{
 		"_id":     bson.ObjectIdHex(id),
 		"deleted": false,
 	}
 
-	err := User.FindOne(query).Exec(user)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no user with id of %s", id))
 		return
 	} else if err != nil {
@@ -202,6 +166,14 @@ func (u *User) UpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("bad data"))
 	}
+	if _, ok := d["role"]; ok && !middleware.CurrentUser(r).Can(models.RoleAssign) {
+		utils.ErrorResponse(w, http.StatusForbidden, errors.New("you do not have permission to assign role"))
+		return
+	}
+
+	// 2FA is managed by the user only, the quotas by their own route
+	delete(d, "twoFactor")
+	delete(d, "quotas")
 
 	// The Update method is incompleted so the error is not handled
 	// see https://github.com/zebresel-com/mongodm/issues/20
@@ -218,7 +190,7 @@ func (u *User) UpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.
 			"$ne": user.Id,
 		},
 	}
-	if n, _ := User.Find(query).Count(); n > 0 {
+	if n, _ := u.users.Count(query); n > 0 {
 		utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("email %s was taken, please use the new one", user.Email))
 		return
 	}
@@ -231,7 +203,7 @@ func (u *User) UpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.
 			return
 		}
 	}
-	err = user.Save()
+	err = u.users.Save(user)
 	if err != nil {
 		utils.ErrorHandler(w, err)
 		return
@@ -247,31 +219,108 @@ func (u *User) UpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.
 // @route   DELETE /api/v1/users/:id
 // @access  Private/Admin
 func (u *User) DeleteUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cUser := getCurrentUser(u.connection, r)
-	if cUser == nil {
-		utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	id := ps.ByName("id")
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid user id format"))
 		return
 	}
-	if !cUser.IsUserInRoles("admin") {
-		utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", cUser.Role))
+
+	query := bson.M{
+		"_id":     bson.ObjectIdHex(id),
+		"deleted": false,
+	}
+
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no user with id of %s", id))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	err = u.users.SoftDelete(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
 		return
 	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    nil,
+	})
+}
+
+// @desc    Assign role to user
+// @route   PUT /api/v1/users/:id/role
+// @access  Private/Admin
+func (u *User) AssignRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cUser := middleware.CurrentUser(r)
+
 	id := ps.ByName("id")
 	if !bson.IsObjectIdHex(id) {
 		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid user id format"))
 		return
 	}
+	// prevent the last admin from locking everyone out
+	if bson.ObjectIdHex(id) == cUser.Id {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("cannot change your own role"))
+		return
+	}
 
-	User := u.connection.Model("User")
-	user := &models.User{}
+	assignRole := AssignRole{}
+	json.NewDecoder(r.Body).Decode(&assignRole)
+	if len(assignRole.Role) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide role"))
+		return
+	}
 
 	query := bson.M{
 		"_id":     bson.ObjectIdHex(id),
 		"deleted": false,
 	}
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no user with id of %s", id))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	user.Role = assignRole.Role
+	if valid, issues := user.ValidateUpdate(); !valid {
+		utils.ErrorResponse(w, http.StatusBadRequest, issues...)
+		return
+	}
+	err = u.users.Save(user)
+	if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    user,
+	})
+}
 
-	err := User.FindOne(query).Exec(user)
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+// @desc    Get quotas of user
+// @route   GET /api/v1/users/:id/quotas
+// @access  Private/Admin
+func (u *User) GetQuotas(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid user id format"))
+		return
+	}
+
+	query := bson.M{
+		"_id":     bson.ObjectIdHex(id),
+		"deleted": false,
+	}
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
 		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no user with id of %s", id))
 		return
 	} else if err != nil {
@@ -279,8 +328,63 @@ func (u *User) DeleteUser(w http.ResponseWriter, r *http.Request, ps httprouter.
 		return
 	}
 
-	user.SetDeleted(true)
-	err = user.Save()
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    quotasData(user),
+	})
+}
+
+// @desc    Override quotas of user, null gives back the quota of the role
+// @route   PUT /api/v1/users/:id/quotas
+// @access  Private/Admin
+func (u *User) SetQuotas(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if !bson.IsObjectIdHex(id) {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid user id format"))
+		return
+	}
+
+	var quotas map[string]*int
+	err := json.NewDecoder(r.Body).Decode(&quotas)
+	if err != nil || len(quotas) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, errors.New("please provide the quotas"))
+		return
+	}
+	for name, limit := range quotas {
+		if !models.IsQuota(name) {
+			utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("no quota %s", name))
+			return
+		}
+		if limit != nil && *limit < models.Unlimited {
+			utils.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("%s should be %d (no limit) or more", name, models.Unlimited))
+			return
+		}
+	}
+
+	query := bson.M{
+		"_id":     bson.ObjectIdHex(id),
+		"deleted": false,
+	}
+	user, err := u.users.FindOne(query)
+	if err == models.ErrNotFound {
+		utils.ErrorResponse(w, http.StatusNotFound, fmt.Errorf("no user with id of %s", id))
+		return
+	} else if err != nil {
+		utils.ErrorHandler(w, err)
+		return
+	}
+
+	if user.Quotas == nil {
+		user.Quotas = map[string]int{}
+	}
+	for name, limit := range quotas {
+		if limit == nil {
+			delete(user.Quotas, name)
+		} else {
+			user.Quotas[name] = *limit
+		}
+	}
+	err = u.users.Save(user)
 	if err != nil {
 		utils.ErrorHandler(w, err)
 		return
@@ -288,6 +392,15 @@ func (u *User) DeleteUser(w http.ResponseWriter, r *http.Request, ps httprouter.
 
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
-		"data":    nil,
+		"data":    quotasData(user),
 	})
 }
+
+// overrides of the user and the limits that apply, null for no limit
+func quotasData(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"role":      user.Role,
+		"overrides": user.Quotas,
+		"limits":    user.QuotaLimits(),
+	}
+}
@@ -6,11 +6,11 @@ require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/zebresel-com/mongodm v2.0.1+incompatible
+	golang.org/x/crypto v0.0.0-20210915214749-c084706c2272
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
 )
 
 require (
-	golang.org/x/crypto v0.0.0-20210915214749-c084706c2272 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"context"
+	"devcamper/config"
+	"devcamper/models"
+	"devcamper/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type contextKey string
+
+// key of the logged in user and its session in the request context
+const (
+	userKey    contextKey = "user"
+	sessionKey contextKey = "session"
+	// the role requires 2FA but the user has not enabled it
+	twoFactorPendingKey contextKey = "twoFactorPending"
+)
+
+type Auth struct {
+	users    models.UserRepo
+	sessions models.SessionRepo
+	policies models.RolePolicyRepo
+	tokens   *utils.JWT
+}
+
+func NewAuth(users models.UserRepo, sessions models.SessionRepo, policies models.RolePolicyRepo, c *config.Config) *Auth {
+	return &Auth{
+		users:    users,
+		sessions: sessions,
+		policies: policies,
+		tokens:   utils.NewJWT(c.JWTSecret, c.JWTExpire),
+	}
+}
+
+// allow only logged in user, the user is put in the request context (see CurrentUser)
+func (a *Auth) Protect(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		user, session := a.getUser(r)
+		if user == nil {
+			utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		required, err := a.policies.IsTwoFactorRequired(user.Role)
+		if err != nil {
+			utils.ErrorHandler(w, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userKey, user)
+		ctx = context.WithValue(ctx, sessionKey, session)
+		ctx = context.WithValue(ctx, twoFactorPendingKey, required && !user.TwoFactor.Enabled)
+		next(w, r.WithContext(ctx), ps)
+	}
+}
+
+// allow only user whose role grants one of the actions, must be wrapped by Protect
+// an action without scope (e.g. "bootcamp:update") is granted by both its ":own" and ":any" actions,
+// the handler checks the ownership, actions publishing content also need a verified email
+func Permit(actions ...string) func(httprouter.Handle) httprouter.Handle {
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			user := CurrentUser(r)
+			if user == nil {
+				utils.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+				return
+			}
+			// the user can still reach the routes without Permit, e.g. to enroll 2FA
+			if pending, _ := r.Context().Value(twoFactorPendingKey).(bool); pending {
+				utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("two factor authentication is required for %s role, please enable it", user.Role))
+				return
+			}
+			unverified := false
+			for _, action := range actions {
+				if !user.Can(action) {
+					continue
+				}
+				if models.NeedVerifiedEmail(action) && !user.EmailVerified {
+					unverified = true
+					continue
+				}
+				next(w, r, ps)
+				return
+			}
+			if unverified {
+				utils.ErrorResponse(w, http.StatusForbidden, errors.New("please verify your email before using this route"))
+				return
+			}
+			utils.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("user with %s role do not autorize for this route", user.Role))
+		}
+	}
+}
+
+// logged in user of the request, nil when the route is not protected
+func CurrentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userKey).(*models.User)
+	return user
+}
+
+// login session of the request, nil when the route is not protected
+func CurrentSession(r *http.Request) *models.Session {
+	session, _ := r.Context().Value(sessionKey).(*models.Session)
+	return session
+}
+
+// find the user and the session of the token in the Authorization header or cookie
+// the session must still be active so a revoked session cannot be used until the token expires
+func (a *Auth) getUser(r *http.Request) (*models.User, *models.Session) {
+	var token string
+	// grab token from header
+	if auth := strings.Fields(r.Header.Get("Authorization")); len(auth) == 2 && auth[0] == "Bearer" {
+		token = auth[1]
+		// grab token from cookie
+	} else if c, err := r.Cookie("token"); err == nil {
+		token = c.Value
+	}
+
+	// no token
+	if len(token) == 0 {
+		return nil, nil
+	}
+
+	// validate token
+	payload, err := a.tokens.Parse(token)
+	if err != nil {
+		return nil, nil
+	}
+	// only access tokens are accepted
+	if payload.Purpose != "" || !bson.IsObjectIdHex(payload.Id) || !bson.IsObjectIdHex(payload.SessionId) {
+		return nil, nil
+	}
+
+	// check session
+	session, err := a.sessions.FindId(bson.ObjectIdHex(payload.SessionId))
+	if err != nil || !session.IsActive() || session.User != bson.ObjectIdHex(payload.Id) {
+		return nil, nil
+	}
+
+	// find user
+	query := bson.M{
+		"_id":     bson.ObjectIdHex(payload.Id),
+		"deleted": false,
+	}
+	user, err := a.users.FindOne(query)
+	if err != nil {
+		return nil, nil
+	}
+	return user, session
+}
@@ -1,108 +1,410 @@
 package models
 
 import (
-	"devcamper/utils"
-	"encoding/json"
-	"reflect"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/zebresel-com/mongodm"
 	"gopkg.in/mgo.v2/bson"
 )
 
-// advance result (sort, selct, limit , etc.)
-type queryOption struct {
-	Select string
-	Sort   string
-	Page   int
-	Limit  int
+// type of the values of a field in the url query
+type FieldType int
+
+const (
+	StringField FieldType = iota
+	NumberField
+	BoolField
+	// RFC 3339 time or a day (2006-01-02)
+	DateField
+	IdField
+)
+
+// fields of a model usable in the url query
+type QueryFields struct {
+	// document the select param is checked against, by the json names of its fields
+	Model  interface{}
+	Filter map[string]FieldType
+	Sort   []string
+	// relations the expand param can replace by the related documents
+	Expand map[string]Relation
+	// stored fields of the text index searched by the q param, no search when empty
+	Text []string
+}
+
+func (f QueryFields) sortable(field string) bool {
+	for _, v := range f.Sort {
+		if v == field {
+			return true
+		}
+	}
+	return false
+}
+
+// repository the url query runs on
+type Queryable interface {
+	Count(filter bson.M) (int, error)
+	QueryFields() QueryFields
+}
+
+// bad url query, the message tells which param is wrong
+type QueryError struct {
+	message string
+}
+
+func (e *QueryError) Error() string {
+	return e.message
 }
 
-func AdvanceQuery(urlQuery map[string][]string, Model *mongodm.Model) (*mongodm.Query, Pagination, error) {
+func (e *QueryError) Status() int {
+	return http.StatusBadRequest
+}
+
+func queryErrorf(format string, a ...interface{}) error {
+	return &QueryError{fmt.Sprintf(format, a...)}
+}
+
+// params of the url query that are not filters
+var queryOptions = map[string]bool{
+	"select": true,
+	"sort":   true,
+	"page":   true,
+	"limit":  true,
+	"cursor": true,
+	"expand": true,
+	"q":      true,
+}
+
+// most documents of a page
+const MaxLimit = 100
+
+// build query from url query (field=value, field[op]=value, q, select, sort, page or cursor and limit),
+// only the fields of the repo can be filtered and sorted, extra filters are merged into the query
+func AdvanceQuery(urlQuery map[string][]string, repo Queryable, filters ...bson.M) (Query, Pagination, error) {
 	// init return data
 	var pagination Pagination
+	fields := repo.QueryFields()
 
-	// extract data from url query
-	rawQuery := utils.ExtractData(utils.ConvQuery(urlQuery))
-	rawQuery = utils.CleanData(rawQuery)
-	bs, err := json.Marshal(rawQuery)
-	if err != nil {
-		return nil, pagination, err
+	// create filter
+	query := bson.M{}
+	for key, values := range urlQuery {
+		if queryOptions[key] {
+			continue
+		}
+		field, op := splitParam(key)
+		fieldType, ok := fields.Filter[field]
+		if !ok {
+			return Query{}, pagination, queryErrorf("cannot filter by %s", field)
+		}
+		cond, err := condition(op, fieldType, values)
+		if err != nil {
+			return Query{}, pagination, queryErrorf("%s: %s", key, err)
+		}
+		conds, _ := query[field].(bson.M)
+		if conds == nil {
+			conds = bson.M{}
+		}
+		for k, v := range cond {
+			conds[k] = v
+		}
+		query[field] = conds
 	}
-	// load query to struct
-	queryOption := &queryOption{}
-	err = json.Unmarshal(bs, queryOption)
-	if err != nil {
-		return nil, pagination, err
+	// plain equality when there is no other operator
+	for field, v := range query {
+		conds := v.(bson.M)
+		if eq, ok := conds["$eq"]; ok && len(conds) == 1 {
+			query[field] = eq
+		}
 	}
-
-	// create query
-	query := rawQuery
 	// add deleted field
 	query["deleted"] = false
-	// delete options field
-	delete(query, "select")
-	delete(query, "sort")
-	delete(query, "page")
-	delete(query, "limit")
+	for _, filter := range filters {
+		for k, v := range filter {
+			query[k] = v
+		}
+	}
+
+	// text search, the most relevant first unless sorted otherwise
+	search := strings.TrimSpace(firstParam(urlQuery["q"]))
+	if search != "" {
+		if len(fields.Text) == 0 {
+			return Query{}, pagination, queryErrorf("cannot search")
+		}
+		query["$text"] = bson.M{"$search": search}
+	}
 
 	// init query
-	q := Model.Find(query)
+	q := Query{
+		Filter: query,
+		search: search,
+		text:   fields.Text,
+	}
 
-	// select fields
-	if queryOption.Select != "" {
-		selects := strings.Split(queryOption.Select, ",")
-		selectQuery := bson.M{}
-		for _, v := range selects {
-			selectQuery[v] = 1
+	// fields of the documents, by their json names
+	if s := firstParam(urlQuery["select"]); s != "" {
+		projection, err := parseSelect(s, fields.Model)
+		if err != nil {
+			return Query{}, pagination, err
 		}
-		q = q.Select(selectQuery)
+		q.projection = projection
 	}
 
-	// sort
-	if queryOption.Sort != "" {
-		sorts := strings.Split(queryOption.Sort, ",")
-		q = q.Sort(sorts...)
-	} else {
-		q = q.Sort("-createdAt")
+	// one to many relations asked by expand are joined by the query, the others are
+	// expanded after it
+	if s := firstParam(urlQuery["expand"]); s != "" {
+		expand, err := parseExpand(s, fields.Expand)
+		if err != nil {
+			return Query{}, pagination, err
+		}
+		for name, sel := range expand {
+			if rel := fields.Expand[name]; rel.ForeignField != "" {
+				q.joins = append(q.joins, join{as: name, relation: rel, fields: sel})
+			}
+		}
 	}
 
-	// pagination
-	page := queryOption.Page
-	limit := queryOption.Limit
-	// set default if not provided
-	if page == 0 {
-		page = 1
+	// sort, newest first by default, the _id breaks the ties so the order is stable
+	sort := []string{"-createdAt"}
+	if search != "" {
+		sort = []string{textScoreSort, "-createdAt"}
+	}
+	if s := firstParam(urlQuery["sort"]); s != "" {
+		sort = strings.Split(s, ",")
+		for _, v := range sort {
+			if field := strings.TrimLeft(v, "+-"); !fields.sortable(field) {
+				return Query{}, pagination, queryErrorf("cannot sort by %s", field)
+			}
+		}
+	}
+	idKey := "_id"
+	if strings.HasPrefix(sort[len(sort)-1], "-") {
+		idKey = "-_id"
+	}
+	sort = append(sort, idKey)
+	q.Sort = sort
+	// the score is not stored, there is no cursor for the relevance order
+	if sort[0] != textScoreSort {
+		pagination.sort = sort
 	}
-	if limit == 0 {
-		limit = 100
+	// the cursors are made of the sort keys, the joined documents come with the others
+	if q.projection != nil {
+		var keep []string
+		for _, v := range pagination.sort {
+			keep = append(keep, strings.TrimLeft(v, "+-"))
+		}
+		for _, j := range q.joins {
+			keep = append(keep, j.as)
+		}
+		q.Select = q.projection.bson(keep)
+	}
+	// MongoDB sorts by the score of the documents projected with it
+	if sort[0] == textScoreSort {
+		if q.Select == nil {
+			q.Select = bson.M{}
+		}
+		q.Select["score"] = bson.M{"$meta": "textScore"}
 	}
 
+	// pagination, default to the first 100
+	limit, err := positiveParam(urlQuery, "limit", MaxLimit)
+	if err != nil {
+		return Query{}, pagination, err
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	total, err := repo.Count(query)
+	if err != nil {
+		return Query{}, pagination, err
+	}
+	q.Limit = limit
+
+	// page after (or before) a cursor of a previous response
+	if s := firstParam(urlQuery["cursor"]); s != "" {
+		if len(urlQuery["page"]) > 0 {
+			return Query{}, pagination, queryErrorf("use either cursor or page")
+		}
+		if pagination.sort == nil {
+			return Query{}, pagination, queryErrorf("no cursor for the results sorted by relevance")
+		}
+		c, err := decodeCursor(s)
+		if err != nil {
+			return Query{}, pagination, queryErrorf("invalid cursor")
+		}
+		if !c.matches(sort) {
+			return Query{}, pagination, queryErrorf("the cursor was made for another sort")
+		}
+		// a text search stays at the top of the filter
+		rest := bson.M{}
+		for k, v := range query {
+			if k != "$text" {
+				rest[k] = v
+			}
+		}
+		q.Filter = bson.M{"$and": []bson.M{rest, c.filter()}}
+		if search != "" {
+			q.Filter["$text"] = query["$text"]
+		}
+		if c.Prev {
+			q.Sort = reverseSort(sort)
+		}
+		remaining, err := repo.Count(q.Filter)
+		if err != nil {
+			return Query{}, pagination, err
+		}
+		pagination.fillCursor(c, limit, total, remaining)
+		return q, pagination, nil
+	}
+
+	page, err := positiveParam(urlQuery, "page", 1)
+	if err != nil {
+		return Query{}, pagination, err
+	}
 	startIndex := (page - 1) * limit
 	endIndex := page * limit
-	total, _ := Model.Find(bson.M{"deleted": false}).Count()
 
 	pagination.Fill(page, limit, startIndex, endIndex, total)
 
-	q.Skip(startIndex).Limit(limit)
+	q.Skip = startIndex
 
 	return q, pagination, nil
 }
 
-func ExtractSelectField(models interface{}, selects []string) []map[string]interface{} {
-	// access value of struct field name using reflect
-	refVal := reflect.ValueOf(models)
-	showFieldModels := make([]map[string]interface{}, refVal.Len())
-	for i := 0; i < refVal.Len(); i++ {
-		v := map[string]interface{}{}
-		for _, fieldName := range selects {
-			nameInStruc := strings.ToUpper(fieldName[:1]) + strings.ToLower(fieldName[1:])
-			// check if the struct have the field name
-			if val := refVal.Index(i).Elem().FieldByName(nameInStruc); val.IsValid() {
-				v[fieldName] = val.Interface()
+// "price[gte]" to price and gte, a param without operator is eq
+func splitParam(key string) (string, string) {
+	i := strings.Index(key, "[")
+	if i < 0 || !strings.HasSuffix(key, "]") {
+		return key, "eq"
+	}
+	return key[:i], key[i+1 : len(key)-1]
+}
+
+// mongo condition of a filter param, values are parsed to the type of the field
+func condition(op string, fieldType FieldType, values []string) (bson.M, error) {
+	switch op {
+	case "eq":
+		// a day matches the whole day
+		if fieldType == DateField && len(values) == 1 {
+			if day, ok := parseDay(values[0]); ok {
+				return bson.M{"$gte": day, "$lt": day.AddDate(0, 0, 1)}, nil
+			}
+		}
+		if len(values) > 1 {
+			return condition("in", fieldType, values)
+		}
+		v, err := parseValue(fieldType, values[0])
+		return bson.M{"$eq": v}, err
+	case "ne":
+		v, err := parseValue(fieldType, lastParam(values))
+		return bson.M{"$ne": v}, err
+	case "gt", "gte", "lt", "lte":
+		v, err := parseValue(fieldType, lastParam(values))
+		if err != nil {
+			return nil, err
+		}
+		// a day is included by lte and excluded by gt
+		if day, ok := parseDay(lastParam(values)); ok && fieldType == DateField {
+			switch op {
+			case "lte":
+				return bson.M{"$lt": day.AddDate(0, 0, 1)}, nil
+			case "gt":
+				return bson.M{"$gte": day.AddDate(0, 0, 1)}, nil
 			}
 		}
-		showFieldModels[i] = v
+		return bson.M{"$" + op: v}, nil
+	case "in", "nin":
+		// role[in]=user&role[in]=publisher or role[in]=user,publisher
+		list := []interface{}{}
+		for _, value := range values {
+			for _, s := range strings.Split(value, ",") {
+				v, err := parseValue(fieldType, s)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+		}
+		return bson.M{"$" + op: list}, nil
+	case "regex":
+		if fieldType != StringField {
+			return nil, errors.New("regex needs a text field")
+		}
+		// case-insensitive contains, the value is not a pattern
+		return bson.M{"$regex": regexp.QuoteMeta(lastParam(values)), "$options": "i"}, nil
+	case "exists":
+		v, err := strconv.ParseBool(lastParam(values))
+		if err != nil {
+			return nil, errors.New("exists must be true or false")
+		}
+		return bson.M{"$exists": v}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+func parseValue(fieldType FieldType, s string) (interface{}, error) {
+	switch fieldType {
+	case NumberField:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a number", s)
+		}
+		return v, nil
+	case BoolField:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not true or false", s)
+		}
+		return v, nil
+	case DateField:
+		if day, ok := parseDay(s); ok {
+			return day, nil
+		}
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a date (2006-01-02 or RFC 3339)", s)
+		}
+		return v, nil
+	case IdField:
+		if !bson.IsObjectIdHex(s) {
+			return nil, fmt.Errorf("%s is not an id", s)
+		}
+		return bson.ObjectIdHex(s), nil
+	}
+	return s, nil
+}
+
+// date without time, in UTC
+func parseDay(s string) (time.Time, bool) {
+	day, err := time.Parse("2006-01-02", s)
+	return day, err == nil
+}
+
+func positiveParam(urlQuery map[string][]string, key string, def int) (int, error) {
+	s := firstParam(urlQuery[key])
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 {
+		return 0, queryErrorf("%s must be a positive number", key)
+	}
+	return v, nil
+}
+
+func firstParam(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func lastParam(values []string) string {
+	if len(values) == 0 {
+		return ""
 	}
-	return showFieldModels
+	return values[len(values)-1]
 }
@@ -1,11 +1,16 @@
 package models
 
 import (
+	"devcamper/utils"
 	"fmt"
+	"log"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type GeoJson struct {
@@ -19,16 +24,21 @@ type GeoJson struct {
 	Country          string    `json:"country,omitempty" bson:"country,omitempty"`
 }
 
+// the address could not be geocoded yet, it is kept until a retry succeeds
+const GeocodePending = "pending"
+
 type Bootcamp struct {
 	mongodm.DocumentBase `json:",inline" bson:",inline"`
 	Name                 string        `json:"name" bson:"name" required:"true" maxLen:"50"`
 	Slug                 string        `json:"slug" bson:"slug"`
+	PreviousSlugs        []string      `json:"-" bson:"previousSlugs,omitempty"`
 	Description          string        `json:"description" bson:"description" required:"true" maxLen:"500"`
 	Website              string        `json:"website" bson:"website"`
 	Phone                string        `json:"phone" bson:"phone" maxLen:"20"`
 	Email                string        `json:"email" bson:"email" validation:"email"`
 	Address              string        `json:"address,omitempty" bson:"address,omitempty"`
 	Location             *GeoJson      `json:"location" bson:"location"`
+	GeocodeStatus        string        `json:"geocodeStatus,omitempty" bson:"geocodeStatus,omitempty"`
 	Careers              []string      `json:"careers" bson:"careers" required:"true"`
 	AverageRating        int           `json:"averageRating" bson:"averageRating"`
 	AverageCost          int           `json:"averageCost" bson:"averageCost"`
@@ -39,6 +49,13 @@ type Bootcamp struct {
 	AcceptGi             bool          `json:"acceptGi" bson:"acceptGi"`
 	Courses              []interface{} `json:"courses,omitempty" bson:"-"`
 	User                 interface{}   `json:"user" bson:"user" model:"User" relation:"11" autosave:"true" required:"true"`
+	// its members edit the bootcamp with the owner, see organization.go
+	Organization bson.ObjectId `json:"organization,omitempty" bson:"organization,omitempty"`
+
+	// parts of the text fields matching the q param, see highlight
+	Highlights map[string]string `json:"highlights,omitempty" bson:"-"`
+	// when and with what it was deleted, empty for the live documents
+	Deletion `json:",inline" bson:",inline"`
 }
 
 // override validate function to aviod check before save (will check explicitly)
@@ -117,3 +134,216 @@ func (bc *Bootcamp) validateBothCreateAndUpdate() []error {
 
 	return validationErrors
 }
+
+// bootcamps expanded in other documents, never the slug history, the deletion or the geocode state
+var bootcampRelation = Relation{
+	Model: "Bootcamp",
+	Fields: []string{
+		"name", "slug", "description", "website", "phone", "email", "location", "careers",
+		"averageRating", "averageCost", "photo", "housing", "jobAssistance", "jobGuarantee",
+		"acceptGi", "user", "organization",
+	},
+}
+
+// fields of the bootcamps usable in the url query
+var bootcampQueryFields = QueryFields{
+	Model: Bootcamp{},
+	Filter: map[string]FieldType{
+		"name":             StringField,
+		"description":      StringField,
+		"careers":          StringField,
+		"averageRating":    NumberField,
+		"averageCost":      NumberField,
+		"housing":          BoolField,
+		"jobAssistance":    BoolField,
+		"jobGuarantee":     BoolField,
+		"acceptGi":         BoolField,
+		"location.city":    StringField,
+		"location.state":   StringField,
+		"location.zipcode": StringField,
+		"location.country": StringField,
+		"user":             IdField,
+		"organization":     IdField,
+		"createdAt":        DateField,
+		"updatedAt":        DateField,
+		"deletedAt":        DateField,
+		"deletionBatch":    IdField,
+	},
+	Sort: []string{"name", "averageRating", "averageCost", "createdAt", "updatedAt", "deletedAt"},
+	Expand: map[string]Relation{
+		"user": userRelation,
+		"courses": {
+			Model:        "Course",
+			ForeignField: "bootcamp",
+		},
+	},
+	Text: []string{"name", "description", "careers"},
+}
+
+// bootcamps of the store
+type BootcampRepo interface {
+	// new bootcamp ready to be validated and saved
+	New() *Bootcamp
+	// find by id, the deleted bootcamps included
+	FindId(id bson.ObjectId) (*Bootcamp, error)
+	FindOne(filter bson.M) (*Bootcamp, error)
+	// find by the slug, or by a previous slug with moved true, the deleted bootcamps included
+	FindSlug(slug string) (bootcamp *Bootcamp, moved bool, err error)
+	Find(q Query) ([]*Bootcamp, error)
+	Count(filter bson.M) (int, error)
+	// fields usable in the url query
+	QueryFields() QueryFields
+	// replace the relation ids of docs (a bootcamp or a slice of them) by the documents
+	// asked by the expand param of the url query
+	Expand(docs interface{}, urlQuery map[string][]string) error
+	// set the slug from the name, unique among the current and previous slugs of the other
+	// bootcamps, the previous slug is kept so the old links still lead to the bootcamp
+	SetSlug(bootcamp *Bootcamp) error
+	Save(bootcamp *Bootcamp) error
+	// soft-delete the bootcamp with its courses and reviews in one batch, user is the one
+	// deleting them, a dry run only reports the documents that would be deleted
+	SoftDelete(bootcamp *Bootcamp, user bson.ObjectId, dryRun bool) (*DeletionReport, error)
+}
+
+type bootcampRepo struct {
+	repo
+}
+
+func NewBootcampRepo(store Store) BootcampRepo {
+	r := &bootcampRepo{newRepo(store, "Bootcamp")}
+	// needed by the radius search
+	err := r.c.EnsureIndex(mgo.Index{
+		Key: []string{"$2dsphere:location"},
+	})
+	if err != nil {
+		log.Println("ensure 2dsphere index: ", err)
+	}
+	// needed by the q param
+	err = r.c.EnsureIndex(textIndex(bootcampQueryFields.Text))
+	if err != nil {
+		log.Println("ensure text index: ", err)
+	}
+	// one bootcamp per slug, the previous slugs are looked up by the old links
+	err = r.c.EnsureIndex(mgo.Index{
+		Key:    []string{"slug"},
+		Unique: true,
+	})
+	if err != nil {
+		log.Println("ensure slug index: ", err)
+	}
+	err = r.c.EnsureIndex(mgo.Index{
+		Key: []string{"previousSlugs"},
+	})
+	if err != nil {
+		log.Println("ensure previous slugs index: ", err)
+	}
+	return r
+}
+
+func (r *bootcampRepo) New() *Bootcamp {
+	bootcamp := &Bootcamp{}
+	r.c.Init(bootcamp)
+	return bootcamp
+}
+
+func (r *bootcampRepo) FindId(id bson.ObjectId) (*Bootcamp, error) {
+	bootcamp := &Bootcamp{}
+	err := r.findId(id, bootcamp)
+	if err != nil {
+		return nil, err
+	}
+	return bootcamp, nil
+}
+
+func (r *bootcampRepo) FindOne(filter bson.M) (*Bootcamp, error) {
+	bootcamp := &Bootcamp{}
+	err := r.c.FindOne(filter, bootcamp)
+	if err != nil {
+		return nil, err
+	}
+	return bootcamp, nil
+}
+
+func (r *bootcampRepo) FindSlug(slug string) (*Bootcamp, bool, error) {
+	bootcamp, err := r.FindOne(bson.M{"slug": slug})
+	if err != ErrNotFound {
+		return bootcamp, false, err
+	}
+	bootcamp, err = r.FindOne(bson.M{"previousSlugs": slug})
+	return bootcamp, err == nil, err
+}
+
+func (r *bootcampRepo) Find(q Query) ([]*Bootcamp, error) {
+	bootcamps := []*Bootcamp{}
+	err := r.find(q, &bootcamps)
+	return bootcamps, err
+}
+
+func (r *bootcampRepo) Save(bootcamp *Bootcamp) error {
+	return r.save(bootcamp)
+}
+
+func (r *bootcampRepo) SetSlug(bootcamp *Bootcamp) error {
+	base := utils.Slugify(bootcamp.Name)
+	if base == "" {
+		base = "bootcamp"
+	}
+
+	// first free slug of base, base-2, base-3...
+	slug := base
+	for n := 2; ; n++ {
+		filter := bson.M{
+			"$or": []interface{}{
+				bson.M{"slug": slug},
+				bson.M{"previousSlugs": slug},
+			},
+		}
+		if bootcamp.Id != "" {
+			filter["_id"] = bson.M{"$ne": bootcamp.Id}
+		}
+		taken, err := r.c.Count(filter)
+		if err != nil {
+			return err
+		}
+		if taken == 0 {
+			break
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	if slug == bootcamp.Slug {
+		return nil
+	}
+
+	// the bootcamp may get back a previous slug
+	var previous []string
+	for _, v := range bootcamp.PreviousSlugs {
+		if v != slug {
+			previous = append(previous, v)
+		}
+	}
+	if bootcamp.Slug != "" {
+		previous = append(previous, bootcamp.Slug)
+	}
+	bootcamp.PreviousSlugs = previous
+	bootcamp.Slug = slug
+	return nil
+}
+
+func (r *bootcampRepo) SoftDelete(bootcamp *Bootcamp, user bson.ObjectId, dryRun bool) (*DeletionReport, error) {
+	report, err := r.cascadeDelete("bootcamps", bootcamp.Id, user, bootcampCascade, dryRun)
+	if err == nil && !dryRun {
+		bootcamp.Deleted = true
+		now := time.Now()
+		bootcamp.DeletedAt = &now
+		bootcamp.DeletionBatch = report.Batch
+	}
+	return report, err
+}
+
+func (r *bootcampRepo) QueryFields() QueryFields {
+	return bootcampQueryFields
+}
+
+func (r *bootcampRepo) Expand(docs interface{}, urlQuery map[string][]string) error {
+	return r.expand(docs, urlQuery, bootcampQueryFields.Expand)
+}
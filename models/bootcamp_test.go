@@ -0,0 +1,83 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+// bootcamp named name with its slug set, saved unless it fails
+func saveWithSlug(t *testing.T, repo BootcampRepo, bootcamp *Bootcamp, name string) *Bootcamp {
+	t.Helper()
+	bootcamp.Name = name
+	if err := repo.SetSlug(bootcamp); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(bootcamp); err != nil {
+		t.Fatal(err)
+	}
+	return bootcamp
+}
+
+func TestSetSlug(t *testing.T) {
+	repo := NewBootcampRepo(NewMemoryStore())
+
+	first := saveWithSlug(t, repo, repo.New(), "Devworks Bootcamp")
+	second := saveWithSlug(t, repo, repo.New(), "Devworks Bootcamp")
+	third := saveWithSlug(t, repo, repo.New(), "Devworks: Bootcamp!")
+	for b, want := range map[*Bootcamp]string{first: "devworks-bootcamp", second: "devworks-bootcamp-2", third: "devworks-bootcamp-3"} {
+		if b.Slug != want || len(b.PreviousSlugs) != 0 {
+			t.Errorf("slug of %s = %q %v, want %q", b.Name, b.Slug, b.PreviousSlugs, want)
+		}
+	}
+
+	// no ascii letter in the name
+	if b := saveWithSlug(t, repo, repo.New(), "東京"); b.Slug != "bootcamp" {
+		t.Errorf("slug of %s = %q, want bootcamp", b.Name, b.Slug)
+	}
+	if b := saveWithSlug(t, repo, repo.New(), "!!!"); b.Slug != "bootcamp-2" {
+		t.Errorf("slug of %s = %q, want bootcamp-2", b.Name, b.Slug)
+	}
+
+	// the same name keeps the slug, its own slug is not a collision
+	saveWithSlug(t, repo, second, "Devworks Bootcamp")
+	if second.Slug != "devworks-bootcamp-2" || len(second.PreviousSlugs) != 0 {
+		t.Errorf("slug after saving again = %q %v", second.Slug, second.PreviousSlugs)
+	}
+
+	// renamed, the previous slug is kept and never given to another bootcamp
+	saveWithSlug(t, repo, first, "ModernTech")
+	if first.Slug != "moderntech" || !reflect.DeepEqual(first.PreviousSlugs, []string{"devworks-bootcamp"}) {
+		t.Errorf("slug after rename = %q %v", first.Slug, first.PreviousSlugs)
+	}
+	if b := saveWithSlug(t, repo, repo.New(), "Devworks Bootcamp"); b.Slug != "devworks-bootcamp-4" {
+		t.Errorf("slug of a new bootcamp = %q, want devworks-bootcamp-4", b.Slug)
+	}
+
+	found, moved, err := repo.FindSlug("devworks-bootcamp")
+	if err != nil || !moved || found.Id != first.Id {
+		t.Errorf("FindSlug(previous) = %v %v %v", found, moved, err)
+	}
+	found, moved, err = repo.FindSlug("moderntech")
+	if err != nil || moved || found.Id != first.Id {
+		t.Errorf("FindSlug(current) = %v %v %v", found, moved, err)
+	}
+	if _, _, err := repo.FindSlug("nothing"); err != ErrNotFound {
+		t.Errorf("FindSlug(unknown) error = %v, want ErrNotFound", err)
+	}
+
+	// renamed back, it gets back its previous slug
+	saveWithSlug(t, repo, first, "Devworks Bootcamp")
+	if first.Slug != "devworks-bootcamp" || !reflect.DeepEqual(first.PreviousSlugs, []string{"moderntech"}) {
+		t.Errorf("slug after renaming back = %q %v", first.Slug, first.PreviousSlugs)
+	}
+	saveWithSlug(t, repo, first, "ModernTech")
+	if first.Slug != "moderntech" || !reflect.DeepEqual(first.PreviousSlugs, []string{"devworks-bootcamp"}) {
+		t.Errorf("slug after renaming again = %q %v", first.Slug, first.PreviousSlugs)
+	}
+
+	// the slug of another bootcamp is a collision, the previous slug is kept
+	saveWithSlug(t, repo, third, "ModernTech")
+	if third.Slug != "moderntech-2" || !reflect.DeepEqual(third.PreviousSlugs, []string{"devworks-bootcamp-3"}) {
+		t.Errorf("slug of the other bootcamp = %q %v", third.Slug, third.PreviousSlugs)
+	}
+}
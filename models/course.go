@@ -2,9 +2,11 @@ package models
 
 import (
 	"fmt"
+	"log"
 	"strings"
 
 	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type Course struct {
@@ -17,6 +19,11 @@ type Course struct {
 	ScholarshipAvailable bool        `json:"scholarshipAvailable" bson:"scholarshipAvailable"`
 	Bootcamp             interface{} `json:"bootcamp" bson:"bootcamp" model:"Bootcamp" relation:"11" autosave:"true" required:"true"`
 	User                 interface{} `json:"user" bson:"user" model:"User" relation:"11" autosave:"true" required:"true"`
+
+	// parts of the text fields matching the q param, see highlight
+	Highlights map[string]string `json:"highlights,omitempty" bson:"-"`
+	// when and with what it was deleted, empty for the live documents
+	Deletion `json:",inline" bson:",inline"`
 }
 
 // override validate function to aviod check before save (will check explicitly)
@@ -69,3 +76,118 @@ func (c *Course) validateBothCreateAndUpdate() []error {
 
 	return validationErrors
 }
+
+// fields of the courses usable in the url query
+var courseQueryFields = QueryFields{
+	Model: Course{},
+	Filter: map[string]FieldType{
+		"title":                StringField,
+		"description":          StringField,
+		"weeks":                NumberField,
+		"tuition":              NumberField,
+		"minimumSkill":         StringField,
+		"scholarshipAvailable": BoolField,
+		"bootcamp":             IdField,
+		"user":                 IdField,
+		"createdAt":            DateField,
+		"updatedAt":            DateField,
+		"deletedAt":            DateField,
+		"deletionBatch":        IdField,
+	},
+	Sort: []string{"title", "weeks", "tuition", "createdAt", "updatedAt", "deletedAt"},
+	Expand: map[string]Relation{
+		"bootcamp": bootcampRelation,
+		"user":     userRelation,
+	},
+	Text: []string{"title", "description"},
+}
+
+// courses of the store
+type CourseRepo interface {
+	// new course ready to be validated and saved
+	New() *Course
+	// find by id, the deleted courses included
+	FindId(id bson.ObjectId) (*Course, error)
+	FindOne(filter bson.M) (*Course, error)
+	Find(q Query) ([]*Course, error)
+	Count(filter bson.M) (int, error)
+	// fields usable in the url query
+	QueryFields() QueryFields
+	// replace the relation ids of docs (a course or a slice of them) by the documents
+	// asked by the expand param of the url query
+	Expand(docs interface{}, urlQuery map[string][]string) error
+	Save(course *Course) error
+	SoftDelete(course *Course) error
+	// average tuition of the bootcamp's courses, used as the bootcamp averageCost
+	AverageCost(bootcampId bson.ObjectId) (int, error)
+}
+
+type courseRepo struct {
+	repo
+}
+
+func NewCourseRepo(store Store) CourseRepo {
+	r := &courseRepo{newRepo(store, "Course")}
+	// needed by the q param
+	err := r.c.EnsureIndex(textIndex(courseQueryFields.Text))
+	if err != nil {
+		log.Println("ensure text index: ", err)
+	}
+	return r
+}
+
+func (r *courseRepo) New() *Course {
+	course := &Course{}
+	r.c.Init(course)
+	return course
+}
+
+func (r *courseRepo) FindId(id bson.ObjectId) (*Course, error) {
+	course := &Course{}
+	err := r.findId(id, course)
+	if err != nil {
+		return nil, err
+	}
+	return course, nil
+}
+
+func (r *courseRepo) FindOne(filter bson.M) (*Course, error) {
+	course := &Course{}
+	err := r.c.FindOne(filter, course)
+	if err != nil {
+		return nil, err
+	}
+	return course, nil
+}
+
+func (r *courseRepo) Find(q Query) ([]*Course, error) {
+	courses := []*Course{}
+	err := r.find(q, &courses)
+	return courses, err
+}
+
+func (r *courseRepo) Save(course *Course) error {
+	return r.save(course)
+}
+
+func (r *courseRepo) SoftDelete(course *Course) error {
+	return r.softDelete(course)
+}
+
+func (r *courseRepo) AverageCost(bootcampId bson.ObjectId) (int, error) {
+	query := bson.M{
+		"bootcamp": bootcampId,
+		"deleted":  false,
+	}
+	avg, err := r.average(query, "tuition")
+	// force last digit to be zero
+	return int(avg/10) * 10, err
+}
+
+func (r *courseRepo) QueryFields() QueryFields {
+	return courseQueryFields
+}
+
+func (r *courseRepo) Expand(docs interface{}, urlQuery map[string][]string) error {
+	return r.expand(docs, urlQuery, courseQueryFields.Expand)
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// documents soft-deleted together, each one holds the id of the batch in deletionBatch
+type DeletionBatch struct {
+	mongodm.DocumentBase `json:",inline" bson:",inline"`
+	// model and id of the document whose deletion deleted the others
+	Model    string        `json:"model" bson:"model"`
+	Document bson.ObjectId `json:"document" bson:"document"`
+	// user who deleted it
+	User bson.ObjectId `json:"user" bson:"user"`
+	// ids of the deleted documents by collection, e.g. "courses", the document included
+	Documents map[string][]bson.ObjectId `json:"documents" bson:"documents"`
+}
+
+// documents deleted, or that would be deleted by a dry run
+type DeletionReport struct {
+	// empty for a dry run
+	Batch     bson.ObjectId              `json:"batch,omitempty"`
+	DryRun    bool                       `json:"dryRun"`
+	Documents map[string][]bson.ObjectId `json:"documents"`
+	Counts    map[string]int             `json:"counts"`
+}
+
+// documents of a model deleted with the document whose id they hold in field,
+// reported under key
+type cascade struct {
+	model string
+	field string
+	key   string
+}
+
+var bootcampCascade = []cascade{
+	{model: "Course", field: "bootcamp", key: "courses"},
+	{model: "Review", field: "bootcamp", key: "reviews"},
+}
+
+// soft-delete the document id of the repo (reported under key) with the documents of
+// children holding its id, all in one batch, only report them for a dry run
+func (r repo) cascadeDelete(key string, id bson.ObjectId, user bson.ObjectId, children []cascade, dryRun bool) (*DeletionReport, error) {
+	report := &DeletionReport{
+		DryRun:    dryRun,
+		Documents: map[string][]bson.ObjectId{key: {id}},
+		Counts:    map[string]int{key: 1},
+	}
+
+	// the children that are not deleted yet
+	for _, child := range children {
+		var docs []bson.M
+		err := r.store.C(child.model).Find(Query{
+			Filter: bson.M{
+				child.field: id,
+				"deleted":   false,
+			},
+			Select: bson.M{"_id": 1},
+		}, &docs)
+		if err != nil {
+			return nil, err
+		}
+		ids := []bson.ObjectId{}
+		for _, d := range docs {
+			ids = append(ids, d["_id"].(bson.ObjectId))
+		}
+		report.Documents[child.key] = ids
+		report.Counts[child.key] = len(ids)
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	// the batch is recorded first so the documents of an interrupted deletion can be restored
+	batches := r.store.C("DeletionBatch")
+	batch := &DeletionBatch{
+		Model:     r.model,
+		Document:  id,
+		User:      user,
+		Documents: report.Documents,
+	}
+	batches.Init(batch)
+	err := batches.Save(batch)
+	if err != nil {
+		return nil, err
+	}
+	report.Batch = batch.Id
+
+	now := time.Now()
+	mark := func(c Collection, ids []bson.ObjectId) error {
+		if len(ids) == 0 {
+			return nil
+		}
+		_, err := c.UpdateAll(bson.M{
+			"_id":     bson.M{"$in": ids},
+			"deleted": false,
+		}, bson.M{
+			"$set": bson.M{
+				"deleted":       true,
+				"deletedAt":     now,
+				"deletionBatch": batch.Id,
+				"updatedAt":     now,
+			},
+		})
+		return err
+	}
+	// the document last, a failed deletion can be run again
+	for _, child := range children {
+		err := mark(r.store.C(child.model), report.Documents[child.key])
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = mark(r.c, []bson.ObjectId{id})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
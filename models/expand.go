@@ -0,0 +1,291 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// relation of a model to another one, expanded with the expand param of the url query
+type Relation struct {
+	// model of the related documents
+	Model string
+	// fields that can be selected and the default selection, any field when empty
+	Fields []string
+	// field of the related documents holding the id of the document, for a one to many
+	// relation (e.g. the bootcamp of the courses), empty when the document holds the id
+	ForeignField string
+}
+
+// one to many relation joined to the documents found, in the field as
+type join struct {
+	as       string
+	relation Relation
+	fields   []string
+}
+
+// $lookup of the related documents that are not deleted, with the selected fields
+func (j join) stage(store Store) bson.M {
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"$expr":   bson.M{"$eq": []interface{}{"$" + j.relation.ForeignField, "$$id"}},
+			"deleted": false,
+		}},
+	}
+	if sel := j.selected(); sel != nil {
+		pipeline = append(pipeline, bson.M{"$project": sel})
+	}
+	return bson.M{
+		"$lookup": bson.M{
+			"from":     store.C(j.relation.Model).Name(),
+			"let":      bson.M{"id": "$_id"},
+			"pipeline": pipeline,
+			"as":       j.as,
+		},
+	}
+}
+
+func (j join) selected() bson.M {
+	fields := j.fields
+	if len(fields) == 0 {
+		fields = j.relation.Fields
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	sel := bson.M{}
+	for _, f := range fields {
+		sel[f] = 1
+	}
+	return sel
+}
+
+func (rel Relation) selectable(field string) bool {
+	if len(rel.Fields) == 0 {
+		return true
+	}
+	for _, v := range rel.Fields {
+		if v == field {
+			return true
+		}
+	}
+	return false
+}
+
+// parse "bootcamp(name,slug),user" to the relations and their selected fields
+func parseExpand(s string, relations map[string]Relation) (map[string][]string, error) {
+	expand := map[string][]string{}
+	for _, item := range splitTopLevel(s) {
+		name, fields := item, []string(nil)
+		if i := strings.Index(item, "("); i >= 0 {
+			if !strings.HasSuffix(item, ")") {
+				return nil, queryErrorf("invalid expand %s", item)
+			}
+			name = item[:i]
+			for _, f := range strings.Split(item[i+1:len(item)-1], ",") {
+				if f = strings.TrimSpace(f); f != "" {
+					fields = append(fields, f)
+				}
+			}
+			if len(fields) == 0 {
+				return nil, queryErrorf("no field selected for %s", name)
+			}
+		}
+		name = strings.TrimSpace(name)
+		rel, ok := relations[name]
+		if !ok {
+			return nil, queryErrorf("cannot expand %s", name)
+		}
+		for _, f := range fields {
+			if !rel.selectable(f) {
+				return nil, queryErrorf("cannot select %s of %s", f, name)
+			}
+		}
+		expand[name] = fields
+	}
+	return expand, nil
+}
+
+// split at the commas out of parentheses
+func splitTopLevel(s string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	items = append(items, s[start:])
+	return items
+}
+
+// replace the ids of the relations asked by the expand param of the url query by the
+// related documents, docs is a document or a slice of them, one query per relation
+func (r repo) expand(docs interface{}, urlQuery map[string][]string, relations map[string]Relation) error {
+	s := firstParam(urlQuery["expand"])
+	if s == "" {
+		return nil
+	}
+	expand, err := parseExpand(s, relations)
+	if err != nil {
+		return err
+	}
+
+	// the structs of the documents
+	var items []reflect.Value
+	v := reflect.ValueOf(docs)
+	if v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, reflect.Indirect(v.Index(i)))
+		}
+	} else {
+		items = append(items, reflect.Indirect(v))
+	}
+
+	for name, fields := range expand {
+		rel := relations[name]
+		if rel.ForeignField != "" {
+			err := r.expandMany(items, name, rel, fields)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// ids of the related documents, each one once
+		var ids []bson.ObjectId
+		seen := map[bson.ObjectId]bool{}
+		for _, item := range items {
+			id, ok := relationId(item, name)
+			if ok && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		q := Query{
+			Filter: bson.M{
+				"_id":     bson.M{"$in": ids},
+				"deleted": false,
+			},
+		}
+		q.Select = join{relation: rel, fields: fields}.selected()
+		var related []bson.M
+		err := r.store.C(rel.Model).Find(q, &related)
+		if err != nil {
+			return err
+		}
+
+		byId := map[bson.ObjectId]bson.M{}
+		for _, d := range related {
+			id, _ := d["_id"].(bson.ObjectId)
+			byId[id] = relatedDoc(d)
+		}
+		// the id stays when the related document is gone
+		for _, item := range items {
+			if id, ok := relationId(item, name); ok {
+				if d, ok := byId[id]; ok {
+					fieldByBson(item, name).Set(reflect.ValueOf(d))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fill the one to many relation name of the items not joined yet, with one query
+func (r repo) expandMany(items []reflect.Value, name string, rel Relation, fields []string) error {
+	var ids []bson.ObjectId
+	for _, item := range items {
+		if field := fieldByBson(item, name); field.IsValid() && field.IsNil() {
+			ids = append(ids, item.FieldByName("Id").Interface().(bson.ObjectId))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	q := Query{
+		Filter: bson.M{
+			rel.ForeignField: bson.M{"$in": ids},
+			"deleted":        false,
+		},
+		Select: join{relation: rel, fields: fields}.selected(),
+	}
+	// the foreign field groups the documents, it is shown only when selected
+	hideForeign := q.Select != nil && q.Select[rel.ForeignField] == nil
+	if hideForeign {
+		q.Select[rel.ForeignField] = 1
+	}
+	var related []bson.M
+	err := r.store.C(rel.Model).Find(q, &related)
+	if err != nil {
+		return err
+	}
+
+	byId := map[bson.ObjectId][]interface{}{}
+	for _, d := range related {
+		id, _ := d[rel.ForeignField].(bson.ObjectId)
+		if hideForeign {
+			delete(d, rel.ForeignField)
+		}
+		byId[id] = append(byId[id], relatedDoc(d))
+	}
+	for _, item := range items {
+		if field := fieldByBson(item, name); field.IsValid() && field.IsNil() {
+			id := item.FieldByName("Id").Interface().(bson.ObjectId)
+			list := byId[id]
+			if list == nil {
+				list = []interface{}{}
+			}
+			field.Set(reflect.ValueOf(list))
+		}
+	}
+	return nil
+}
+
+// related document with the keys of the json of the documents
+func relatedDoc(d bson.M) bson.M {
+	d["id"] = d["_id"]
+	delete(d, "_id")
+	delete(d, "deleted")
+	return d
+}
+
+// id in the relation field name of the struct v, false when it is not an id
+func relationId(v reflect.Value, name string) (bson.ObjectId, bool) {
+	field := fieldByBson(v, name)
+	if !field.IsValid() {
+		return "", false
+	}
+	id, ok := field.Interface().(bson.ObjectId)
+	return id, ok
+}
+
+// field of the struct v stored under name, or shown as name when it is not stored
+// (e.g. the courses of a bootcamp)
+func fieldByBson(v reflect.Value, name string) reflect.Value {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("bson"), ",")[0]
+		if tag == "-" {
+			tag = strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		}
+		if tag == name {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"devcamper/utils"
+	"log"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// cached geocoding result, keyed by the normalized address
+type Geocode struct {
+	mongodm.DocumentBase `json:",inline" bson:",inline"`
+	Address              string           `json:"address" bson:"address"`
+	Result               *utils.GeoResult `json:"result" bson:"result"`
+}
+
+// geocoder that looks up the geocodes collection before asking the wrapped geocoder
+type CachedGeocoder struct {
+	geocodes Collection
+	geocoder utils.Geocoder
+}
+
+func NewCachedGeocoder(store Store, geocoder utils.Geocoder) *CachedGeocoder {
+	geocodes := store.C("Geocode")
+	err := geocodes.EnsureIndex(mgo.Index{
+		Key:    []string{"address"},
+		Unique: true,
+	})
+	if err != nil {
+		log.Println("cannot ensure geocode cache index: ", err)
+	}
+	return &CachedGeocoder{
+		geocodes: geocodes,
+		geocoder: geocoder,
+	}
+}
+
+func (c *CachedGeocoder) Geocode(address string) (*utils.GeoResult, error) {
+	cached := &Geocode{}
+
+	key := utils.NormalizeAddress(address)
+	err := c.geocodes.FindOne(bson.M{"address": key}, cached)
+	if err == nil && cached.Result != nil {
+		return cached.Result, nil
+	} else if err != ErrNotFound && err != nil {
+		// the cache is an optimization, keep geocoding without it
+		log.Println("geocode cache lookup: ", err)
+	}
+
+	res, err := c.geocoder.Geocode(address)
+	if err != nil {
+		return nil, err
+	}
+
+	cached = &Geocode{
+		Address: key,
+		Result:  res,
+	}
+	if err := c.geocodes.Save(cached); err != nil {
+		log.Println("geocode cache save: ", err)
+	}
+	return res, nil
+}
+
+// convert geocoding result to GeoJSON point
+func NewGeoJson(res *utils.GeoResult) *GeoJson {
+	return &GeoJson{
+		Type:             "Point",
+		Coordinates:      []float64{res.Lng, res.Lat},
+		FormattedAddress: res.FormattedAddress,
+		Street:           res.Street,
+		City:             res.City,
+		State:            res.State,
+		Zipcode:          res.Zipcode,
+		Country:          res.Country,
+	}
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"devcamper/utils"
+	"log"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// failed login attempts of a key, see utils.AttemptTracker
+type LoginAttempt struct {
+	mongodm.DocumentBase `json:",inline" bson:",inline"`
+	utils.LoginAttempt   `json:",inline" bson:",inline"`
+}
+
+// attempt tracker kept in DB so every instance shares it
+type MongoAttemptTracker struct {
+	connection *mongodm.Connection
+	policy     utils.AttemptPolicy
+}
+
+func NewMongoAttemptTracker(conn *mongodm.Connection, policy utils.AttemptPolicy) *MongoAttemptTracker {
+	LoginAttemptModel := conn.Model("LoginAttempt")
+	err := LoginAttemptModel.EnsureIndex(mgo.Index{
+		Key:    []string{"key"},
+		Unique: true,
+	})
+	if err != nil {
+		log.Println("cannot ensure login attempt index: ", err)
+	}
+	// old attempts are removed by DB, after the window and a running lockout
+	err = LoginAttemptModel.EnsureIndex(mgo.Index{
+		Key:         []string{"lastFailedAt"},
+		ExpireAfter: policy.Window + policy.LockDuration,
+	})
+	if err != nil {
+		log.Println("cannot ensure login attempt index: ", err)
+	}
+	return &MongoAttemptTracker{
+		connection: conn,
+		policy:     policy,
+	}
+}
+
+func (t *MongoAttemptTracker) Get(key string) (*utils.LoginAttempt, error) {
+	attempt := &LoginAttempt{}
+	err := t.connection.Model("LoginAttempt").FindOne(bson.M{"key": key}).Exec(attempt)
+	if _, ok := err.(*mongodm.NotFoundError); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if t.stale(&attempt.LoginAttempt, time.Now()) {
+		return nil, nil
+	}
+	return &attempt.LoginAttempt, nil
+}
+
+func (t *MongoAttemptTracker) Fail(key string) (*utils.LoginAttempt, bool, error) {
+	LoginAttemptModel := t.connection.Model("LoginAttempt")
+	now := time.Now()
+
+	// start again from zero when the failures are too old or the lockout is over
+	_, err := LoginAttemptModel.UpdateAll(bson.M{
+		"key": key,
+		"$or": []bson.M{
+			{"locked": false, "lastFailedAt": bson.M{"$lt": now.Add(-t.policy.Window)}},
+			{"locked": true, "blockedUntil": bson.M{"$lte": now}},
+		},
+	}, bson.M{
+		"$set": bson.M{
+			"failures":     0,
+			"locked":       false,
+			"blockedUntil": time.Time{},
+		},
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// count the failure atomically so concurrent attempts are not lost
+	attempt := &LoginAttempt{}
+	change := mgo.Change{
+		Update: bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{
+				"lastFailedAt": now,
+				"updatedAt":    now,
+			},
+			"$setOnInsert": bson.M{
+				"createdAt": now,
+				"deleted":   false,
+			},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	_, err = LoginAttemptModel.Collection.Find(bson.M{"key": key}).Apply(change, attempt)
+	if err != nil {
+		return nil, false, err
+	}
+
+	wasLocked := attempt.Locked
+	attempt.BlockedUntil, attempt.Locked = t.policy.Block(attempt.Failures, now)
+	err = LoginAttemptModel.Collection.Update(bson.M{"_id": attempt.Id}, bson.M{
+		"$set": bson.M{
+			"blockedUntil": attempt.BlockedUntil,
+			"locked":       attempt.Locked,
+		},
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &attempt.LoginAttempt, attempt.Locked && !wasLocked, nil
+}
+
+func (t *MongoAttemptTracker) Reset(key string) error {
+	_, err := t.connection.Model("LoginAttempt").RemoveAll(bson.M{"key": key})
+	return err
+}
+
+func (t *MongoAttemptTracker) ReleaseExpired() ([]*utils.LoginAttempt, error) {
+	LoginAttemptModel := t.connection.Model("LoginAttempt")
+	attempts := []*LoginAttempt{}
+	query := bson.M{
+		"locked": true,
+		"blockedUntil": bson.M{
+			"$lte": time.Now(),
+		},
+	}
+	err := LoginAttemptModel.Find(query).Exec(&attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	released := []*utils.LoginAttempt{}
+	for _, v := range attempts {
+		// removed only if still locked, another instance may have released it
+		err := LoginAttemptModel.Collection.Remove(bson.M{"_id": v.Id, "locked": true})
+		if err == mgo.ErrNotFound {
+			continue
+		} else if err != nil {
+			return released, err
+		}
+		released = append(released, &v.LoginAttempt)
+	}
+	return released, nil
+}
+
+// the failures are too old to count, a running lockout is kept until released
+func (t *MongoAttemptTracker) stale(a *utils.LoginAttempt, now time.Time) bool {
+	return !a.Locked && now.Sub(a.LastFailedAt) > t.policy.Window
+}
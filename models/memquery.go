@@ -0,0 +1,1129 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// the MongoDB query language of the memory store: filters, updates, sorts, projections and aggregations
+// the documents are bson.M as returned by toM, so the values have the types of the bson package
+
+// true when the document matches the filter
+func match(doc bson.M, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		switch key {
+		case "$and", "$or", "$nor":
+			list, ok := cond.([]interface{})
+			if !ok {
+				return false, fmt.Errorf("%s needs an array", key)
+			}
+			matched := 0
+			for _, c := range list {
+				f, ok := c.(bson.M)
+				if !ok {
+					return false, fmt.Errorf("%s needs an array of documents", key)
+				}
+				ok, err := match(doc, f)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					matched++
+				}
+			}
+			if key == "$and" && matched < len(list) || key == "$or" && matched == 0 || key == "$nor" && matched > 0 {
+				return false, nil
+			}
+		case "$expr":
+			v, err := evalExpr(doc, cond)
+			if err != nil || !truthy(v) {
+				return false, err
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported query operator %s", key)
+			}
+			ok, err := matchField(doc, key, cond)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+func matchField(doc bson.M, path string, cond interface{}) (bool, error) {
+	found := lookup(doc, path)
+	if ops, ok := cond.(bson.M); ok && isOperators(ops) {
+		for op, arg := range ops {
+			ok, err := matchOperator(found, op, arg, ops)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	if re, ok := cond.(bson.RegEx); ok {
+		return matchRegex(found, re.Pattern, re.Options)
+	}
+	return anyEqual(found, cond), nil
+}
+
+func matchOperator(found []interface{}, op string, arg interface{}, ops bson.M) (bool, error) {
+	switch op {
+	case "$eq":
+		return anyEqual(found, arg), nil
+	case "$ne":
+		return !anyEqual(found, arg), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, v := range candidates(found) {
+			n, ok := compareValues(v, arg)
+			if !ok {
+				continue
+			}
+			if op == "$gt" && n > 0 || op == "$gte" && n >= 0 || op == "$lt" && n < 0 || op == "$lte" && n <= 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$in", "$nin":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("%s needs an array", op)
+		}
+		in := false
+		for _, a := range list {
+			if re, ok := a.(bson.RegEx); ok {
+				m, err := matchRegex(found, re.Pattern, re.Options)
+				if err != nil {
+					return false, err
+				}
+				in = in || m
+			} else if anyEqual(found, a) {
+				in = true
+			}
+		}
+		return in == (op == "$in"), nil
+	case "$all":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("$all needs an array")
+		}
+		for _, a := range list {
+			if !anyEqual(found, a) {
+				return false, nil
+			}
+		}
+		return len(list) > 0, nil
+	case "$exists":
+		return (len(found) > 0) == truthy(arg), nil
+	case "$size":
+		for _, v := range found {
+			if arr, ok := v.([]interface{}); ok && float64(len(arr)) == toFloat(arg) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$regex":
+		options, _ := ops["$options"].(string)
+		switch p := arg.(type) {
+		case string:
+			return matchRegex(found, p, options)
+		case bson.RegEx:
+			return matchRegex(found, p.Pattern, p.Options+options)
+		}
+		return false, fmt.Errorf("$regex needs a string")
+	case "$options":
+		return true, nil
+	case "$not":
+		var ok bool
+		var err error
+		if re, isRe := arg.(bson.RegEx); isRe {
+			ok, err = matchRegex(found, re.Pattern, re.Options)
+		} else if sub, isM := arg.(bson.M); isM {
+			ok = true
+			for o, a := range sub {
+				m, e := matchOperator(found, o, a, sub)
+				if e != nil {
+					return false, e
+				}
+				ok = ok && m
+			}
+		} else {
+			return false, fmt.Errorf("$not needs an operator or a regex")
+		}
+		return !ok, err
+	case "$elemMatch":
+		f, ok := arg.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("$elemMatch needs a document")
+		}
+		for _, v := range found {
+			arr, _ := v.([]interface{})
+			for _, e := range arr {
+				var m bool
+				var err error
+				if d, ok := e.(bson.M); ok && !isOperators(f) {
+					m, err = match(d, f)
+				} else {
+					m, err = matchField(bson.M{"v": e}, "v", f)
+				}
+				if err != nil {
+					return false, err
+				}
+				if m {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case "$geoWithin":
+		return matchGeoWithin(found, arg)
+	}
+	return false, fmt.Errorf("unsupported query operator %s", op)
+}
+
+// $geoWithin with $centerSphere: [[lng, lat], radius in radians]
+func matchGeoWithin(found []interface{}, arg interface{}) (bool, error) {
+	m, _ := arg.(bson.M)
+	sphere, ok := m["$centerSphere"].([]interface{})
+	if !ok || len(sphere) != 2 {
+		return false, fmt.Errorf("$geoWithin supports only $centerSphere")
+	}
+	center, ok := point(sphere[0])
+	if !ok {
+		return false, fmt.Errorf("$centerSphere needs a [lng, lat] center")
+	}
+	radius := toFloat(sphere[1])
+	for _, v := range found {
+		p, ok := point(v)
+		if ok && angularDistance(center, p) <= radius {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// coordinates of a GeoJSON point or a legacy [lng, lat] pair
+func point(v interface{}) ([2]float64, bool) {
+	if m, ok := v.(bson.M); ok {
+		v = m["coordinates"]
+	}
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) != 2 || !isNumber(arr[0]) || !isNumber(arr[1]) {
+		return [2]float64{}, false
+	}
+	return [2]float64{toFloat(arr[0]), toFloat(arr[1])}, true
+}
+
+// haversine distance in radians between two [lng, lat] points
+func angularDistance(a [2]float64, b [2]float64) float64 {
+	rad := math.Pi / 180
+	lat1, lat2 := a[1]*rad, b[1]*rad
+	dLat := lat2 - lat1
+	dLng := (b[0] - a[0]) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func matchRegex(found []interface{}, pattern string, options string) (bool, error) {
+	flags := ""
+	for _, o := range options {
+		if strings.ContainsRune("ims", o) {
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid regex: %w", err)
+	}
+	for _, v := range candidates(found) {
+		if s, ok := v.(string); ok && re.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// a missing field equals null, like in MongoDB
+func anyEqual(found []interface{}, value interface{}) bool {
+	if value == nil && len(found) == 0 {
+		return true
+	}
+	for _, v := range found {
+		if valuesEqual(v, value) {
+			return true
+		}
+		if arr, ok := v.([]interface{}); ok {
+			for _, e := range arr {
+				if valuesEqual(e, value) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// found values with the elements of the arrays
+func candidates(found []interface{}) []interface{} {
+	var values []interface{}
+	for _, v := range found {
+		if arr, ok := v.([]interface{}); ok {
+			values = append(values, arr...)
+		} else {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func isOperators(m bson.M) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// values at a dotted path, the arrays on the way are traversed
+func lookup(doc bson.M, path string) []interface{} {
+	return lookupParts(doc, strings.Split(path, "."))
+}
+
+func lookupParts(v interface{}, parts []string) []interface{} {
+	if len(parts) == 0 {
+		return []interface{}{v}
+	}
+	switch t := v.(type) {
+	case bson.M:
+		child, ok := t[parts[0]]
+		if !ok {
+			return nil
+		}
+		return lookupParts(child, parts[1:])
+	case []interface{}:
+		if i, err := strconv.Atoi(parts[0]); err == nil {
+			if i < len(t) {
+				return lookupParts(t[i], parts[1:])
+			}
+			return nil
+		}
+		var values []interface{}
+		for _, e := range t {
+			values = append(values, lookupParts(e, parts)...)
+		}
+		return values
+	}
+	return nil
+}
+
+func firstValue(doc bson.M, path string) interface{} {
+	found := lookup(doc, path)
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+func valuesEqual(a interface{}, b interface{}) bool {
+	if isNumber(a) && isNumber(b) {
+		return toFloat(a) == toFloat(b)
+	}
+	n, ok := compareValues(a, b)
+	if ok {
+		return n == 0
+	}
+	switch at := a.(type) {
+	case bson.M:
+		bt, ok := b.(bson.M)
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for k, v := range at {
+			w, ok := bt[k]
+			if !ok || !valuesEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bt, ok := b.([]interface{})
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for i := range at {
+			if !valuesEqual(at[i], bt[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == nil && b == nil
+}
+
+// order of two values of the same kind, ok is false when they cannot be compared
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	switch at := a.(type) {
+	case string:
+		if bt, ok := b.(string); ok {
+			return strings.Compare(at, bt), true
+		}
+	case bson.ObjectId:
+		if bt, ok := b.(bson.ObjectId); ok {
+			return strings.Compare(string(at), string(bt)), true
+		}
+	case time.Time:
+		if bt, ok := b.(time.Time); ok {
+			switch {
+			case at.Before(bt):
+				return -1, true
+			case at.After(bt):
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if bt, ok := b.(bool); ok {
+			switch {
+			case at == bt:
+				return 0, true
+			case bt:
+				return -1, true
+			}
+			return 1, true
+		}
+	default:
+		if isNumber(a) && isNumber(b) {
+			x, y := toFloat(a), toFloat(b)
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// rank of the types in the sort order of MongoDB
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case int, int32, int64, float64:
+		return 2
+	case string:
+		return 3
+	case bson.M:
+		return 4
+	case []interface{}:
+		return 5
+	case bson.ObjectId:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	}
+	return 10
+}
+
+func compareSort(a interface{}, b interface{}) int {
+	if n, ok := compareValues(a, b); ok {
+		return n
+	}
+	ra, rb := typeRank(a), typeRank(b)
+	switch {
+	case ra < rb:
+		return -1
+	case ra > rb:
+		return 1
+	}
+	return 0
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int32, int64, float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	}
+	if isNumber(v) {
+		return toFloat(v) != 0
+	}
+	return true
+}
+
+// key of the score of a text search in the documents, set by the collection before the
+// documents are sorted and projected
+const scoreKey = "$textScore"
+
+// sort by the fields, prefixed with "-" for descending order, "$textScore:field" sorts
+// by the score of the text search, the most relevant first
+func sortDocs(docs []bson.M, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, f := range fields {
+			desc := strings.HasPrefix(f, "-")
+			f = strings.TrimLeft(f, "+-")
+			if strings.HasPrefix(f, scoreKey+":") {
+				f, desc = scoreKey, true
+			}
+			n := compareSort(firstValue(docs[i], f), firstValue(docs[j], f))
+			if n != 0 {
+				return n < 0 != desc
+			}
+		}
+		return false
+	})
+}
+
+// copy of the document with the selected fields, the _id is kept unless excluded
+// a field {"$meta": "textScore"} is set to the score of the text search
+func project(doc bson.M, sel bson.M) bson.M {
+	meta := bson.M{}
+	fields := bson.M{}
+	for k, v := range sel {
+		if isTextScore(v) {
+			meta[k] = doc[scoreKey]
+		} else {
+			fields[k] = v
+		}
+	}
+	out := projectFields(doc, fields)
+	for k, v := range meta {
+		out[k] = v
+	}
+	return out
+}
+
+func projectFields(doc bson.M, sel bson.M) bson.M {
+	// {"_id": 1} alone keeps only the _id
+	include := false
+	for k, v := range sel {
+		if truthy(v) && (k != "_id" || len(sel) == 1) {
+			include = true
+		}
+	}
+	if !include {
+		out := copyM(doc)
+		for k := range sel {
+			unsetPath(out, k)
+		}
+		return out
+	}
+	out := bson.M{}
+	if id, ok := doc["_id"]; ok && (sel["_id"] == nil || truthy(sel["_id"])) {
+		out["_id"] = id
+	}
+	for k, v := range sel {
+		if k == "_id" || !truthy(v) {
+			continue
+		}
+		if found := lookupParts(doc, strings.Split(k, ".")); len(found) == 1 {
+			setPath(out, k, found[0])
+		}
+	}
+	return copyM(out)
+}
+
+// set the value at a dotted path, the missing documents on the way are created
+func setPath(doc bson.M, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	var cur interface{} = doc
+	for i, p := range parts {
+		last := i == len(parts)-1
+		switch t := cur.(type) {
+		case bson.M:
+			if last {
+				t[p] = value
+				return nil
+			}
+			next, ok := t[p]
+			if !ok || next == nil {
+				next = bson.M{}
+				t[p] = next
+			}
+			cur = next
+		case []interface{}:
+			n, err := strconv.Atoi(p)
+			if err != nil || n >= len(t) {
+				return fmt.Errorf("cannot set %s", path)
+			}
+			if last {
+				t[n] = value
+				return nil
+			}
+			cur = t[n]
+		default:
+			return fmt.Errorf("cannot set %s", path)
+		}
+	}
+	return nil
+}
+
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	var cur interface{} = doc
+	for i, p := range parts {
+		m, ok := cur.(bson.M)
+		if !ok {
+			return
+		}
+		if i == len(parts)-1 {
+			delete(m, p)
+			return
+		}
+		cur = m[p]
+	}
+}
+
+// fields of the filter equalities, the base of an upserted document
+func upsertBase(filter bson.M) bson.M {
+	doc := bson.M{}
+	for k, v := range filter {
+		if strings.HasPrefix(k, "$") {
+			continue
+		}
+		if m, ok := v.(bson.M); ok && isOperators(m) {
+			if eq, ok := m["$eq"]; ok {
+				setPath(doc, k, eq)
+			}
+			continue
+		}
+		setPath(doc, k, v)
+	}
+	return doc
+}
+
+// apply the update operators, an update without operators replaces the document but its _id
+func applyUpdate(doc bson.M, update bson.M, insert bool) error {
+	if !isOperators(update) {
+		id := doc["_id"]
+		for k := range doc {
+			delete(doc, k)
+		}
+		for k, v := range update {
+			doc[k] = v
+		}
+		if id != nil {
+			doc["_id"] = id
+		}
+		return nil
+	}
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("%s needs a document", op)
+		}
+		for path, value := range fields {
+			var err error
+			switch op {
+			case "$set":
+				err = setPath(doc, path, value)
+			case "$setOnInsert":
+				if insert {
+					err = setPath(doc, path, value)
+				}
+			case "$unset":
+				unsetPath(doc, path)
+			case "$inc":
+				cur := firstValue(doc, path)
+				if cur != nil && !isNumber(cur) || !isNumber(value) {
+					return fmt.Errorf("$inc needs numbers")
+				}
+				err = setPath(doc, path, addNumbers(cur, value))
+			case "$push", "$addToSet":
+				err = pushValues(doc, path, value, op == "$addToSet")
+			case "$pull":
+				err = pullValues(doc, path, value)
+			case "$min", "$max":
+				cur := lookup(doc, path)
+				if len(cur) == 0 || op == "$min" && compareSort(value, cur[0]) < 0 || op == "$max" && compareSort(value, cur[0]) > 0 {
+					err = setPath(doc, path, value)
+				}
+			default:
+				return fmt.Errorf("unsupported update operator %s", op)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addNumbers(a interface{}, b interface{}) interface{} {
+	_, af := a.(float64)
+	_, bf := b.(float64)
+	if af || bf {
+		return toFloat(a) + toFloat(b)
+	}
+	return int64(toFloat(a)) + int64(toFloat(b))
+}
+
+func pushValues(doc bson.M, path string, value interface{}, unique bool) error {
+	var arr []interface{}
+	if cur := lookupParts(doc, strings.Split(path, ".")); len(cur) == 1 {
+		var ok bool
+		arr, ok = cur[0].([]interface{})
+		if !ok && cur[0] != nil {
+			return fmt.Errorf("cannot push to %s, not an array", path)
+		}
+	}
+	values := []interface{}{value}
+	var slice interface{}
+	if m, ok := value.(bson.M); ok {
+		if each, ok := m["$each"].([]interface{}); ok {
+			values = each
+			slice = m["$slice"]
+		}
+	}
+	for _, v := range values {
+		if unique && anyEqual([]interface{}{arr}, v) {
+			continue
+		}
+		arr = append(arr, v)
+	}
+	// the first n elements, or the last ones when n is negative
+	if slice != nil {
+		if !isNumber(slice) {
+			return fmt.Errorf("$slice needs a number")
+		}
+		n := int(toFloat(slice))
+		if n >= 0 && n < len(arr) {
+			arr = arr[:n]
+		} else if n < 0 && -n < len(arr) {
+			arr = arr[len(arr)+n:]
+		}
+	}
+	return setPath(doc, path, arr)
+}
+
+func pullValues(doc bson.M, path string, cond interface{}) error {
+	cur := lookupParts(doc, strings.Split(path, "."))
+	if len(cur) != 1 {
+		return nil
+	}
+	arr, ok := cur[0].([]interface{})
+	if !ok {
+		return nil
+	}
+	kept := []interface{}{}
+	for _, e := range arr {
+		var pull bool
+		if m, ok := cond.(bson.M); ok {
+			var err error
+			if d, isDoc := e.(bson.M); isDoc && !isOperators(m) {
+				pull, err = match(d, m)
+			} else {
+				pull, err = matchField(bson.M{"v": e}, "v", m)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			pull = valuesEqual(e, cond)
+		}
+		if !pull {
+			kept = append(kept, e)
+		}
+	}
+	return setPath(doc, path, kept)
+}
+
+// run the stages of an aggregation pipeline on the documents
+// from gives the documents of the collections joined by $lookup
+func aggregate(docs []bson.M, pipeline []bson.M, from func(name string) []bson.M) ([]bson.M, error) {
+	for _, stage := range pipeline {
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("a pipeline stage needs one operator")
+		}
+		for op, arg := range stage {
+			var err error
+			switch op {
+			case "$match":
+				f, e := toM(arg)
+				if e != nil {
+					return nil, e
+				}
+				var matched []bson.M
+				for _, d := range docs {
+					ok, e := match(d, f)
+					if e != nil {
+						return nil, e
+					}
+					if ok {
+						matched = append(matched, d)
+					}
+				}
+				docs = matched
+			case "$sort":
+				docs, err = sortStage(docs, arg)
+			case "$skip":
+				docs = skipLimit(docs, int(toFloat(arg)), 0)
+			case "$limit":
+				docs = skipLimit(docs, 0, int(toFloat(arg)))
+			case "$project":
+				sel, ok := arg.(bson.M)
+				if !ok {
+					return nil, fmt.Errorf("$project needs a document")
+				}
+				for i, d := range docs {
+					docs[i] = project(d, sel)
+				}
+			case "$group":
+				docs, err = groupStage(docs, arg)
+			case "$lookup":
+				docs, err = lookupStage(docs, arg, from)
+			default:
+				err = fmt.Errorf("unsupported pipeline stage %s", op)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+// the keys of $sort need an order, so the stage takes a bson.D
+func sortStage(docs []bson.M, arg interface{}) ([]bson.M, error) {
+	var fields []string
+	switch keys := arg.(type) {
+	case bson.D:
+		for _, k := range keys {
+			if isTextScore(k.Value) {
+				fields = append(fields, scoreKey+":"+k.Name)
+			} else if toFloat(k.Value) < 0 {
+				fields = append(fields, "-"+k.Name)
+			} else {
+				fields = append(fields, k.Name)
+			}
+		}
+	case bson.M:
+		if len(keys) > 1 {
+			return nil, fmt.Errorf("$sort on several fields needs a bson.D")
+		}
+		for k, v := range keys {
+			if isTextScore(v) {
+				k = scoreKey + ":" + k
+			} else if toFloat(v) < 0 {
+				k = "-" + k
+			}
+			fields = append(fields, k)
+		}
+	default:
+		return nil, fmt.Errorf("$sort needs a document")
+	}
+	sortDocs(docs, fields)
+	return docs, nil
+}
+
+// {"$meta": "textScore"}
+func isTextScore(v interface{}) bool {
+	m, ok := v.(bson.M)
+	return ok && m["$meta"] == "textScore"
+}
+
+// $group with the $sum, $avg, $min, $max, $first and $push accumulators
+func groupStage(docs []bson.M, arg interface{}) ([]bson.M, error) {
+	spec, ok := arg.(bson.M)
+	if !ok {
+		return nil, fmt.Errorf("$group needs a document")
+	}
+	idExpr, ok := spec["_id"]
+	if !ok {
+		return nil, fmt.Errorf("$group needs an _id")
+	}
+
+	type group struct {
+		id     interface{}
+		values map[string][]interface{}
+	}
+	var groups []*group
+	for _, d := range docs {
+		id, err := evalExpr(d, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		var g *group
+		for _, o := range groups {
+			if valuesEqual(o.id, id) {
+				g = o
+				break
+			}
+		}
+		if g == nil {
+			g = &group{id: id, values: map[string][]interface{}{}}
+			groups = append(groups, g)
+		}
+		for field, acc := range spec {
+			if field == "_id" {
+				continue
+			}
+			m, ok := acc.(bson.M)
+			if !ok || len(m) != 1 {
+				return nil, fmt.Errorf("%s needs one accumulator", field)
+			}
+			for _, expr := range m {
+				v, err := evalExpr(d, expr)
+				if err != nil {
+					return nil, err
+				}
+				g.values[field] = append(g.values[field], v)
+			}
+		}
+	}
+
+	out := make([]bson.M, 0, len(groups))
+	for _, g := range groups {
+		r := bson.M{"_id": g.id}
+		for field, acc := range spec {
+			if field == "_id" {
+				continue
+			}
+			for op := range acc.(bson.M) {
+				v, err := accumulate(op, g.values[field])
+				if err != nil {
+					return nil, err
+				}
+				r[field] = v
+			}
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func accumulate(op string, values []interface{}) (interface{}, error) {
+	switch op {
+	case "$sum", "$avg":
+		var sum interface{} = 0
+		n := 0
+		for _, v := range values {
+			if isNumber(v) {
+				sum = addNumbers(sum, v)
+				n++
+			}
+		}
+		if op == "$sum" {
+			return sum, nil
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		return toFloat(sum) / float64(n), nil
+	case "$min", "$max":
+		var best interface{}
+		for _, v := range values {
+			if v == nil {
+				continue
+			}
+			if best == nil || op == "$min" && compareSort(v, best) < 0 || op == "$max" && compareSort(v, best) > 0 {
+				best = v
+			}
+		}
+		return best, nil
+	case "$first":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[0], nil
+	case "$push":
+		return values, nil
+	}
+	return nil, fmt.Errorf("unsupported accumulator %s", op)
+}
+
+// join the documents of another collection: the variables of let are set from each
+// document and the pipeline runs on the documents of from, the result is in the field as
+func lookupStage(docs []bson.M, arg interface{}, from func(name string) []bson.M) ([]bson.M, error) {
+	spec, err := toM(arg)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := spec["from"].(string)
+	as, _ := spec["as"].(string)
+	if name == "" || as == "" || from == nil {
+		return nil, fmt.Errorf("$lookup needs from and as")
+	}
+	let, _ := spec["let"].(bson.M)
+	stages, ok := spec["pipeline"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("$lookup needs a pipeline")
+	}
+	foreign := from(name)
+
+	for _, d := range docs {
+		vars := map[string]interface{}{}
+		for k, expr := range let {
+			v, err := evalExpr(d, expr)
+			if err != nil {
+				return nil, err
+			}
+			vars[k] = v
+		}
+		var pipeline []bson.M
+		for _, stage := range stages {
+			m, ok := bindVars(stage, vars).(bson.M)
+			if !ok {
+				return nil, fmt.Errorf("a pipeline stage needs a document")
+			}
+			pipeline = append(pipeline, m)
+		}
+		joined, err := aggregate(append([]bson.M(nil), foreign...), pipeline, from)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, len(joined))
+		for i, j := range joined {
+			list[i] = j
+		}
+		d[as] = list
+	}
+	return docs, nil
+}
+
+// replace the "$$name" variables by their values
+func bindVars(v interface{}, vars map[string]interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		if strings.HasPrefix(t, "$$") {
+			if value, ok := vars[t[2:]]; ok {
+				return bson.M{"$literal": value}
+			}
+		}
+	case bson.M:
+		out := bson.M{}
+		for k, e := range t {
+			out[k] = bindVars(e, vars)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			out[i] = bindVars(e, vars)
+		}
+		return out
+	}
+	return v
+}
+
+// value of an expression: "$field" references a field, {"$op": args} applies an
+// operator, anything else is a constant
+func evalExpr(doc bson.M, expr interface{}) (interface{}, error) {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$") {
+			return firstValue(doc, e[1:]), nil
+		}
+	case bson.M:
+		for op, arg := range e {
+			if strings.HasPrefix(op, "$") && len(e) == 1 {
+				return evalOperator(doc, op, arg)
+			}
+		}
+		out := bson.M{}
+		for k, v := range e {
+			value, err := evalExpr(doc, v)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = value
+		}
+		return out, nil
+	}
+	return expr, nil
+}
+
+func evalOperator(doc bson.M, op string, arg interface{}) (interface{}, error) {
+	if op == "$literal" {
+		return arg, nil
+	}
+	list, ok := arg.([]interface{})
+	if !ok {
+		list = []interface{}{arg}
+	}
+	args := make([]interface{}, len(list))
+	for i, a := range list {
+		v, err := evalExpr(doc, a)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	switch op {
+	case "$and", "$or":
+		for _, a := range args {
+			if truthy(a) != (op == "$and") {
+				return op == "$or", nil
+			}
+		}
+		return op == "$and", nil
+	case "$not":
+		return !truthy(args[0]), nil
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s needs 2 arguments", op)
+		}
+		n := compareSort(args[0], args[1])
+		switch op {
+		case "$eq":
+			return n == 0, nil
+		case "$ne":
+			return n != 0, nil
+		case "$gt":
+			return n > 0, nil
+		case "$gte":
+			return n >= 0, nil
+		case "$lt":
+			return n < 0, nil
+		}
+		return n <= 0, nil
+	}
+	return nil, fmt.Errorf("unsupported expression operator %s", op)
+}
@@ -0,0 +1,488 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// document as stored by the memory store
+func storedDoc(t *testing.T, v interface{}) bson.M {
+	t.Helper()
+	m, err := toM(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+var (
+	queryId   = bson.NewObjectId()
+	queryTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+)
+
+func queryDoc(t *testing.T) bson.M {
+	return storedDoc(t, bson.M{
+		"_id":     queryId,
+		"name":    "Devworks Bootcamp",
+		"rating":  8,
+		"cost":    9500.5,
+		"housing": true,
+		"nothing": nil,
+		"careers": []string{"Web Development", "UI/UX", "Business"},
+		"scores":  []int{3, 7, 12},
+		"location": bson.M{
+			"city":        "Boston",
+			"type":        "Point",
+			"coordinates": []float64{-71.104028, 42.350846},
+		},
+		"courses": []bson.M{
+			{"title": "Front End", "weeks": 8, "tags": []string{"html", "css"}},
+			{"title": "Full Stack", "weeks": 12, "tags": []string{"node", "mongo"}},
+		},
+		"createdAt": queryTime,
+	})
+}
+
+// each operator against the behaviour documented by MongoDB
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter bson.M
+		want   bool
+	}{
+		{"empty filter", bson.M{}, true},
+
+		// equality
+		{"string equality", bson.M{"name": "Devworks Bootcamp"}, true},
+		{"string equality is case sensitive", bson.M{"name": "devworks bootcamp"}, false},
+		{"int equals float", bson.M{"rating": 8.0}, true},
+		{"bool equality", bson.M{"housing": true}, true},
+		{"object id equality", bson.M{"_id": queryId}, true},
+		{"time equality", bson.M{"createdAt": queryTime}, true},
+		{"array contains the value", bson.M{"careers": "UI/UX"}, true},
+		{"array does not contain the value", bson.M{"careers": "Data Science"}, false},
+		{"whole array equality", bson.M{"scores": []int{3, 7, 12}}, true},
+		{"whole array in another order", bson.M{"scores": []int{7, 3, 12}}, false},
+		{"missing field equals null", bson.M{"missing": nil}, true},
+		{"null field equals null", bson.M{"nothing": nil}, true},
+		{"present field is not null", bson.M{"name": nil}, false},
+		{"embedded document equality", bson.M{"location.city": "Boston"}, true},
+
+		// dotted paths
+		{"dotted path in array of documents", bson.M{"courses.title": "Full Stack"}, true},
+		{"dotted path with array index", bson.M{"courses.0.title": "Front End"}, true},
+		{"dotted path with wrong array index", bson.M{"courses.1.title": "Front End"}, false},
+		{"dotted path out of the array", bson.M{"courses.5.title": nil}, true},
+		{"nested arrays", bson.M{"courses.tags": "mongo"}, true},
+
+		// $eq and $ne
+		{"$eq", bson.M{"rating": bson.M{"$eq": 8}}, true},
+		{"$eq on array element", bson.M{"careers": bson.M{"$eq": "Business"}}, true},
+		{"$ne", bson.M{"rating": bson.M{"$ne": 8}}, false},
+		{"$ne on array requires no element equal", bson.M{"careers": bson.M{"$ne": "Business"}}, false},
+		{"$ne matches a missing field", bson.M{"missing": bson.M{"$ne": 1}}, true},
+		{"$ne null on a present field", bson.M{"name": bson.M{"$ne": nil}}, true},
+
+		// comparisons
+		{"$gt", bson.M{"rating": bson.M{"$gt": 7}}, true},
+		{"$gt equal", bson.M{"rating": bson.M{"$gt": 8}}, false},
+		{"$gte equal", bson.M{"rating": bson.M{"$gte": 8}}, true},
+		{"$lt float", bson.M{"cost": bson.M{"$lt": 9500.6}}, true},
+		{"$lte", bson.M{"cost": bson.M{"$lte": 9500}}, false},
+		{"range", bson.M{"rating": bson.M{"$gte": 5, "$lte": 10}}, true},
+		{"empty range", bson.M{"rating": bson.M{"$gt": 8, "$lt": 10}}, false},
+		{"$gt on any array element", bson.M{"scores": bson.M{"$gt": 10}}, true},
+		{"range on array elements need not be the same element", bson.M{"scores": bson.M{"$gt": 4, "$lt": 6}}, true},
+		{"$gt string", bson.M{"name": bson.M{"$gt": "A"}}, true},
+		{"$gt on time", bson.M{"createdAt": bson.M{"$gt": queryTime.Add(-time.Second)}}, true},
+		{"$lt on time", bson.M{"createdAt": bson.M{"$lt": queryTime}}, false},
+		{"no comparison across types", bson.M{"name": bson.M{"$gt": 1}}, false},
+		{"no comparison with a missing field", bson.M{"missing": bson.M{"$lt": 1}}, false},
+		{"$gt object id", bson.M{"_id": bson.M{"$gt": bson.ObjectIdHex("000000000000000000000000")}}, true},
+
+		// $in and $nin
+		{"$in", bson.M{"rating": bson.M{"$in": []int{1, 8}}}, true},
+		{"$in none", bson.M{"rating": bson.M{"$in": []int{1, 2}}}, false},
+		{"$in empty", bson.M{"rating": bson.M{"$in": []int{}}}, false},
+		{"$in on array", bson.M{"careers": bson.M{"$in": []string{"Business", "Other"}}}, true},
+		{"$in with null matches a missing field", bson.M{"missing": bson.M{"$in": []interface{}{nil, 1}}}, true},
+		{"$in with regex", bson.M{"name": bson.M{"$in": []interface{}{bson.RegEx{Pattern: "^dev", Options: "i"}}}}, true},
+		{"$in object ids", bson.M{"_id": bson.M{"$in": []bson.ObjectId{bson.NewObjectId(), queryId}}}, true},
+		{"$nin", bson.M{"rating": bson.M{"$nin": []int{1, 2}}}, true},
+		{"$nin with the value", bson.M{"rating": bson.M{"$nin": []int{8}}}, false},
+		{"$nin on array", bson.M{"careers": bson.M{"$nin": []string{"UI/UX"}}}, false},
+		{"$nin matches a missing field", bson.M{"missing": bson.M{"$nin": []int{1}}}, true},
+
+		// arrays
+		{"$all", bson.M{"careers": bson.M{"$all": []string{"Business", "UI/UX"}}}, true},
+		{"$all with a missing value", bson.M{"careers": bson.M{"$all": []string{"Business", "Other"}}}, false},
+		{"$all empty matches nothing", bson.M{"careers": bson.M{"$all": []string{}}}, false},
+		{"$size", bson.M{"careers": bson.M{"$size": 3}}, true},
+		{"$size other", bson.M{"careers": bson.M{"$size": 2}}, false},
+		{"$size on a scalar", bson.M{"name": bson.M{"$size": 1}}, false},
+		{"$elemMatch on documents", bson.M{"courses": bson.M{"$elemMatch": bson.M{"title": "Front End", "weeks": 8}}}, true},
+		{"$elemMatch needs one element", bson.M{"courses": bson.M{"$elemMatch": bson.M{"title": "Front End", "weeks": 12}}}, false},
+		{"$elemMatch with operators", bson.M{"scores": bson.M{"$elemMatch": bson.M{"$gt": 4, "$lt": 6}}}, false},
+		{"$elemMatch with operators on one element", bson.M{"scores": bson.M{"$elemMatch": bson.M{"$gt": 4, "$lt": 8}}}, true},
+
+		// $exists
+		{"$exists true", bson.M{"name": bson.M{"$exists": true}}, true},
+		{"$exists true on null", bson.M{"nothing": bson.M{"$exists": true}}, true},
+		{"$exists false", bson.M{"missing": bson.M{"$exists": false}}, true},
+		{"$exists false on a present field", bson.M{"name": bson.M{"$exists": false}}, false},
+		{"$exists on a dotted path", bson.M{"location.city": bson.M{"$exists": true}}, true},
+
+		// regular expressions
+		{"$regex", bson.M{"name": bson.M{"$regex": "works"}}, true},
+		{"$regex is case sensitive", bson.M{"name": bson.M{"$regex": "^devworks"}}, false},
+		{"$regex with $options", bson.M{"name": bson.M{"$regex": "^devworks", "$options": "i"}}, true},
+		{"$regex with bson.RegEx", bson.M{"name": bson.M{"$regex": bson.RegEx{Pattern: "BOOTCAMP$", Options: "i"}}}, true},
+		{"bson.RegEx value", bson.M{"name": bson.RegEx{Pattern: "^Dev"}}, true},
+		{"$regex on array element", bson.M{"careers": bson.M{"$regex": "^UI"}}, true},
+		{"$regex on a number", bson.M{"rating": bson.M{"$regex": "8"}}, false},
+		{"$regex on a missing field", bson.M{"missing": bson.M{"$regex": ".*"}}, false},
+
+		// $not
+		{"$not with operator", bson.M{"rating": bson.M{"$not": bson.M{"$gt": 10}}}, true},
+		{"$not with matching operator", bson.M{"rating": bson.M{"$not": bson.M{"$gt": 5}}}, false},
+		{"$not matches a missing field", bson.M{"missing": bson.M{"$not": bson.M{"$gt": 5}}}, true},
+		{"$not with regex", bson.M{"name": bson.M{"$not": bson.RegEx{Pattern: "^Dev"}}}, false},
+
+		// logical operators
+		{"$and", bson.M{"$and": []interface{}{bson.M{"rating": 8}, bson.M{"housing": true}}}, true},
+		{"$and with one false", bson.M{"$and": []interface{}{bson.M{"rating": 8}, bson.M{"housing": false}}}, false},
+		{"$or", bson.M{"$or": []interface{}{bson.M{"rating": 1}, bson.M{"housing": true}}}, true},
+		{"$or none", bson.M{"$or": []interface{}{bson.M{"rating": 1}, bson.M{"housing": false}}}, false},
+		{"$nor", bson.M{"$nor": []interface{}{bson.M{"rating": 1}, bson.M{"housing": false}}}, true},
+		{"$nor with one true", bson.M{"$nor": []interface{}{bson.M{"rating": 8}, bson.M{"housing": false}}}, false},
+		{"implicit and with $or", bson.M{"rating": 8, "$or": []interface{}{bson.M{"name": "x"}, bson.M{"cost": 9500.5}}}, true},
+		{"nested logical operators", bson.M{"$or": []interface{}{
+			bson.M{"$and": []interface{}{bson.M{"rating": 8}, bson.M{"housing": false}}},
+			bson.M{"$nor": []interface{}{bson.M{"careers": "Other"}}},
+		}}, true},
+
+		// $expr
+		{"$expr comparing fields", bson.M{"$expr": bson.M{"$gt": []interface{}{"$cost", "$rating"}}}, true},
+		{"$expr false", bson.M{"$expr": bson.M{"$lt": []interface{}{"$cost", "$rating"}}}, false},
+		{"$expr $and", bson.M{"$expr": bson.M{"$and": []interface{}{
+			bson.M{"$eq": []interface{}{"$location.city", "Boston"}},
+			bson.M{"$ne": []interface{}{"$rating", 1}},
+		}}}, true},
+
+		// geo, the radius is in radians (miles / 3963.2)
+		{"$geoWithin inside", bson.M{"location": bson.M{"$geoWithin": bson.M{"$centerSphere": []interface{}{[]float64{-71.06, 42.36}, 10 / 3963.2}}}}, true},
+		{"$geoWithin outside", bson.M{"location": bson.M{"$geoWithin": bson.M{"$centerSphere": []interface{}{[]float64{-74.0, 40.7}, 10 / 3963.2}}}}, false},
+		{"$geoWithin legacy pair", bson.M{"location.coordinates": bson.M{"$geoWithin": bson.M{"$centerSphere": []interface{}{[]float64{-71.1, 42.35}, 1 / 3963.2}}}}, true},
+	}
+	doc := queryDoc(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := match(doc, storedDoc(t, tt.filter))
+			if err != nil {
+				t.Fatalf("match: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("match(%v) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter bson.M
+	}{
+		{"unknown top level operator", bson.M{"$where": "true"}},
+		{"unknown field operator", bson.M{"rating": bson.M{"$near": []int{1, 2}}}},
+		{"$or without array", bson.M{"$or": bson.M{"rating": 8}}},
+		{"$in without array", bson.M{"rating": bson.M{"$in": 8}}},
+		{"invalid regex", bson.M{"name": bson.M{"$regex": "("}}},
+		{"$geoWithin without $centerSphere", bson.M{"location": bson.M{"$geoWithin": bson.M{"$box": []int{}}}}},
+		{"unknown expression operator", bson.M{"$expr": bson.M{"$concat": []interface{}{"$name", "x"}}}},
+	}
+	doc := queryDoc(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := match(doc, storedDoc(t, tt.filter)); err == nil {
+				t.Errorf("match(%v) without error", tt.filter)
+			}
+		})
+	}
+}
+
+func TestApplyUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    bson.M
+		update bson.M
+		insert bool
+		want   bson.M
+	}{
+		{"$set", bson.M{"a": 1}, bson.M{"$set": bson.M{"a": 2, "b": "x"}}, false, bson.M{"a": 2, "b": "x"}},
+		{"$set dotted creates documents", bson.M{}, bson.M{"$set": bson.M{"a.b.c": 1}}, false, bson.M{"a": bson.M{"b": bson.M{"c": 1}}}},
+		{"$set array element", bson.M{"a": []int{1, 2}}, bson.M{"$set": bson.M{"a.1": 5}}, false, bson.M{"a": []int{1, 5}}},
+		{"$unset", bson.M{"a": 1, "b": 2}, bson.M{"$unset": bson.M{"a": ""}}, false, bson.M{"b": 2}},
+		{"$unset dotted", bson.M{"a": bson.M{"b": 1, "c": 2}}, bson.M{"$unset": bson.M{"a.b": ""}}, false, bson.M{"a": bson.M{"c": 2}}},
+		{"$unset missing", bson.M{"a": 1}, bson.M{"$unset": bson.M{"b": ""}}, false, bson.M{"a": 1}},
+		{"$inc int", bson.M{"n": 1}, bson.M{"$inc": bson.M{"n": 2}}, false, bson.M{"n": int64(3)}},
+		{"$inc float", bson.M{"n": 1}, bson.M{"$inc": bson.M{"n": 0.5}}, false, bson.M{"n": 1.5}},
+		{"$inc missing", bson.M{}, bson.M{"$inc": bson.M{"n": -1}}, false, bson.M{"n": int64(-1)}},
+		{"$push", bson.M{"a": []int{1}}, bson.M{"$push": bson.M{"a": 1}}, false, bson.M{"a": []int{1, 1}}},
+		{"$push to missing", bson.M{}, bson.M{"$push": bson.M{"a": "x"}}, false, bson.M{"a": []string{"x"}}},
+		{"$push $each", bson.M{"a": []int{1}}, bson.M{"$push": bson.M{"a": bson.M{"$each": []int{2, 3}}}}, false, bson.M{"a": []int{1, 2, 3}}},
+		{"$push $slice last", bson.M{"a": []int{1, 2}}, bson.M{"$push": bson.M{"a": bson.M{"$each": []int{3, 4}, "$slice": -3}}}, false, bson.M{"a": []int{2, 3, 4}}},
+		{"$push $slice first", bson.M{"a": []int{1, 2}}, bson.M{"$push": bson.M{"a": bson.M{"$each": []int{3}, "$slice": 2}}}, false, bson.M{"a": []int{1, 2}}},
+		{"$push $slice longer", bson.M{"a": []int{1}}, bson.M{"$push": bson.M{"a": bson.M{"$each": []int{2}, "$slice": -5}}}, false, bson.M{"a": []int{1, 2}}},
+		{"$addToSet", bson.M{"a": []int{1, 2}}, bson.M{"$addToSet": bson.M{"a": bson.M{"$each": []int{2, 3}}}}, false, bson.M{"a": []int{1, 2, 3}}},
+		{"$pull value", bson.M{"a": []int{1, 2, 1}}, bson.M{"$pull": bson.M{"a": 1}}, false, bson.M{"a": []int{2}}},
+		{"$pull condition", bson.M{"a": []int{1, 5, 9}}, bson.M{"$pull": bson.M{"a": bson.M{"$gte": 5}}}, false, bson.M{"a": []int{1}}},
+		{"$pull documents", bson.M{"a": []bson.M{{"u": 1, "r": "x"}, {"u": 2, "r": "y"}}}, bson.M{"$pull": bson.M{"a": bson.M{"u": 1}}}, false, bson.M{"a": []bson.M{{"u": 2, "r": "y"}}}},
+		{"$min lower", bson.M{"n": 5}, bson.M{"$min": bson.M{"n": 3}}, false, bson.M{"n": 3}},
+		{"$min higher", bson.M{"n": 5}, bson.M{"$min": bson.M{"n": 7}}, false, bson.M{"n": 5}},
+		{"$max higher", bson.M{"n": 5}, bson.M{"$max": bson.M{"n": 7}}, false, bson.M{"n": 7}},
+		{"$max missing", bson.M{}, bson.M{"$max": bson.M{"n": 7}}, false, bson.M{"n": 7}},
+		{"$setOnInsert on update", bson.M{"a": 1}, bson.M{"$setOnInsert": bson.M{"b": 2}}, false, bson.M{"a": 1}},
+		{"$setOnInsert on insert", bson.M{"a": 1}, bson.M{"$setOnInsert": bson.M{"b": 2}}, true, bson.M{"a": 1, "b": 2}},
+		{"replacement keeps the _id", bson.M{"_id": queryId, "a": 1}, bson.M{"b": 2}, false, bson.M{"_id": queryId, "b": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := storedDoc(t, tt.doc)
+			if err := applyUpdate(doc, storedDoc(t, tt.update), tt.insert); err != nil {
+				t.Fatalf("applyUpdate: %v", err)
+			}
+			if want := storedDoc(t, tt.want); !valuesEqual(storedDoc(t, doc), want) {
+				t.Errorf("got %v, want %v", doc, want)
+			}
+		})
+	}
+}
+
+func TestApplyUpdateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    bson.M
+		update bson.M
+	}{
+		{"unknown operator", bson.M{}, bson.M{"$rename": bson.M{"a": "b"}}},
+		{"$inc on a string", bson.M{"a": "x"}, bson.M{"$inc": bson.M{"a": 1}}},
+		{"$push on a scalar", bson.M{"a": 1}, bson.M{"$push": bson.M{"a": 2}}},
+		{"$set through a scalar", bson.M{"a": 1}, bson.M{"$set": bson.M{"a.b": 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := applyUpdate(storedDoc(t, tt.doc), storedDoc(t, tt.update), false); err == nil {
+				t.Errorf("applyUpdate(%v) without error", tt.update)
+			}
+		})
+	}
+}
+
+func TestUpsertBase(t *testing.T) {
+	got := upsertBase(storedDoc(t, bson.M{
+		"role":    "user",
+		"a.b":     1,
+		"n":       bson.M{"$eq": 2},
+		"m":       bson.M{"$gt": 2},
+		"$or":     []interface{}{bson.M{"x": 1}},
+		"deleted": false,
+	}))
+	want := storedDoc(t, bson.M{"role": "user", "a": bson.M{"b": 1}, "n": 2, "deleted": false})
+	if !valuesEqual(got, want) {
+		t.Errorf("upsertBase = %v, want %v", got, want)
+	}
+}
+
+func TestSortDocs(t *testing.T) {
+	names := func(docs []bson.M) []interface{} {
+		var out []interface{}
+		for _, d := range docs {
+			out = append(out, d["name"])
+		}
+		return out
+	}
+	docs := func() []bson.M {
+		return []bson.M{
+			storedDoc(t, bson.M{"name": "a", "n": 2, "s": "x"}),
+			storedDoc(t, bson.M{"name": "b", "n": 1, "s": "y"}),
+			storedDoc(t, bson.M{"name": "c", "s": "x"}),
+			storedDoc(t, bson.M{"name": "d", "n": "text", "s": "y"}),
+			storedDoc(t, bson.M{"name": "e", "n": 1.5, "s": "x"}),
+		}
+	}
+	tests := []struct {
+		sort []string
+		want []interface{}
+	}{
+		// null before numbers before strings
+		{[]string{"n"}, []interface{}{"c", "b", "e", "a", "d"}},
+		{[]string{"-n"}, []interface{}{"d", "a", "e", "b", "c"}},
+		{[]string{"s", "-name"}, []interface{}{"e", "c", "a", "d", "b"}},
+		{[]string{"+s", "n"}, []interface{}{"c", "e", "a", "b", "d"}},
+		// stable without sort
+		{nil, []interface{}{"a", "b", "c", "d", "e"}},
+	}
+	for _, tt := range tests {
+		d := docs()
+		sortDocs(d, tt.sort)
+		if got := names(d); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sort %v = %v, want %v", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	tests := []struct {
+		name string
+		sel  bson.M
+		want bson.M
+	}{
+		{"include keeps the _id", bson.M{"name": 1}, bson.M{"_id": queryId, "name": "Devworks Bootcamp"}},
+		{"include without _id", bson.M{"name": 1, "_id": 0}, bson.M{"name": "Devworks Bootcamp"}},
+		{"only _id", bson.M{"_id": 1}, bson.M{"_id": queryId}},
+		{"dotted include", bson.M{"location.city": 1, "_id": 0}, bson.M{"location": bson.M{"city": "Boston"}}},
+		{"include missing field", bson.M{"missing": 1, "_id": 0}, bson.M{}},
+	}
+	doc := queryDoc(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := project(doc, storedDoc(t, tt.sel))
+			if want := storedDoc(t, tt.want); !valuesEqual(got, want) {
+				t.Errorf("project(%v) = %v, want %v", tt.sel, got, want)
+			}
+		})
+	}
+
+	// exclusion keeps everything else
+	got := project(doc, bson.M{"courses": 0, "location.coordinates": 0})
+	if _, ok := got["courses"]; ok {
+		t.Error("excluded courses are kept")
+	}
+	if got["name"] != doc["name"] || got["_id"] != queryId {
+		t.Errorf("exclusion dropped other fields: %v", got)
+	}
+	if loc := got["location"].(bson.M); loc["city"] != "Boston" || loc["coordinates"] != nil {
+		t.Errorf("dotted exclusion = %v", loc)
+	}
+	if _, ok := doc["courses"]; !ok {
+		t.Error("project changed the document")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	b1, b2, b3 := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+	bootcamps := []bson.M{
+		storedDoc(t, bson.M{"_id": b1, "name": "b1"}),
+		storedDoc(t, bson.M{"_id": b2, "name": "b2"}),
+		storedDoc(t, bson.M{"_id": b3, "name": "b3"}),
+	}
+	courses := []bson.M{
+		storedDoc(t, bson.M{"title": "c1", "bootcamp": b1, "tuition": 1000, "deleted": false}),
+		storedDoc(t, bson.M{"title": "c2", "bootcamp": b1, "tuition": 3000, "deleted": false}),
+		storedDoc(t, bson.M{"title": "c3", "bootcamp": b2, "tuition": 500.5, "deleted": false}),
+		storedDoc(t, bson.M{"title": "c4", "bootcamp": b2, "tuition": 9000, "deleted": true}),
+	}
+	from := func(name string) []bson.M {
+		if name == "courses" {
+			return courses
+		}
+		return nil
+	}
+
+	t.Run("$group accumulators", func(t *testing.T) {
+		docs, err := aggregate(append([]bson.M(nil), courses...), []bson.M{
+			{"$match": bson.M{"deleted": false}},
+			{"$group": bson.M{
+				"_id":   "$bootcamp",
+				"avg":   bson.M{"$avg": "$tuition"},
+				"sum":   bson.M{"$sum": "$tuition"},
+				"count": bson.M{"$sum": 1},
+				"min":   bson.M{"$min": "$tuition"},
+				"max":   bson.M{"$max": "$tuition"},
+				"first": bson.M{"$first": "$title"},
+				"all":   bson.M{"$push": "$title"},
+			}},
+			{"$sort": bson.M{"_id": 1}},
+		}, from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) != 2 {
+			t.Fatalf("%d groups, want 2", len(docs))
+		}
+		g := docs[0]
+		if g["_id"] != b1 {
+			g = docs[1]
+		}
+		want := storedDoc(t, bson.M{"_id": b1, "avg": 2000.0, "sum": 4000, "count": 2, "min": 1000, "max": 3000, "first": "c1", "all": []string{"c1", "c2"}})
+		if !valuesEqual(storedDoc(t, g), want) {
+			t.Errorf("group = %v, want %v", g, want)
+		}
+	})
+
+	t.Run("$sort $skip $limit $project", func(t *testing.T) {
+		docs, err := aggregate(append([]bson.M(nil), courses...), []bson.M{
+			{"$sort": bson.D{{Name: "deleted", Value: 1}, {Name: "tuition", Value: -1}}},
+			{"$skip": 1},
+			{"$limit": 2},
+			{"$project": bson.M{"title": 1, "_id": 0}},
+		}, from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []bson.M{{"title": "c1"}, {"title": "c3"}}
+		if !reflect.DeepEqual(docs, want) {
+			t.Errorf("got %v, want %v", docs, want)
+		}
+	})
+
+	t.Run("$lookup with let and pipeline", func(t *testing.T) {
+		docs, err := aggregate(append([]bson.M(nil), bootcamps...), []bson.M{
+			{"$lookup": bson.M{
+				"from": "courses",
+				"let":  bson.M{"id": "$_id"},
+				"pipeline": []bson.M{
+					{"$match": bson.M{"$expr": bson.M{"$and": []interface{}{
+						bson.M{"$eq": []interface{}{"$bootcamp", "$$id"}},
+						bson.M{"$eq": []interface{}{"$deleted", false}},
+					}}}},
+					{"$sort": bson.M{"tuition": -1}},
+					{"$project": bson.M{"title": 1, "_id": 0}},
+				},
+				"as": "courses",
+			}},
+		}, from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[bson.ObjectId][]interface{}{
+			b1: {bson.M{"title": "c2"}, bson.M{"title": "c1"}},
+			b2: {bson.M{"title": "c3"}},
+			b3: {},
+		}
+		for _, d := range docs {
+			if got := d["courses"]; !reflect.DeepEqual(got, want[d["_id"].(bson.ObjectId)]) {
+				t.Errorf("courses of %v = %v, want %v", d["name"], got, want[d["_id"].(bson.ObjectId)])
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, p := range [][]bson.M{
+			{{"$unwind": "$courses"}},
+			{{"$match": bson.M{}, "$limit": 1}},
+			{{"$sort": bson.M{"a": 1, "b": 1}}},
+			{{"$group": bson.M{"count": bson.M{"$sum": 1}}}},
+			{{"$group": bson.M{"_id": nil, "x": bson.M{"$stdDevPop": "$tuition"}}}},
+			{{"$lookup": bson.M{"from": "courses"}}},
+		} {
+			if _, err := aggregate(append([]bson.M(nil), courses...), p, from); err == nil {
+				t.Errorf("pipeline %v without error", p)
+			}
+		}
+	})
+}
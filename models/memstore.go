@@ -0,0 +1,558 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// store keeping the documents in memory, for tests and local runs without MongoDB
+// it understands the subset of the MongoDB query language used by the app (see memquery.go)
+type MemoryStore struct {
+	mu          sync.Mutex
+	collections map[string]*memoryCollection
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		collections: map[string]*memoryCollection{},
+	}
+}
+
+func (s *MemoryStore) C(model string) Collection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.collections[model]
+	if !ok {
+		c = &memoryCollection{
+			name:  model,
+			store: s,
+		}
+		s.collections[model] = c
+	}
+	return c
+}
+
+// copy of the documents of a collection, for $lookup
+func (s *MemoryStore) documents(name string) []bson.M {
+	c := s.C(name).(*memoryCollection)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	docs := make([]bson.M, len(c.docs))
+	for i, d := range c.docs {
+		docs[i] = copyM(d)
+	}
+	return docs
+}
+
+type memoryCollection struct {
+	name  string
+	store *MemoryStore
+	mu    sync.RWMutex
+	// documents in insertion order, as they would be stored by MongoDB
+	docs    []bson.M
+	indexes []mgo.Index
+}
+
+func (c *memoryCollection) Name() string {
+	return c.name
+}
+
+func (c *memoryCollection) Init(doc mongodm.IDocumentBase) {
+	doc.SetDocument(doc)
+}
+
+func (c *memoryCollection) Find(q Query, result interface{}) error {
+	filter, err := toM(q.Filter)
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	filter, search, err := c.textSearch(filter)
+	if err != nil {
+		c.mu.RUnlock()
+		return err
+	}
+	docs, err := c.match(filter)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if search != nil {
+		docs = search.score(docs)
+	}
+	sortDocs(docs, q.Sort)
+	docs = skipLimit(docs, q.Skip, q.Limit)
+	if q.Select != nil {
+		for i, d := range docs {
+			docs[i] = project(d, q.Select)
+		}
+	}
+	if search != nil {
+		unscore(docs)
+	}
+	return decodeAll(docs, result)
+}
+
+func (c *memoryCollection) FindOne(filter bson.M, doc mongodm.IDocumentBase) error {
+	f, err := toM(filter)
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	i, err := c.first(f)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return ErrNotFound
+	}
+	return decode(c.docs[i], doc)
+}
+
+func (c *memoryCollection) Count(filter bson.M) (int, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	f, _, err = c.textSearch(f)
+	if err != nil {
+		return 0, err
+	}
+	docs, err := c.match(f)
+	return len(docs), err
+}
+
+func (c *memoryCollection) Save(doc mongodm.IDocumentBase) error {
+	now := time.Now()
+	if doc.GetId() == "" {
+		doc.SetId(bson.NewObjectId())
+		doc.SetCreatedAt(now)
+	}
+	doc.SetUpdatedAt(now)
+	m, err := toM(doc)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.indexOf(doc.GetId())
+	if err := c.checkUnique(m, i); err != nil {
+		return err
+	}
+	if i < 0 {
+		c.docs = append(c.docs, m)
+	} else {
+		c.docs[i] = m
+	}
+	doc.SetDocument(doc)
+	return nil
+}
+
+func (c *memoryCollection) FindAndModify(filter bson.M, update bson.M, upsert bool, doc mongodm.IDocumentBase) error {
+	f, err := toM(filter)
+	if err != nil {
+		return err
+	}
+	u, err := toM(update)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, err := c.first(f)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		if !upsert {
+			return ErrNotFound
+		}
+		m := upsertBase(f)
+		if err := applyUpdate(m, u, true); err != nil {
+			return err
+		}
+		if _, ok := m["_id"]; !ok {
+			m["_id"] = bson.NewObjectId()
+		}
+		if err := c.checkUnique(m, -1); err != nil {
+			return err
+		}
+		c.docs = append(c.docs, m)
+		return decode(m, doc)
+	}
+
+	m := copyM(c.docs[i])
+	if err := applyUpdate(m, u, false); err != nil {
+		return err
+	}
+	if err := c.checkUnique(m, i); err != nil {
+		return err
+	}
+	c.docs[i] = m
+	return decode(m, doc)
+}
+
+func (c *memoryCollection) UpdateAll(filter bson.M, update bson.M) (int, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return 0, err
+	}
+	u, err := toM(update)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for i, d := range c.docs {
+		ok, err := match(d, f)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			continue
+		}
+		m := copyM(d)
+		if err := applyUpdate(m, u, false); err != nil {
+			return n, err
+		}
+		if err := c.checkUnique(m, i); err != nil {
+			return n, err
+		}
+		c.docs[i] = m
+		n++
+	}
+	return n, nil
+}
+
+func (c *memoryCollection) RemoveAll(filter bson.M) (int, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := c.docs[:0]
+	for _, d := range c.docs {
+		ok, err := match(d, f)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			kept = append(kept, d)
+		}
+	}
+	n := len(c.docs) - len(kept)
+	c.docs = kept
+	return n, nil
+}
+
+func (c *memoryCollection) Aggregate(pipeline []bson.M, result interface{}) error {
+	c.mu.RLock()
+	docs := make([]bson.M, len(c.docs))
+	for i, d := range c.docs {
+		docs[i] = copyM(d)
+	}
+	// like MongoDB, a text search can only be the first stage
+	var search *textSearch
+	if len(pipeline) > 0 && pipeline[0]["$match"] != nil {
+		f, err := toM(pipeline[0]["$match"])
+		if err == nil {
+			f, search, err = c.textSearch(f)
+		}
+		if err != nil {
+			c.mu.RUnlock()
+			return err
+		}
+		pipeline = append([]bson.M{{"$match": f}}, pipeline[1:]...)
+	}
+	c.mu.RUnlock()
+	if search != nil {
+		docs = search.score(docs)
+	}
+
+	docs, err := aggregate(docs, pipeline, c.store.documents)
+	if err != nil {
+		return err
+	}
+	if search != nil {
+		unscore(docs)
+	}
+	return decodeAll(docs, result)
+}
+
+// only the unique and text indexes matter in memory, the others speed up MongoDB
+func (c *memoryCollection) EnsureIndex(index mgo.Index) error {
+	if !index.Unique && textFields(index) == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indexes = append(c.indexes, index)
+	return nil
+}
+
+// matching documents, the caller holds the lock
+func (c *memoryCollection) match(filter bson.M) ([]bson.M, error) {
+	var docs []bson.M
+	for _, d := range c.docs {
+		ok, err := match(d, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, d)
+		}
+	}
+	return docs, nil
+}
+
+// position of the first matching document, -1 when none, the caller holds the lock
+func (c *memoryCollection) first(filter bson.M) (int, error) {
+	for i, d := range c.docs {
+		ok, err := match(d, filter)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+func (c *memoryCollection) indexOf(id bson.ObjectId) int {
+	for i, d := range c.docs {
+		if d["_id"] == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ErrDuplicate when m has the keys of another document on a unique index,
+// the document at position self is the one being replaced
+func (c *memoryCollection) checkUnique(m bson.M, self int) error {
+	for _, index := range c.indexes {
+		if !index.Unique {
+			continue
+		}
+		keys := indexKeys(index)
+		if index.Sparse && !hasAll(m, keys) {
+			continue
+		}
+		for i, d := range c.docs {
+			if i == self {
+				continue
+			}
+			if index.Sparse && !hasAll(d, keys) {
+				continue
+			}
+			same := true
+			for _, k := range keys {
+				if !valuesEqual(firstValue(d, k), firstValue(m, k)) {
+					same = false
+					break
+				}
+			}
+			if same {
+				return ErrDuplicate
+			}
+		}
+	}
+	return nil
+}
+
+// field names of the index key, e.g. "-createdAt" or "$2dsphere:location"
+func indexKeys(index mgo.Index) []string {
+	keys := make([]string, len(index.Key))
+	for i, k := range index.Key {
+		if j := strings.Index(k, ":"); strings.HasPrefix(k, "$") && j > 0 {
+			k = k[j+1:]
+		}
+		keys[i] = strings.TrimLeft(k, "+-")
+	}
+	return keys
+}
+
+// fields of a text index, e.g. "$text:name", nil for the other indexes
+func textFields(index mgo.Index) []string {
+	var fields []string
+	for _, k := range index.Key {
+		if strings.HasPrefix(k, "$text:") {
+			fields = append(fields, strings.TrimPrefix(k, "$text:"))
+		}
+	}
+	return fields
+}
+
+// text search of a query, on the fields of the text index of the collection
+type textSearch struct {
+	search string
+	// weight of each field, 1 by default
+	weights map[string]int
+}
+
+// the filter with the regexes of the words of its $text search in place of it, and the
+// search to score the documents, nil without $text, the caller holds the lock
+func (c *memoryCollection) textSearch(filter bson.M) (bson.M, *textSearch, error) {
+	text, ok := filter["$text"]
+	if !ok {
+		return filter, nil, nil
+	}
+	spec, _ := text.(bson.M)
+	s, ok := spec["$search"].(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("$text needs a $search string")
+	}
+	var fields []string
+	weights := map[string]int{}
+	for _, index := range c.indexes {
+		if fields = textFields(index); fields != nil {
+			for _, f := range fields {
+				weights[f] = 1
+				if w := index.Weights[f]; w > 0 {
+					weights[f] = w
+				}
+			}
+			break
+		}
+	}
+	if fields == nil {
+		return nil, nil, fmt.Errorf("text index required for $text query")
+	}
+
+	f := bson.M{}
+	for k, v := range filter {
+		f[k] = v
+	}
+	delete(f, "$text")
+	and := []interface{}{textFilter(s, fields)}
+	if prev, ok := f["$and"].([]interface{}); ok {
+		and = append(prev, and...)
+	}
+	f["$and"] = and
+	return f, &textSearch{search: s, weights: weights}, nil
+}
+
+// copies of the documents with their score, see project and sortDocs
+func (t *textSearch) score(docs []bson.M) []bson.M {
+	scored := make([]bson.M, len(docs))
+	for i, d := range docs {
+		scored[i] = copyM(d)
+		scored[i][scoreKey] = textScore(d, t.search, t.weights)
+	}
+	return scored
+}
+
+// remove the score of the text search from the documents
+func unscore(docs []bson.M) {
+	for _, d := range docs {
+		delete(d, scoreKey)
+	}
+}
+
+func hasAll(m bson.M, keys []string) bool {
+	for _, k := range keys {
+		if len(lookup(m, k)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func skipLimit(docs []bson.M, skip int, limit int) []bson.M {
+	if skip > 0 {
+		if skip >= len(docs) {
+			return nil
+		}
+		docs = docs[skip:]
+	}
+	if limit > 0 && limit < len(docs) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+// document as stored, every value takes the type MongoDB would give it back
+func toM(v interface{}) (bson.M, error) {
+	m := bson.M{}
+	if v == nil {
+		return m, nil
+	}
+	if mv, ok := v.(bson.M); ok && mv == nil {
+		return m, nil
+	}
+	bs, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return m, bson.Unmarshal(bs, m)
+}
+
+func copyM(m bson.M) bson.M {
+	c, err := toM(m)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func decode(m bson.M, doc mongodm.IDocumentBase) error {
+	bs, err := bson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	err = bson.Unmarshal(bs, doc)
+	if err != nil {
+		return err
+	}
+	doc.SetDocument(doc)
+	return nil
+}
+
+// decode the documents into result, a pointer to a slice of documents or of bson.M
+func decodeAll(docs []bson.M, result interface{}) error {
+	rv := reflect.ValueOf(result).Elem()
+	et := rv.Type().Elem()
+	slice := reflect.MakeSlice(rv.Type(), 0, len(docs))
+	for _, d := range docs {
+		bs, err := bson.Marshal(d)
+		if err != nil {
+			return err
+		}
+		var ev reflect.Value
+		if et.Kind() == reflect.Ptr {
+			ev = reflect.New(et.Elem())
+		} else {
+			ev = reflect.New(et)
+		}
+		err = bson.Unmarshal(bs, ev.Interface())
+		if err != nil {
+			return err
+		}
+		if doc, ok := ev.Interface().(mongodm.IDocumentBase); ok {
+			doc.SetDocument(doc)
+		}
+		if et.Kind() != reflect.Ptr {
+			ev = ev.Elem()
+		}
+		slice = reflect.Append(slice, ev)
+	}
+	rv.Set(slice)
+	return nil
+}
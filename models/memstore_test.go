@@ -0,0 +1,337 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type memDoc struct {
+	mongodm.DocumentBase `json:",inline" bson:",inline"`
+	Name                 string   `json:"name" bson:"name"`
+	Email                string   `json:"email,omitempty" bson:"email,omitempty"`
+	Tags                 []string `json:"tags" bson:"tags"`
+	N                    int      `json:"n" bson:"n"`
+	Text                 string   `json:"text" bson:"text"`
+}
+
+func newMemDoc(c Collection, name string, n int) *memDoc {
+	d := &memDoc{Name: name, N: n}
+	c.Init(d)
+	return d
+}
+
+// collection with the documents a (n=3), b (n=1), c (n=2) saved in this order
+func memCollection(t *testing.T) Collection {
+	t.Helper()
+	c := NewMemoryStore().C("Doc")
+	for _, d := range []*memDoc{
+		{Name: "a", N: 3, Tags: []string{"x"}},
+		{Name: "b", N: 1, Tags: []string{"x", "y"}},
+		{Name: "c", N: 2},
+	} {
+		c.Init(d)
+		if err := c.Save(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func docNames(docs []*memDoc) []string {
+	names := []string{}
+	for _, d := range docs {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+func TestMemorySave(t *testing.T) {
+	c := NewMemoryStore().C("Doc")
+	d := newMemDoc(c, "a", 1)
+	if err := c.Save(d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Id == "" || d.CreatedAt.IsZero() || d.UpdatedAt.IsZero() {
+		t.Fatalf("Save did not set id and dates: %+v", d.DocumentBase)
+	}
+	created := d.CreatedAt
+
+	d.Name = "b"
+	if err := c.Save(d); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Count(bson.M{}); n != 1 {
+		t.Errorf("%d documents after replace, want 1", n)
+	}
+	found := &memDoc{}
+	if err := c.FindOne(bson.M{"_id": d.Id}, found); err != nil {
+		t.Fatal(err)
+	}
+	// dates are kept in milliseconds like in MongoDB
+	if found.Name != "b" || !found.CreatedAt.Equal(created.Truncate(time.Millisecond)) {
+		t.Errorf("found %+v", found)
+	}
+
+	// the stored document is a copy
+	d.Name = "changed"
+	c.FindOne(bson.M{"_id": d.Id}, found)
+	if found.Name != "b" {
+		t.Error("the stored document changed without Save")
+	}
+}
+
+func TestMemoryUniqueIndex(t *testing.T) {
+	c := NewMemoryStore().C("Doc")
+	c.EnsureIndex(mgo.Index{Key: []string{"name"}, Unique: true})
+	c.EnsureIndex(mgo.Index{Key: []string{"email"}, Unique: true, Sparse: true})
+
+	a := newMemDoc(c, "a", 1)
+	if err := c.Save(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(newMemDoc(c, "a", 2)); err != ErrDuplicate {
+		t.Errorf("Save of a duplicate = %v, want %v", err, ErrDuplicate)
+	}
+	// replacing the document itself is not a duplicate
+	a.N = 5
+	if err := c.Save(a); err != nil {
+		t.Errorf("Save of the same document: %v", err)
+	}
+	// sparse: the documents without email are not indexed
+	if err := c.Save(newMemDoc(c, "b", 1)); err != nil {
+		t.Errorf("Save without the sparse key: %v", err)
+	}
+	d := newMemDoc(c, "c", 1)
+	d.Email = "a@gmail.com"
+	if err := c.Save(d); err != nil {
+		t.Fatal(err)
+	}
+	d = newMemDoc(c, "d", 1)
+	d.Email = "a@gmail.com"
+	if err := c.Save(d); err != ErrDuplicate {
+		t.Errorf("Save of a duplicate sparse key = %v, want %v", err, ErrDuplicate)
+	}
+	// updates are checked too
+	if _, err := c.UpdateAll(bson.M{"name": "b"}, bson.M{"$set": bson.M{"name": "a"}}); err != ErrDuplicate {
+		t.Errorf("UpdateAll to a duplicate = %v, want %v", err, ErrDuplicate)
+	}
+	if err := c.FindAndModify(bson.M{"name": "b"}, bson.M{"$set": bson.M{"name": "c"}}, false, &memDoc{}); err != ErrDuplicate {
+		t.Errorf("FindAndModify to a duplicate = %v, want %v", err, ErrDuplicate)
+	}
+}
+
+func TestMemoryFind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"insertion order", Query{}, []string{"a", "b", "c"}},
+		{"filter", Query{Filter: bson.M{"tags": "x"}}, []string{"a", "b"}},
+		{"sort", Query{Sort: []string{"n"}}, []string{"b", "c", "a"}},
+		{"sort descending", Query{Sort: []string{"-n"}}, []string{"a", "c", "b"}},
+		{"skip and limit", Query{Sort: []string{"n"}, Skip: 1, Limit: 1}, []string{"c"}},
+		{"skip past the end", Query{Skip: 5}, []string{}},
+		{"no match", Query{Filter: bson.M{"n": bson.M{"$gt": 10}}}, []string{}},
+	}
+	c := memCollection(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := []*memDoc{}
+			if err := c.Find(tt.query, &docs); err != nil {
+				t.Fatal(err)
+			}
+			if got := docNames(docs); !equalStrings(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// select into bson.M
+	docs := []bson.M{}
+	if err := c.Find(Query{Select: bson.M{"name": 1}, Sort: []string{"name"}, Limit: 1}, &docs); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || len(docs[0]) != 2 || docs[0]["name"] != "a" || docs[0]["_id"] == nil {
+		t.Errorf("selected %v", docs)
+	}
+}
+
+func TestMemoryFindOneCount(t *testing.T) {
+	c := memCollection(t)
+	d := &memDoc{}
+	if err := c.FindOne(bson.M{"n": bson.M{"$lt": 3}}, d); err != nil || d.Name != "b" {
+		t.Errorf("FindOne = %v, %v, want the first match b", d.Name, err)
+	}
+	if err := c.FindOne(bson.M{"name": "z"}, d); err != ErrNotFound {
+		t.Errorf("FindOne without match = %v, want %v", err, ErrNotFound)
+	}
+	if n, err := c.Count(bson.M{"tags.0": bson.M{"$exists": true}}); err != nil || n != 2 {
+		t.Errorf("Count = %d, %v, want 2", n, err)
+	}
+	if _, err := c.Count(bson.M{"$where": "1"}); err == nil {
+		t.Error("Count with an unsupported operator without error")
+	}
+}
+
+func TestMemoryFindAndModify(t *testing.T) {
+	c := memCollection(t)
+
+	d := &memDoc{}
+	err := c.FindAndModify(bson.M{"name": "a"}, bson.M{"$inc": bson.M{"n": 1}, "$push": bson.M{"tags": "z"}}, false, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.N != 4 || !equalStrings(d.Tags, []string{"x", "z"}) {
+		t.Errorf("updated %+v", d)
+	}
+
+	if err := c.FindAndModify(bson.M{"name": "z"}, bson.M{"$set": bson.M{"n": 1}}, false, d); err != ErrNotFound {
+		t.Errorf("FindAndModify without match = %v, want %v", err, ErrNotFound)
+	}
+
+	// upsert: the equalities of the filter and $setOnInsert make the document
+	err = c.FindAndModify(bson.M{"name": "z"}, bson.M{"$set": bson.M{"n": 7}, "$setOnInsert": bson.M{"text": "new"}}, true, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Id == "" || d.Name != "z" || d.N != 7 || d.Text != "new" {
+		t.Errorf("upserted %+v", d)
+	}
+	err = c.FindAndModify(bson.M{"name": "z"}, bson.M{"$set": bson.M{"n": 8}, "$setOnInsert": bson.M{"text": "again"}}, true, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.N != 8 || d.Text != "new" {
+		t.Errorf("second upsert %+v, want an update", d)
+	}
+	if n, _ := c.Count(bson.M{"name": "z"}); n != 1 {
+		t.Errorf("%d upserted documents, want 1", n)
+	}
+}
+
+func TestMemoryUpdateRemoveAll(t *testing.T) {
+	c := memCollection(t)
+	n, err := c.UpdateAll(bson.M{"n": bson.M{"$gte": 2}}, bson.M{"$set": bson.M{"text": "big"}})
+	if err != nil || n != 2 {
+		t.Fatalf("UpdateAll = %d, %v, want 2", n, err)
+	}
+	if n, _ := c.Count(bson.M{"text": "big"}); n != 2 {
+		t.Errorf("%d updated documents, want 2", n)
+	}
+
+	n, err = c.RemoveAll(bson.M{"text": "big"})
+	if err != nil || n != 2 {
+		t.Fatalf("RemoveAll = %d, %v, want 2", n, err)
+	}
+	docs := []*memDoc{}
+	c.Find(Query{}, &docs)
+	if got := docNames(docs); !equalStrings(got, []string{"b"}) {
+		t.Errorf("left %v, want [b]", got)
+	}
+}
+
+func TestMemoryAggregateLookup(t *testing.T) {
+	s := NewMemoryStore()
+	parents, children := s.C("Parent"), s.C("Child")
+	parents.Save(newMemDoc(parents, "p", 1))
+	parents.Save(newMemDoc(parents, "q", 2))
+	// the children keep the name of their parent in text
+	for i, name := range []string{"c1", "c2", "c3"} {
+		c := newMemDoc(children, name, i)
+		c.Text = "p"
+		if name == "c3" {
+			c.Text = "r"
+		}
+		children.Save(c)
+	}
+
+	docs := []bson.M{}
+	err := parents.Aggregate([]bson.M{
+		{"$sort": bson.M{"name": 1}},
+		{"$lookup": bson.M{
+			"from":     children.Name(),
+			"let":      bson.M{"parent": "$name"},
+			"pipeline": []bson.M{{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$text", "$$parent"}}}}, {"$sort": bson.M{"n": -1}}},
+			"as":       "children",
+		}},
+	}, &docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("%d documents, want 2", len(docs))
+	}
+	if list := docs[0]["children"].([]interface{}); len(list) != 2 || list[0].(bson.M)["name"] != "c2" || list[1].(bson.M)["name"] != "c1" {
+		t.Errorf("children of p = %v, want c2 and c1", list)
+	}
+	if list := docs[1]["children"].([]interface{}); len(list) != 0 {
+		t.Errorf("children of q = %v, want none", list)
+	}
+}
+
+func TestMemoryTextSearch(t *testing.T) {
+	c := NewMemoryStore().C("Doc")
+	for _, v := range []struct{ name, text string }{
+		{"a", "web development and design"},
+		{"b", "web web web"},
+		{"c", "data science"},
+	} {
+		d := newMemDoc(c, v.name, 0)
+		d.Text = v.text
+		c.Save(d)
+	}
+
+	if _, err := c.Count(bson.M{"$text": bson.M{"$search": "web"}}); err == nil {
+		t.Error("$text without a text index, want an error")
+	}
+	c.EnsureIndex(mgo.Index{Key: []string{"$text:name", "$text:text"}, Weights: map[string]int{"name": 10}})
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{"web", []string{"b", "a"}},
+		{"WEB science", []string{"b", "a", "c"}},
+		{`"web development"`, []string{"a"}},
+		{"web -design", []string{"b"}},
+		{"-web", []string{}},
+		{"c", []string{"c"}},
+	}
+	for _, tt := range tests {
+		docs := []*memDoc{}
+		q := Query{Filter: bson.M{"$text": bson.M{"$search": tt.search}}, Sort: []string{textScoreSort, "name"}}
+		if err := c.Find(q, &docs); err != nil {
+			t.Fatalf("%s: %v", tt.search, err)
+		}
+		if got := docNames(docs); !equalStrings(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.search, got, tt.want)
+		}
+	}
+
+	// the score is projected with {"$meta": "textScore"}
+	docs := []bson.M{}
+	c.Find(Query{Filter: bson.M{"$text": bson.M{"$search": "web"}}, Select: bson.M{"score": bson.M{"$meta": "textScore"}}, Sort: []string{textScoreSort}}, &docs)
+	if len(docs) != 2 || docs[0]["score"] != 3.0 || docs[1]["score"] != 1.0 {
+		t.Errorf("scores %v, want 3 then 1", docs)
+	}
+	if _, ok := docs[0][scoreKey]; ok {
+		t.Error("the score is left in the documents")
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"reflect"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// store backed by the mongodm connection, the models must be registered on it
+type MongoStore struct {
+	connection *mongodm.Connection
+}
+
+func NewMongoStore(conn *mongodm.Connection) *MongoStore {
+	return &MongoStore{
+		connection: conn,
+	}
+}
+
+// mount every model of the repositories to DB, before NewMongoStore
+func RegisterModels(conn *mongodm.Connection) {
+	conn.Register(&Bootcamp{}, "bootcamps")
+	conn.Register(&Course{}, "courses")
+	conn.Register(&User{}, "users")
+	conn.Register(&Review{}, "reviews")
+	conn.Register(&Geocode{}, "geocodes")
+	conn.Register(&Session{}, "sessions")
+	conn.Register(&RolePolicy{}, "rolepolicies")
+	conn.Register(&LoginAttempt{}, "loginattempts")
+	conn.Register(&DeletionBatch{}, "deletionbatches")
+	conn.Register(&Transfer{}, "transfers")
+	conn.Register(&Organization{}, "organizations")
+}
+
+func (s *MongoStore) C(model string) Collection {
+	return &mongoCollection{
+		model: s.connection.Model(model),
+	}
+}
+
+type mongoCollection struct {
+	model *mongodm.Model
+}
+
+func (c *mongoCollection) Name() string {
+	return c.model.Collection.Name
+}
+
+func (c *mongoCollection) Init(doc mongodm.IDocumentBase) {
+	c.model.New(doc)
+}
+
+func (c *mongoCollection) Find(q Query, result interface{}) error {
+	// mongodm can only init documents, plain results (e.g. []bson.M) are read with mgo
+	if !isDocumentSlice(result) {
+		query := c.model.Collection.Find(q.Filter)
+		if q.Select != nil {
+			query.Select(q.Select)
+		}
+		if len(q.Sort) > 0 {
+			query.Sort(q.Sort...)
+		}
+		query.Skip(q.Skip).Limit(q.Limit)
+		return mongoError(query.All(result))
+	}
+
+	query := c.model.Find(q.Filter)
+	if q.Select != nil {
+		query.Select(q.Select)
+	}
+	if len(q.Sort) > 0 {
+		query.Sort(q.Sort...)
+	}
+	query.Skip(q.Skip).Limit(q.Limit)
+	return mongoError(query.Exec(result))
+}
+
+func (c *mongoCollection) FindOne(filter bson.M, doc mongodm.IDocumentBase) error {
+	return mongoError(c.model.FindOne(filter).Exec(doc))
+}
+
+func (c *mongoCollection) Count(filter bson.M) (int, error) {
+	return c.model.Find(filter).Count()
+}
+
+func (c *mongoCollection) Save(doc mongodm.IDocumentBase) error {
+	c.model.New(doc)
+	return mongoError(doc.Save())
+}
+
+func (c *mongoCollection) FindAndModify(filter bson.M, update bson.M, upsert bool, doc mongodm.IDocumentBase) error {
+	change := mgo.Change{
+		Update:    update,
+		Upsert:    upsert,
+		ReturnNew: true,
+	}
+	_, err := c.model.Collection.Find(filter).Apply(change, doc)
+	if err != nil {
+		return mongoError(err)
+	}
+	c.model.New(doc)
+	return nil
+}
+
+func (c *mongoCollection) UpdateAll(filter bson.M, update bson.M) (int, error) {
+	info, err := c.model.UpdateAll(filter, update)
+	if err != nil {
+		return 0, mongoError(err)
+	}
+	return info.Updated, nil
+}
+
+func (c *mongoCollection) RemoveAll(filter bson.M) (int, error) {
+	info, err := c.model.RemoveAll(filter)
+	if err != nil {
+		return 0, mongoError(err)
+	}
+	return info.Removed, nil
+}
+
+func (c *mongoCollection) Aggregate(pipeline []bson.M, result interface{}) error {
+	return mongoError(c.model.Pipe(pipeline).All(result))
+}
+
+func (c *mongoCollection) EnsureIndex(index mgo.Index) error {
+	return c.model.EnsureIndex(index)
+}
+
+func isDocumentSlice(result interface{}) bool {
+	t := reflect.TypeOf(result).Elem().Elem()
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	return t.Implements(reflect.TypeOf((*mongodm.IDocumentBase)(nil)).Elem())
+}
+
+// convert the errors of mongodm and mgo to the store errors
+func mongoError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*mongodm.NotFoundError); ok || err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	if _, ok := err.(*mongodm.DuplicateError); ok || mgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
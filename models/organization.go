@@ -0,0 +1,258 @@
+package models
+
+import (
+	"crypto/rand"
+	"devcamper/utils"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// roles of the members of an organization
+const (
+	// manage the members and the bootcamps of the organization
+	OrgOwner = "owner"
+	// edit the bootcamps of the organization and their courses
+	OrgEditor = "editor"
+	// see the organization and its members
+	OrgViewer = "viewer"
+)
+
+// every role a member can have
+var OrgRoles = []string{OrgOwner, OrgEditor, OrgViewer}
+
+// actions on the bootcamps of the organization that can be granted to a member,
+// on top of the permissions of the role of the user
+var orgRolePermissions = map[string][]string{
+	OrgOwner: {
+		BootcampUpdate,
+		BootcampDelete,
+		BootcampTransfer,
+		CourseCreate,
+		CourseUpdate,
+		CourseDelete,
+	},
+	OrgEditor: {
+		BootcampUpdate,
+		CourseCreate,
+		CourseUpdate,
+		CourseDelete,
+	},
+}
+
+// lifetime of an invite
+const InviteExpire = 7 * 24 * time.Hour
+
+var (
+	ErrLastOwner     = &StoreError{"the organization needs at least one owner", http.StatusBadRequest}
+	ErrInviteInvalid = &StoreError{"your invite is invalid or expired", http.StatusBadRequest}
+)
+
+// organization owning bootcamps, its members edit them according to their role
+type Organization struct {
+	mongodm.DocumentBase `json:",inline" bson:",inline"`
+	Name                 string   `json:"name" bson:"name" required:"true" maxLen:"50"`
+	Members              []Member `json:"members" bson:"members"`
+	Invites              []Invite `json:"invites" bson:"invites"`
+}
+
+type Member struct {
+	User     bson.ObjectId `json:"user" bson:"user"`
+	Role     string        `json:"role" bson:"role"`
+	JoinedAt time.Time     `json:"joinedAt" bson:"joinedAt"`
+}
+
+// invite of an email to join as a member, accepted with the emailed token
+type Invite struct {
+	Email     string        `json:"email" bson:"email"`
+	Role      string        `json:"role" bson:"role"`
+	InvitedBy bson.ObjectId `json:"invitedBy" bson:"invitedBy"`
+	TokenHash string        `json:"-" bson:"tokenHash"`
+	ExpiredAt time.Time     `json:"expiredAt" bson:"expiredAt"`
+}
+
+// override validate function to aviod check before save (will check explicitly)
+func (o *Organization) Validate(values ...interface{}) (bool, []error) {
+	return true, nil
+}
+
+// check data before create or update organization
+func (o *Organization) ValidateCreate() (bool, []error) {
+	return o.DefaultValidate()
+}
+
+func IsOrgRole(role string) bool {
+	for _, v := range OrgRoles {
+		if v == role {
+			return true
+		}
+	}
+	return false
+}
+
+// role of the user in the organization, empty when it is not a member
+func (o *Organization) Role(userId bson.ObjectId) string {
+	for _, m := range o.Members {
+		if m.User == userId {
+			return m.Role
+		}
+	}
+	return ""
+}
+
+// check if the role of the user in the organization grants the action on its bootcamps
+func (o *Organization) Can(userId bson.ObjectId, action string) bool {
+	for _, p := range orgRolePermissions[o.Role(userId)] {
+		if p == action {
+			return true
+		}
+	}
+	return false
+}
+
+// add the user as a member or change its role, ErrLastOwner when the last owner would be demoted
+func (o *Organization) SetMember(userId bson.ObjectId, role string) error {
+	for i, m := range o.Members {
+		if m.User == userId {
+			if m.Role == OrgOwner && role != OrgOwner && o.owners() == 1 {
+				return ErrLastOwner
+			}
+			o.Members[i].Role = role
+			return nil
+		}
+	}
+	o.Members = append(o.Members, Member{User: userId, Role: role, JoinedAt: time.Now()})
+	return nil
+}
+
+// remove the member, ErrLastOwner for the last owner, false when the user is not a member
+func (o *Organization) RemoveMember(userId bson.ObjectId) (bool, error) {
+	for i, m := range o.Members {
+		if m.User == userId {
+			if m.Role == OrgOwner && o.owners() == 1 {
+				return false, ErrLastOwner
+			}
+			o.Members = append(o.Members[:i], o.Members[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (o *Organization) owners() int {
+	n := 0
+	for _, m := range o.Members {
+		if m.Role == OrgOwner {
+			n++
+		}
+	}
+	return n
+}
+
+// invite the email with role, a previous invite of the email is replaced,
+// the token to send is returned, only its hash keyed with secret is kept
+func (o *Organization) Invite(email string, role string, by bson.ObjectId, secret string) string {
+	bs := make([]byte, 20)
+	io.ReadFull(rand.Reader, bs)
+	invite := Invite{
+		Email:     email,
+		Role:      role,
+		InvitedBy: by,
+		TokenHash: utils.HashToken(secret, bs),
+		ExpiredAt: time.Now().Add(InviteExpire),
+	}
+	o.RemoveInvite(email)
+	o.Invites = append(o.Invites, invite)
+	return hex.EncodeToString(bs)
+}
+
+// remove the invite of the email, false when there is none
+func (o *Organization) RemoveInvite(email string) bool {
+	for i, v := range o.Invites {
+		if strings.EqualFold(v.Email, email) {
+			o.Invites = append(o.Invites[:i], o.Invites[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// add the user as a member with the role of the invite of its email with the hash of the token,
+// ErrInviteInvalid when there is no such invite or it expired
+func (o *Organization) Join(user *User, tokenHash string) error {
+	for _, v := range o.Invites {
+		if v.TokenHash != tokenHash || !strings.EqualFold(v.Email, user.Email) || v.ExpiredAt.Before(time.Now()) {
+			continue
+		}
+		o.RemoveInvite(v.Email)
+		// an owner keeps its role
+		if o.Role(user.Id) == OrgOwner {
+			return nil
+		}
+		return o.SetMember(user.Id, v.Role)
+	}
+	return ErrInviteInvalid
+}
+
+// check if the user can do the action on the bootcamp: by its role, as the owner of the
+// bootcamp or as a member of org, the organization of the bootcamp (nil when it has none)
+func (u *User) CanOnBootcamp(action string, bootcamp *Bootcamp, org *Organization) bool {
+	if u.CanOn(action, bootcamp.User) {
+		return true
+	}
+	return org != nil && org.Id == bootcamp.Organization && u.Can(action+Own) && org.Can(u.Id, action)
+}
+
+// organizations of the store
+type OrganizationRepo interface {
+	// new organization ready to be validated and saved
+	New() *Organization
+	// find the live organization by id
+	FindId(id bson.ObjectId) (*Organization, error)
+	// live organizations the user is a member of
+	FindByMember(userId bson.ObjectId) ([]*Organization, error)
+	Save(org *Organization) error
+}
+
+type organizationRepo struct {
+	repo
+}
+
+func NewOrganizationRepo(store Store) OrganizationRepo {
+	return &organizationRepo{newRepo(store, "Organization")}
+}
+
+func (r *organizationRepo) New() *Organization {
+	org := &Organization{}
+	r.c.Init(org)
+	org.Members = []Member{}
+	org.Invites = []Invite{}
+	return org
+}
+
+func (r *organizationRepo) FindId(id bson.ObjectId) (*Organization, error) {
+	org := &Organization{}
+	err := r.c.FindOne(bson.M{"_id": id, "deleted": false}, org)
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+func (r *organizationRepo) FindByMember(userId bson.ObjectId) ([]*Organization, error) {
+	orgs := []*Organization{}
+	err := r.c.Find(Query{
+		Filter: bson.M{"members.user": userId, "deleted": false},
+		Sort:   []string{"name", "_id"},
+	}, &orgs)
+	return orgs, err
+}
+
+func (r *organizationRepo) Save(org *Organization) error {
+	return r.c.Save(org)
+}
@@ -1,24 +1,240 @@
 package models
 
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
 type Pagination struct {
+	// page asked with page, 0 with a cursor
+	Page       int `json:"page,omitempty"`
+	Limit      int `json:"limit"`
+	TotalPages int `json:"totalPages"`
+	// documents matching the filter, on all the pages
+	Total int `json:"total"`
+
 	Next struct {
-		Page  int `json:"page,omitempty"`
-		Limit int `json:"limit,omitempty"`
+		Page   int    `json:"page,omitempty"`
+		Limit  int    `json:"limit,omitempty"`
+		Cursor string `json:"cursor,omitempty"`
 	} `json:"next"`
 
 	Prev struct {
-		Page  int `json:"page,omitempty"`
-		Limit int `json:"limit,omitempty"`
+		Page   int    `json:"page,omitempty"`
+		Limit  int    `json:"limit,omitempty"`
+		Cursor string `json:"cursor,omitempty"`
 	} `json:"prev"`
+
+	// sort of the query, the cursors are made of its keys
+	sort []string
+	// the page was asked with a prev cursor, the documents come in reverse order
+	reverse bool
+	hasNext bool
+	hasPrev bool
 }
 
 func (p *Pagination) Fill(page int, limit int, startIndex int, endIndex int, total int) {
+	p.Page = page
+	p.setTotal(limit, total)
 	if endIndex < total {
 		p.Next.Page = page + 1
 		p.Next.Limit = limit
+		p.hasNext = true
 	}
 	if startIndex > 0 {
 		p.Prev.Page = page - 1
 		p.Prev.Limit = limit
+		p.hasPrev = true
+	}
+}
+
+// pages around a cursor, remaining is the count of the documents after the cursor
+// (before it for a prev cursor)
+func (p *Pagination) fillCursor(c *cursor, limit int, total int, remaining int) {
+	p.setTotal(limit, total)
+	p.reverse = c.Prev
+	hasMore, hasBefore := remaining > limit, total > remaining
+	if c.Prev {
+		hasMore, hasBefore = hasBefore, hasMore
+	}
+	if hasMore {
+		p.Next.Limit = limit
+		p.hasNext = true
+	}
+	if hasBefore {
+		p.Prev.Limit = limit
+		p.hasPrev = true
+	}
+}
+
+func (p *Pagination) setTotal(limit int, total int) {
+	p.Limit = limit
+	p.Total = total
+	p.TotalPages = (total + limit - 1) / limit
+}
+
+// set the Link (first, prev, next and last pages, RFC 8288) and X-Total-Count headers
+// of the list at u, after the cursors are set
+func (p *Pagination) SetHeaders(w http.ResponseWriter, u *url.URL) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(p.Total))
+
+	links := []string{
+		pageLink(u, "page", strconv.Itoa(1), "first"),
+	}
+	// a list asked by page links to pages, by cursor to cursors
+	if p.Page > 0 {
+		if p.Prev.Page > 0 {
+			links = append(links, pageLink(u, "page", strconv.Itoa(p.Prev.Page), "prev"))
+		}
+		if p.Next.Page > 0 {
+			links = append(links, pageLink(u, "page", strconv.Itoa(p.Next.Page), "next"))
+		}
+	} else {
+		if p.Prev.Cursor != "" {
+			links = append(links, pageLink(u, "cursor", p.Prev.Cursor, "prev"))
+		}
+		if p.Next.Cursor != "" {
+			links = append(links, pageLink(u, "cursor", p.Next.Cursor, "next"))
+		}
+	}
+	if p.TotalPages > 0 {
+		links = append(links, pageLink(u, "page", strconv.Itoa(p.TotalPages), "last"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// link to the list at u with another page or cursor, the other params are kept
+func pageLink(u *url.URL, key string, value string, rel string) string {
+	q := u.Query()
+	q.Del("page")
+	q.Del("cursor")
+	q.Set(key, value)
+	link := url.URL{
+		Path:     u.Path,
+		RawQuery: q.Encode(),
+	}
+	return fmt.Sprintf(`<%s>; rel="%s"`, link.String(), rel)
+}
+
+// set the cursors of the next and previous pages from the documents of the page,
+// docs is the slice returned by the repository, put back in order for a prev cursor
+func (p *Pagination) Cursors(docs interface{}) error {
+	n := reflect.ValueOf(docs).Len()
+	if p.reverse {
+		swap := reflect.Swapper(docs)
+		for i := 0; i < n/2; i++ {
+			swap(i, n-1-i)
+		}
+	}
+	// no cursor for the results sorted by relevance
+	if n == 0 || p.sort == nil {
+		return nil
+	}
+
+	var err error
+	if p.hasNext {
+		p.Next.Cursor, err = newCursor(p.sort, reflect.ValueOf(docs).Index(n-1).Interface(), false)
+		if err != nil {
+			return err
+		}
+	}
+	if p.hasPrev {
+		p.Prev.Cursor, err = newCursor(p.sort, reflect.ValueOf(docs).Index(0).Interface(), true)
+	}
+	return err
+}
+
+// position in a sorted list, the values of the sort keys of a document
+type cursor struct {
+	Sort   []string      `bson:"s"`
+	Values []interface{} `bson:"v"`
+	// the page before the document instead of after
+	Prev bool `bson:"p,omitempty"`
+}
+
+// opaque cursor of doc, sort ends with the _id so the position is unique
+func newCursor(sort []string, doc interface{}, prev bool) (string, error) {
+	m, err := toM(doc)
+	if err != nil {
+		return "", err
+	}
+	c := cursor{
+		Sort: sort,
+		Prev: prev,
+	}
+	for _, key := range sort {
+		c.Values = append(c.Values, firstValue(m, strings.TrimLeft(key, "+-")))
+	}
+	bs, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &cursor{}
+	err = bson.Unmarshal(bs, c)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Values) != len(c.Sort) {
+		return nil, queryErrorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// documents after the cursor in the sort order (before it for a prev cursor)
+func (c *cursor) filter() bson.M {
+	or := []bson.M{}
+	for i, key := range c.Sort {
+		cond := bson.M{}
+		for j := 0; j < i; j++ {
+			// $eq so a value is never read as an operator
+			cond[strings.TrimLeft(c.Sort[j], "+-")] = bson.M{"$eq": c.Values[j]}
+		}
+		op := "$gt"
+		if strings.HasPrefix(key, "-") != c.Prev {
+			op = "$lt"
+		}
+		cond[strings.TrimLeft(key, "+-")] = bson.M{op: c.Values[i]}
+		or = append(or, cond)
+	}
+	return bson.M{"$or": or}
+}
+
+func (c *cursor) matches(sort []string) bool {
+	if len(c.Sort) != len(sort) {
+		return false
+	}
+	for i := range sort {
+		if c.Sort[i] != sort[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sort in the opposite order
+func reverseSort(sort []string) []string {
+	reversed := make([]string, len(sort))
+	for i, key := range sort {
+		if strings.HasPrefix(key, "-") {
+			reversed[i] = strings.TrimPrefix(key, "-")
+		} else {
+			reversed[i] = "-" + strings.TrimLeft(key, "+")
+		}
 	}
+	return reversed
 }
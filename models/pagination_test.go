@@ -0,0 +1,337 @@
+package models
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCursorEncodeDecode(t *testing.T) {
+	id := bson.NewObjectId()
+	doc := &Bootcamp{Name: "Devworks", AverageCost: 8000}
+	doc.Id = id
+	sort := []string{"-averageCost", "name", "-_id"}
+
+	for _, prev := range []bool{false, true} {
+		s, err := newCursor(sort, doc, prev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ContainsAny(s, "+/=") {
+			t.Errorf("cursor %q is not url safe", s)
+		}
+		c, err := decodeCursor(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &cursor{Sort: sort, Values: []interface{}{8000, "Devworks", id}, Prev: prev}
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("decodeCursor(newCursor()) = %+v, want %+v", c, want)
+		}
+		if !c.matches(sort) || c.matches(sort[1:]) || c.matches([]string{"averageCost", "name", "-_id"}) {
+			t.Errorf("matches is wrong for %v", sort)
+		}
+	}
+
+	// the values and the keys are checked together
+	bs, _ := bson.Marshal(cursor{Sort: sort, Values: []interface{}{8000}})
+	for _, s := range []string{"", "not a cursor!", "bm90IGJzb24", base64.RawURLEncoding.EncodeToString(bs)} {
+		if _, err := decodeCursor(s); err == nil {
+			t.Errorf("decodeCursor(%q) should fail", s)
+		}
+	}
+}
+
+func TestCursorFilter(t *testing.T) {
+	id := bson.NewObjectId()
+	tests := []struct {
+		name   string
+		cursor cursor
+		want   bson.M
+	}{
+		{
+			"ascending",
+			cursor{Sort: []string{"name", "_id"}, Values: []interface{}{"b", id}},
+			bson.M{"$or": []bson.M{
+				{"name": bson.M{"$gt": "b"}},
+				{"name": bson.M{"$eq": "b"}, "_id": bson.M{"$gt": id}},
+			}},
+		},
+		{
+			"descending with tie-break",
+			cursor{Sort: []string{"-averageCost", "-_id"}, Values: []interface{}{8000, id}},
+			bson.M{"$or": []bson.M{
+				{"averageCost": bson.M{"$lt": 8000}},
+				{"averageCost": bson.M{"$eq": 8000}, "_id": bson.M{"$lt": id}},
+			}},
+		},
+		{
+			"prev reverses each key",
+			cursor{Sort: []string{"-averageCost", "+name", "_id"}, Values: []interface{}{8000, "b", id}, Prev: true},
+			bson.M{"$or": []bson.M{
+				{"averageCost": bson.M{"$gt": 8000}},
+				{"averageCost": bson.M{"$eq": 8000}, "name": bson.M{"$lt": "b"}},
+				{"averageCost": bson.M{"$eq": 8000}, "name": bson.M{"$eq": "b"}, "_id": bson.M{"$lt": id}},
+			}},
+		},
+		{
+			// a value is never read as an operator
+			"operator value",
+			cursor{Sort: []string{"name", "_id"}, Values: []interface{}{bson.M{"$ne": ""}, id}},
+			bson.M{"$or": []bson.M{
+				{"name": bson.M{"$gt": bson.M{"$ne": ""}}},
+				{"name": bson.M{"$eq": bson.M{"$ne": ""}}, "_id": bson.M{"$gt": id}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cursor.filter(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseSort(t *testing.T) {
+	got := reverseSort([]string{"-averageCost", "+name", "_id"})
+	if want := []string{"averageCost", "-name", "-_id"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reverseSort() = %v, want %v", got, want)
+	}
+}
+
+// bootcamps named b0...b6, the costs tie by pairs so the _id breaks the ties
+func paginationRepo(t *testing.T) BootcampRepo {
+	t.Helper()
+	repo := NewBootcampRepo(NewMemoryStore())
+	for i, cost := range []int{3, 1, 2, 1, 3, 2, 1} {
+		b := repo.New()
+		b.Name = "b" + string(rune('0'+i))
+		b.Slug = b.Name
+		b.AverageCost = cost
+		if err := repo.Save(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+// page of the url query, its names and the pagination with the cursors set
+func queryPage(t *testing.T, repo BootcampRepo, rawQuery string) ([]string, Pagination) {
+	t.Helper()
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, p, err := AdvanceQuery(values, repo)
+	if err != nil {
+		t.Fatalf("AdvanceQuery(%s): %v", rawQuery, err)
+	}
+	bootcamps, err := repo.Find(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Cursors(bootcamps); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, b := range bootcamps {
+		names = append(names, b.Name)
+	}
+	return names, p
+}
+
+func TestAdvanceQueryCursor(t *testing.T) {
+	repo := paginationRepo(t)
+	all, _ := queryPage(t, repo, "sort=-averageCost&limit=100")
+
+	// the ties keep the order of the _id, descending like the last key
+	want := []string{"b4", "b0", "b5", "b2", "b6", "b3", "b1"}
+	if !reflect.DeepEqual(all, want) {
+		t.Fatalf("all = %v, want %v", all, want)
+	}
+
+	// next pages to the end, then prev pages back to the start
+	var pages [][]string
+	names, p := queryPage(t, repo, "sort=-averageCost&limit=2")
+	pages = append(pages, names)
+	if p.Page != 1 || p.Prev.Cursor != "" || p.Next.Cursor == "" {
+		t.Fatalf("first page = %+v", p)
+	}
+	for p.Next.Cursor != "" {
+		names, p = queryPage(t, repo, "sort=-averageCost&limit=2&cursor="+p.Next.Cursor)
+		pages = append(pages, names)
+		if p.Page != 0 || p.Total != 7 || p.TotalPages != 4 {
+			t.Errorf("cursor page = %+v", p)
+		}
+	}
+	if got := concat(pages); !reflect.DeepEqual(got, want) {
+		t.Errorf("next pages = %v, want %v", pages, want)
+	}
+	if len(pages) != 4 || p.Prev.Cursor == "" {
+		t.Fatalf("last page = %v %+v", pages, p)
+	}
+
+	pages = [][]string{pages[len(pages)-1]}
+	for p.Prev.Cursor != "" {
+		names, p = queryPage(t, repo, "sort=-averageCost&limit=2&cursor="+p.Prev.Cursor)
+		pages = append([][]string{names}, pages...)
+	}
+	if got := concat(pages); !reflect.DeepEqual(got, want) {
+		t.Errorf("prev pages = %v, want %v", pages, want)
+	}
+	if p.Next.Cursor == "" {
+		t.Errorf("first page by cursor has no next cursor: %+v", p)
+	}
+
+	// a document added before the cursor does not shift the next page
+	_, p = queryPage(t, repo, "sort=-averageCost&limit=2")
+	b := repo.New()
+	b.Name = "b7"
+	b.Slug = b.Name
+	b.AverageCost = 4
+	if err := repo.Save(b); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := queryPage(t, repo, "sort=-averageCost&limit=2&cursor="+p.Next.Cursor); !reflect.DeepEqual(names, want[2:4]) {
+		t.Errorf("page after an insert = %v, want %v", names, want[2:4])
+	}
+}
+
+func TestAdvanceQueryCursorErrors(t *testing.T) {
+	repo := paginationRepo(t)
+	_, p := queryPage(t, repo, "sort=-averageCost&limit=2")
+
+	tests := []struct {
+		name     string
+		rawQuery string
+		message  string
+	}{
+		{"other sort", "sort=averageCost&limit=2&cursor=" + p.Next.Cursor, "the cursor was made for another sort"},
+		{"default sort", "limit=2&cursor=" + p.Next.Cursor, "the cursor was made for another sort"},
+		{"with page", "sort=-averageCost&limit=2&page=2&cursor=" + p.Next.Cursor, "use either cursor or page"},
+		{"invalid", "sort=-averageCost&cursor=abc", "invalid cursor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.rawQuery)
+			_, _, err := AdvanceQuery(values, repo)
+			if _, ok := err.(*QueryError); !ok || err.Error() != tt.message {
+				t.Errorf("AdvanceQuery(%s) error = %v, want %q", tt.rawQuery, err, tt.message)
+			}
+		})
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	repo := paginationRepo(t)
+	u, _ := url.Parse("/api/v1/bootcamps?sort=-averageCost&limit=2&page=2&housing=true")
+
+	tests := []struct {
+		name     string
+		rawQuery string
+		links    []string
+	}{
+		{
+			"first page",
+			"sort=-averageCost&limit=2",
+			[]string{"page=1", "first", "page=2", "next", "page=4", "last"},
+		},
+		{
+			"middle page",
+			"sort=-averageCost&limit=2&page=2",
+			[]string{"page=1", "first", "page=1", "prev", "page=3", "next", "page=4", "last"},
+		},
+		{
+			"last page",
+			"sort=-averageCost&limit=2&page=4",
+			[]string{"page=1", "first", "page=3", "prev", "page=4", "last"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, p := queryPage(t, repo, tt.rawQuery)
+			w := httptest.NewRecorder()
+			p.SetHeaders(w, u)
+			if got := w.Header().Get("X-Total-Count"); got != "7" {
+				t.Errorf("X-Total-Count = %q, want 7", got)
+			}
+			got := parseLinks(t, w.Header().Get("Link"))
+			want := [][2]string{}
+			for i := 0; i < len(tt.links); i += 2 {
+				want = append(want, [2]string{tt.links[i], tt.links[i+1]})
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Link = %q, want %v", w.Header().Get("Link"), want)
+			}
+		})
+	}
+
+	// the cursor pages link to the cursors, the other params are kept
+	_, p := queryPage(t, repo, "sort=-averageCost&limit=2")
+	_, p = queryPage(t, repo, "sort=-averageCost&limit=2&cursor="+p.Next.Cursor)
+	w := httptest.NewRecorder()
+	p.SetHeaders(w, u)
+	for _, link := range strings.Split(w.Header().Get("Link"), ", ") {
+		target := strings.TrimPrefix(strings.SplitN(link, ">", 2)[0], "<")
+		lu, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := lu.Query()
+		if lu.Path != "/api/v1/bootcamps" || q.Get("housing") != "true" || q.Get("sort") != "-averageCost" {
+			t.Errorf("link %s lost the params", link)
+		}
+		switch {
+		case strings.HasSuffix(link, `rel="prev"`):
+			if q.Get("cursor") != p.Prev.Cursor || q.Get("page") != "" {
+				t.Errorf("prev link = %s", link)
+			}
+		case strings.HasSuffix(link, `rel="next"`):
+			if q.Get("cursor") != p.Next.Cursor || q.Get("page") != "" {
+				t.Errorf("next link = %s", link)
+			}
+		}
+	}
+	if got := w.Header().Get("Link"); !strings.Contains(got, `rel="prev"`) || !strings.Contains(got, `rel="next"`) {
+		t.Errorf("Link = %q, want prev and next cursors", got)
+	}
+
+	// no last page when there is nothing
+	w = httptest.NewRecorder()
+	(&Pagination{Limit: 2}).SetHeaders(w, u)
+	if got := w.Header().Get("X-Total-Count"); got != "0" {
+		t.Errorf("X-Total-Count = %q, want 0", got)
+	}
+	if got := w.Header().Get("Link"); strings.Contains(got, "last") {
+		t.Errorf("Link = %q, want no last page", got)
+	}
+}
+
+// page param and rel of each link of a Link header
+func parseLinks(t *testing.T, header string) [][2]string {
+	t.Helper()
+	links := [][2]string{}
+	for _, link := range strings.Split(header, ", ") {
+		parts := strings.SplitN(link, ">; rel=", 2)
+		lu, err := url.Parse(strings.TrimPrefix(parts[0], "<"))
+		if err != nil || len(parts) != 2 {
+			t.Fatalf("bad link %q", link)
+		}
+		links = append(links, [2]string{"page=" + lu.Query().Get("page"), strings.Trim(parts[1], `"`)})
+	}
+	return links
+}
+
+func concat(pages [][]string) []string {
+	all := []string{}
+	for _, p := range pages {
+		all = append(all, p...)
+	}
+	return all
+}
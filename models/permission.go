@@ -0,0 +1,166 @@
+package models
+
+import (
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	RoleUser      = "user"
+	RolePublisher = "publisher"
+	RoleAdmin     = "admin"
+)
+
+// every role a user can have
+var Roles = []string{RoleUser, RolePublisher, RoleAdmin}
+
+// roles that can be chosen when register
+var RegisterRoles = []string{RoleUser, RolePublisher}
+
+// actions that can be granted to a role
+const (
+	BootcampCreate = "bootcamp:create"
+	BootcampUpdate = "bootcamp:update"
+	BootcampDelete = "bootcamp:delete"
+	// give the bootcamp and its courses to another publisher
+	BootcampTransfer = "bootcamp:transfer"
+	// course create is checked against the owner of the bootcamp
+	CourseCreate = "course:create"
+	CourseUpdate = "course:update"
+	CourseDelete = "course:delete"
+	ReviewCreate = "review:create"
+	ReviewUpdate = "review:update"
+	ReviewDelete = "review:delete"
+	UserRead     = "user:read"
+	UserCreate   = "user:create"
+	UserUpdate   = "user:update"
+	UserDelete   = "user:delete"
+	RoleAssign   = "role:assign"
+	// set role policies such as required 2FA
+	RolePolicyUpdate = "role:policy"
+	// override the quotas of a user
+	QuotaUpdate = "quota:update"
+	// list and restore the deleted documents
+	TrashRead    = "trash:read"
+	TrashRestore = "trash:restore"
+	// create an organization, its members are managed by its owners
+	OrganizationCreate = "organization:create"
+	// manage the organizations the user does not own
+	OrganizationManage = "organization:manage"
+)
+
+// scope of an action on owned resources
+// Own grants the action on resources owned by the user, Any on every resource
+const (
+	Own = ":own"
+	Any = ":any"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser: {
+		ReviewCreate,
+		ReviewUpdate + Own,
+		ReviewDelete + Own,
+	},
+	RolePublisher: {
+		BootcampCreate,
+		BootcampUpdate + Own,
+		BootcampDelete + Own,
+		BootcampTransfer + Own,
+		CourseCreate + Own,
+		CourseUpdate + Own,
+		CourseDelete + Own,
+		OrganizationCreate,
+	},
+	RoleAdmin: {
+		BootcampCreate,
+		BootcampUpdate + Any,
+		BootcampDelete + Any,
+		BootcampTransfer + Any,
+		CourseCreate + Any,
+		CourseUpdate + Any,
+		CourseDelete + Any,
+		ReviewCreate,
+		ReviewUpdate + Any,
+		ReviewDelete + Any,
+		UserRead,
+		UserCreate,
+		UserUpdate,
+		UserDelete,
+		RoleAssign,
+		RolePolicyUpdate,
+		QuotaUpdate,
+		TrashRead,
+		TrashRestore,
+		OrganizationCreate,
+		OrganizationManage,
+	},
+}
+
+// actions that need a verified email, they publish content under the user's address
+var verifiedActions = map[string]bool{
+	BootcampCreate: true,
+	BootcampUpdate: true,
+	BootcampDelete: true,
+	CourseCreate:   true,
+	CourseUpdate:   true,
+	CourseDelete:   true,
+}
+
+// check if the action (with or without scope) needs a verified email
+func NeedVerifiedEmail(action string) bool {
+	return verifiedActions[strings.TrimSuffix(strings.TrimSuffix(action, Own), Any)]
+}
+
+// check if the role of the user grants the action
+// an action without scope is granted by both its Own and Any scope
+func (u *User) Can(action string) bool {
+	for _, p := range rolePermissions[u.Role] {
+		if p == action || strings.TrimSuffix(strings.TrimSuffix(p, Own), Any) == action {
+			return true
+		}
+	}
+	return false
+}
+
+// check if the user can do the action (without scope) on a resource owned by ownerId
+func (u *User) CanOn(action string, ownerId interface{}) bool {
+	if u.Can(action + Any) {
+		return true
+	}
+	id, ok := ownerId.(bson.ObjectId)
+	return ok && id == u.Id && u.Can(action+Own)
+}
+
+// check if the role can be chosen when register, empty role is the default user role
+func CanRegisterAs(role string) bool {
+	if role == "" {
+		return true
+	}
+	for _, v := range RegisterRoles {
+		if v == role {
+			return true
+		}
+	}
+	return false
+}
+
+// promote the user with the email to admin when there is no admin yet
+func BootstrapAdmin(users UserRepo, email string) (bool, error) {
+	if n, err := users.Count(bson.M{"role": RoleAdmin, "deleted": false}); err != nil || n > 0 {
+		return false, err
+	}
+
+	query := bson.M{
+		"email":   email,
+		"deleted": false,
+	}
+	user, err := users.FindOne(query)
+	if err != nil {
+		return false, err
+	}
+	user.Role = RoleAdmin
+	err = users.Save(user)
+	return err == nil, err
+}
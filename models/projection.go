@@ -0,0 +1,226 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fields of the documents asked by the select param, by their json names with dots for
+// the nested ones, e.g. "name,location.city" keeps them and "-description" removes them
+type projection struct {
+	paths   []string
+	exclude bool
+	// stored name of each field, empty when it is not stored (e.g. the courses of a bootcamp)
+	stored map[string]string
+}
+
+// the documents with the fields asked by the select param of the url query, as is without it
+func (q Query) Project(docs interface{}) (interface{}, error) {
+	if q.projection == nil {
+		return docs, nil
+	}
+	return q.projection.apply(docs)
+}
+
+func parseSelect(s string, model interface{}) (*projection, error) {
+	fields := jsonPaths(reflect.TypeOf(model))
+	p := &projection{
+		stored: map[string]string{},
+	}
+	for i, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		exclude := strings.HasPrefix(v, "-")
+		v = strings.TrimPrefix(v, "-")
+		if i > 0 && exclude != p.exclude {
+			return nil, queryErrorf("select cannot keep and remove fields at once")
+		}
+		p.exclude = exclude
+		stored, ok := fields[v]
+		if !ok {
+			return nil, queryErrorf("cannot select %s", v)
+		}
+		p.paths = append(p.paths, v)
+		p.stored[v] = stored
+	}
+
+	// a field covers its nested fields, e.g. location and location.city
+	var paths []string
+	for _, v := range p.paths {
+		covered := contains(paths, v)
+		for _, o := range p.paths {
+			covered = covered || strings.HasPrefix(v, o+".")
+		}
+		if !covered {
+			paths = append(paths, v)
+		}
+	}
+	p.paths = paths
+	return p, nil
+}
+
+// projection of the store, keep are the stored fields never removed (e.g. the sort keys
+// the cursors are made of)
+func (p *projection) bson(keep []string) bson.M {
+	sel := bson.M{}
+	for _, v := range p.paths {
+		stored := p.stored[v]
+		if stored == "" || p.exclude && contains(keep, stored) {
+			continue
+		}
+		if p.exclude {
+			sel[stored] = 0
+		} else {
+			sel[stored] = 1
+		}
+	}
+	if !p.exclude {
+		for _, v := range keep {
+			sel[v] = 1
+		}
+	}
+	if len(sel) == 0 {
+		return nil
+	}
+	return sel
+}
+
+// the json of docs (a document or a slice of them) with the fields of the projection
+func (p *projection) apply(docs interface{}) (interface{}, error) {
+	bs, err := json.Marshal(docs)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(docs)
+	if v.Kind() != reflect.Slice {
+		var doc map[string]interface{}
+		err = json.Unmarshal(bs, &doc)
+		if err != nil {
+			return nil, err
+		}
+		return p.applyDoc(doc), nil
+	}
+
+	var list []map[string]interface{}
+	err = json.Unmarshal(bs, &list)
+	if err != nil {
+		return nil, err
+	}
+	for i, doc := range list {
+		list[i] = p.applyDoc(doc)
+	}
+	return list, nil
+}
+
+func (p *projection) applyDoc(doc map[string]interface{}) map[string]interface{} {
+	if p.exclude {
+		for _, v := range p.paths {
+			removePath(doc, strings.Split(v, "."))
+		}
+		return doc
+	}
+	out := map[string]interface{}{}
+	for _, v := range p.paths {
+		copyPath(out, doc, strings.Split(v, "."))
+	}
+	return out
+}
+
+func copyPath(dst map[string]interface{}, src map[string]interface{}, parts []string) {
+	v, ok := src[parts[0]]
+	if !ok {
+		return
+	}
+	if len(parts) == 1 {
+		dst[parts[0]] = v
+		return
+	}
+	nested, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	d, ok := dst[parts[0]].(map[string]interface{})
+	if !ok {
+		d = map[string]interface{}{}
+		dst[parts[0]] = d
+	}
+	copyPath(d, nested, parts[1:])
+}
+
+func removePath(doc map[string]interface{}, parts []string) {
+	if len(parts) == 1 {
+		delete(doc, parts[0])
+		return
+	}
+	if nested, ok := doc[parts[0]].(map[string]interface{}); ok {
+		removePath(nested, parts[1:])
+	}
+}
+
+// json path of every field shown by the type t, e.g. "location.city", with its stored path
+func jsonPaths(t reflect.Type) map[string]string {
+	paths := map[string]string{}
+	addJsonPaths(paths, t, "", "")
+	return paths
+}
+
+func addJsonPaths(paths map[string]string, t reflect.Type, prefix string, storedPrefix string) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		stored := strings.Split(f.Tag.Get("bson"), ",")[0]
+		if stored == "" {
+			stored = strings.ToLower(f.Name)
+		}
+
+		// embedded fields are shown with the fields of the document (DocumentBase)
+		if f.Anonymous && name == "" {
+			addJsonPaths(paths, f.Type, prefix, storedPrefix)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		path := prefix + name
+		if stored == "-" || storedPrefix == "-" {
+			paths[path] = ""
+		} else {
+			paths[path] = storedPrefix + stored
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft.PkgPath() != "time" {
+			nested := paths[path]
+			if nested == "" {
+				nested = "-"
+			} else {
+				nested += "."
+			}
+			addJsonPaths(paths, ft, path+".", nested)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// quotas on the documents a user can create
+const (
+	// live bootcamps owned by the user
+	QuotaBootcamps = "bootcamps"
+	// live courses of a bootcamp
+	QuotaCoursesPerBootcamp = "coursesPerBootcamp"
+	// live reviews of the user on a bootcamp
+	QuotaReviewsPerBootcamp = "reviewsPerBootcamp"
+)
+
+// every quota a user can have
+var Quotas = []string{QuotaBootcamps, QuotaCoursesPerBootcamp, QuotaReviewsPerBootcamp}
+
+// override of a user without limit
+const Unlimited = -1
+
+// default quotas of each role, a quota that is not listed has no limit
+var roleQuotas = map[string]map[string]int{
+	RoleUser: {
+		QuotaReviewsPerBootcamp: 1,
+	},
+	RolePublisher: {
+		QuotaBootcamps:          1,
+		QuotaCoursesPerBootcamp: 20,
+		QuotaReviewsPerBootcamp: 1,
+	},
+}
+
+// limit of a quota that is reached
+type QuotaError struct {
+	Quota string `json:"quota"`
+	Limit int    `json:"limit"`
+	Used  int    `json:"used"`
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded: %s is limited to %d", e.Quota, e.Limit)
+}
+
+func (e *QuotaError) Status() int {
+	return http.StatusForbidden
+}
+
+func (e *QuotaError) Details() map[string]interface{} {
+	return map[string]interface{}{"quota": e}
+}
+
+func IsQuota(name string) bool {
+	for _, v := range Quotas {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// limit of the quota for the user, its override or the default of its role,
+// false when there is no limit
+func (u *User) QuotaLimit(name string) (int, bool) {
+	limit, ok := u.Quotas[name]
+	if !ok {
+		limit, ok = roleQuotas[u.Role][name]
+	}
+	if !ok || limit == Unlimited {
+		return 0, false
+	}
+	return limit, true
+}
+
+// limit of every quota for the user, nil when there is no limit
+func (u *User) QuotaLimits() map[string]*int {
+	limits := map[string]*int{}
+	for _, name := range Quotas {
+		if limit, ok := u.QuotaLimit(name); ok {
+			limits[name] = &limit
+		} else {
+			limits[name] = nil
+		}
+	}
+	return limits
+}
+
+// check the user can create one more document of the quota, used is the count
+// of the documents it already has, a *QuotaError when the limit is reached
+func (u *User) CheckQuota(name string, used int) error {
+	return u.CheckQuotaN(name, used, 1)
+}
+
+// check the user can have n more documents of the quota, e.g. the courses restored
+// with a bootcamp
+func (u *User) CheckQuotaN(name string, used int, n int) error {
+	limit, ok := u.QuotaLimit(name)
+	if ok && used+n > limit {
+		return &QuotaError{Quota: name, Limit: limit, Used: used}
+	}
+	return nil
+}
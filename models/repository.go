@@ -0,0 +1,170 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// repositories of the models, built on the same store
+type Repos struct {
+	Bootcamps     BootcampRepo
+	Courses       CourseRepo
+	Reviews       ReviewRepo
+	Users         UserRepo
+	Sessions      SessionRepo
+	RolePolicies  RolePolicyRepo
+	Trash         TrashRepo
+	Transfers     TransferRepo
+	Organizations OrganizationRepo
+}
+
+func NewRepos(store Store) *Repos {
+	return &Repos{
+		Bootcamps:     NewBootcampRepo(store),
+		Courses:       NewCourseRepo(store),
+		Reviews:       NewReviewRepo(store),
+		Users:         NewUserRepo(store),
+		Sessions:      NewSessionRepo(store),
+		RolePolicies:  NewRolePolicyRepo(store),
+		Trash:         NewTrashRepo(store),
+		Transfers:     NewTransferRepo(store),
+		Organizations: NewOrganizationRepo(store),
+	}
+}
+
+// common methods of the repositories, on the collection of one model
+type repo struct {
+	model string
+	c     Collection
+	// store of the related models
+	store Store
+}
+
+func newRepo(store Store, model string) repo {
+	return repo{
+		model: model,
+		c:     store.C(model),
+		store: store,
+	}
+}
+
+func (r repo) Count(filter bson.M) (int, error) {
+	return r.c.Count(filter)
+}
+
+func (r repo) findId(id bson.ObjectId, doc mongodm.IDocumentBase) error {
+	return r.c.FindOne(bson.M{"_id": id}, doc)
+}
+
+// the document is kept and marked as deleted
+func (r repo) softDelete(doc mongodm.IDocumentBase) error {
+	doc.SetDeleted(true)
+	if d, ok := doc.(deletable); ok {
+		now := time.Now()
+		d.deletion().DeletedAt = &now
+	}
+	return r.c.Save(doc)
+}
+
+// average of a numeric field of the documents matching filter, 0 when there is none
+func (r repo) average(filter bson.M, field string) (float64, error) {
+	var res []bson.M
+	err := r.c.Aggregate([]bson.M{
+		{"$match": filter},
+		{"$group": bson.M{
+			"_id": nil,
+			"avg": bson.M{"$avg": "$" + field},
+		}},
+	}, &res)
+	if err != nil || len(res) == 0 {
+		return 0, err
+	}
+	return toFloat(res[0]["avg"]), nil
+}
+
+// find the documents of q into result, with the relations of q.joins in one aggregation
+func (r repo) find(q Query, result interface{}) error {
+	err := r.findJoined(q, result)
+	if err != nil {
+		return err
+	}
+	if q.search != "" {
+		highlight(reflect.ValueOf(result).Elem().Interface(), q.search, q.text)
+	}
+	return nil
+}
+
+func (r repo) findJoined(q Query, result interface{}) error {
+	if len(q.joins) == 0 {
+		return r.c.Find(q, result)
+	}
+
+	pipeline := []bson.M{
+		{"$match": q.Filter},
+	}
+	if len(q.Sort) > 0 {
+		pipeline = append(pipeline, bson.M{"$sort": sortDoc(q.Sort)})
+	}
+	if q.Skip > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": q.Skip})
+	}
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": q.Limit})
+	}
+	for _, j := range q.joins {
+		pipeline = append(pipeline, j.stage(r.store))
+	}
+	if q.Select != nil {
+		pipeline = append(pipeline, bson.M{"$project": q.Select})
+	}
+
+	var docs []bson.M
+	err := r.c.Aggregate(pipeline, &docs)
+	if err != nil {
+		return err
+	}
+	err = decodeAll(docs, result)
+	if err != nil {
+		return err
+	}
+
+	// the joined documents are not stored fields, set them after decoding
+	slice := reflect.ValueOf(result).Elem()
+	for i := 0; i < slice.Len(); i++ {
+		item := reflect.Indirect(slice.Index(i))
+		for _, j := range q.joins {
+			list, _ := docs[i][j.as].([]interface{})
+			joined := make([]interface{}, len(list))
+			for k, d := range list {
+				m, _ := d.(bson.M)
+				joined[k] = relatedDoc(m)
+			}
+			if field := fieldByBson(item, j.as); field.IsValid() {
+				field.Set(reflect.ValueOf(joined))
+			}
+		}
+	}
+	return nil
+}
+
+// sort of a pipeline from field names prefixed with "-" for descending order
+func sortDoc(sort []string) bson.D {
+	var d bson.D
+	for _, key := range sort {
+		// "$textScore:score" like mgo
+		if strings.HasPrefix(key, "$textScore:") {
+			d = append(d, bson.DocElem{Name: strings.TrimPrefix(key, "$textScore:"), Value: bson.M{"$meta": "textScore"}})
+			continue
+		}
+		order := 1
+		if strings.HasPrefix(key, "-") {
+			order = -1
+		}
+		d = append(d, bson.DocElem{Name: strings.TrimLeft(key, "+-"), Value: order})
+	}
+	return d
+}
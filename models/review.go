@@ -2,6 +2,7 @@ package models
 
 import (
 	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type Review struct {
@@ -11,6 +12,9 @@ type Review struct {
 	Rating               int         `json:"rating" bson:"rating"`
 	Bootcamp             interface{} `json:"bootcamp" bson:"bootcamp" model:"Bootcamp" relation:"11" autosave:"true" required:"true"`
 	User                 interface{} `json:"user" bson:"user" model:"User" relation:"11" autosave:"true" required:"true"`
+
+	// when and with what it was deleted, empty for the live documents
+	Deletion `json:",inline" bson:",inline"`
 }
 
 // override validate function to aviod check before save (will check explicitly)
@@ -57,3 +61,107 @@ func (rw *Review) validateBothCreateAndUpdate() []error {
 
 	return validationErrors
 }
+
+// fields of the reviews usable in the url query
+var reviewQueryFields = QueryFields{
+	Model: Review{},
+	Filter: map[string]FieldType{
+		"title":         StringField,
+		"text":          StringField,
+		"rating":        NumberField,
+		"bootcamp":      IdField,
+		"user":          IdField,
+		"createdAt":     DateField,
+		"updatedAt":     DateField,
+		"deletedAt":     DateField,
+		"deletionBatch": IdField,
+	},
+	Sort: []string{"title", "rating", "createdAt", "updatedAt", "deletedAt"},
+	Expand: map[string]Relation{
+		"bootcamp": bootcampRelation,
+		"user":     userRelation,
+	},
+}
+
+// reviews of the store
+type ReviewRepo interface {
+	// new review ready to be validated and saved
+	New() *Review
+	// find by id, the deleted reviews included
+	FindId(id bson.ObjectId) (*Review, error)
+	FindOne(filter bson.M) (*Review, error)
+	Find(q Query) ([]*Review, error)
+	Count(filter bson.M) (int, error)
+	// fields usable in the url query
+	QueryFields() QueryFields
+	// replace the relation ids of docs (a review or a slice of them) by the documents
+	// asked by the expand param of the url query
+	Expand(docs interface{}, urlQuery map[string][]string) error
+	Save(review *Review) error
+	SoftDelete(review *Review) error
+	// average rating of the bootcamp's reviews, used as the bootcamp averageRating
+	AverageRating(bootcampId bson.ObjectId) (int, error)
+}
+
+type reviewRepo struct {
+	repo
+}
+
+func NewReviewRepo(store Store) ReviewRepo {
+	return &reviewRepo{newRepo(store, "Review")}
+}
+
+func (r *reviewRepo) New() *Review {
+	review := &Review{}
+	r.c.Init(review)
+	return review
+}
+
+func (r *reviewRepo) FindId(id bson.ObjectId) (*Review, error) {
+	review := &Review{}
+	err := r.findId(id, review)
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+func (r *reviewRepo) FindOne(filter bson.M) (*Review, error) {
+	review := &Review{}
+	err := r.c.FindOne(filter, review)
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+func (r *reviewRepo) Find(q Query) ([]*Review, error) {
+	reviews := []*Review{}
+	err := r.find(q, &reviews)
+	return reviews, err
+}
+
+func (r *reviewRepo) Save(review *Review) error {
+	return r.save(review)
+}
+
+func (r *reviewRepo) SoftDelete(review *Review) error {
+	return r.softDelete(review)
+}
+
+func (r *reviewRepo) AverageRating(bootcampId bson.ObjectId) (int, error) {
+	query := bson.M{
+		"bootcamp": bootcampId,
+		"deleted":  false,
+	}
+	avg, err := r.average(query, "rating")
+	return int(avg), err
+}
+
+func (r *reviewRepo) QueryFields() QueryFields {
+	return reviewQueryFields
+}
+
+func (r *reviewRepo) Expand(docs interface{}, urlQuery map[string][]string) error {
+	return r.expand(docs, urlQuery, reviewQueryFields.Expand)
+}
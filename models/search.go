@@ -0,0 +1,218 @@
+package models
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// sort key of the text score, understood by mgo and the memory store
+const textScoreSort = "$textScore:score"
+
+// text index of the fields searched by the q param, the first one (e.g. the name) weighs
+// the most in the score
+func textIndex(fields []string) mgo.Index {
+	index := mgo.Index{
+		Weights: map[string]int{},
+	}
+	for i, f := range fields {
+		index.Key = append(index.Key, "$text:"+f)
+		index.Weights[f] = 1
+		if i == 0 {
+			index.Weights[f] = 3
+		}
+	}
+	return index
+}
+
+// words of a text search like MongoDB reads them: "-word" excludes the word and the
+// "quoted words" must be found together
+type search struct {
+	terms    []string
+	phrases  []string
+	excluded []string
+}
+
+func parseSearch(s string) search {
+	var se search
+	for i, part := range strings.Split(s, `"`) {
+		// odd parts are inside quotes
+		if i%2 == 1 {
+			if p := strings.TrimSpace(part); p != "" {
+				se.phrases = append(se.phrases, strings.ToLower(p))
+			}
+			continue
+		}
+		for _, w := range strings.Fields(strings.ToLower(part)) {
+			if strings.HasPrefix(w, "-") {
+				if w = strings.TrimLeft(w, "-"); w != "" {
+					se.excluded = append(se.excluded, w)
+				}
+				continue
+			}
+			se.terms = append(se.terms, w)
+		}
+	}
+	return se
+}
+
+// the words and phrases found in the results
+func (se search) words() []string {
+	return append(append([]string{}, se.phrases...), se.terms...)
+}
+
+// regex of a word or a phrase in a text, case-insensitive
+func wordPattern(w string) string {
+	return `(?i)\b` + regexp.QuoteMeta(w)
+}
+
+// length of the text around the first word found in a field
+const snippetLength = 80
+
+// set the highlights of docs (a slice of documents with a Highlights field): the part of
+// each text field around the first word of the search, the words marked with <em>
+func highlight(docs interface{}, s string, fields []string) {
+	se := parseSearch(s)
+	var res []*regexp.Regexp
+	for _, w := range se.words() {
+		res = append(res, regexp.MustCompile(wordPattern(w)))
+	}
+	if len(res) == 0 {
+		return
+	}
+
+	v := reflect.ValueOf(docs)
+	for i := 0; i < v.Len(); i++ {
+		item := reflect.Indirect(v.Index(i))
+		target := item.FieldByName("Highlights")
+		if !target.IsValid() {
+			return
+		}
+		highlights := map[string]string{}
+		for _, name := range fields {
+			field := fieldByBson(item, name)
+			if !field.IsValid() {
+				continue
+			}
+			var texts []string
+			switch t := field.Interface().(type) {
+			case string:
+				texts = []string{t}
+			case []string:
+				texts = t
+			}
+			for _, text := range texts {
+				if snippet, ok := snippetOf(text, res); ok {
+					highlights[name] = snippet
+					break
+				}
+			}
+		}
+		if len(highlights) > 0 {
+			target.Set(reflect.ValueOf(highlights))
+		}
+	}
+}
+
+// the text around the first match, false when no word is found
+func snippetOf(text string, res []*regexp.Regexp) (string, bool) {
+	start := -1
+	for _, re := range res {
+		if loc := re.FindStringIndex(text); loc != nil && (start < 0 || loc[0] < start) {
+			start = loc[0]
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+
+	// a window of the text around the match, cut between words
+	from := start - snippetLength/4
+	if from <= 0 {
+		from = 0
+	} else if i := strings.IndexByte(text[from:start], ' '); i >= 0 {
+		from += i + 1
+	} else {
+		from = start
+	}
+	to := from + snippetLength
+	if to >= len(text) {
+		to = len(text)
+	} else if i := strings.LastIndexByte(text[from:to], ' '); i > start-from {
+		to = from + i
+	} else {
+		for to < len(text) && !utf8.RuneStart(text[to]) {
+			to++
+		}
+	}
+	snippet := text[from:to]
+	for _, re := range res {
+		snippet = re.ReplaceAllStringFunc(snippet, func(w string) string {
+			return "<em>" + w + "</em>"
+		})
+	}
+	if from > 0 {
+		snippet = "..." + snippet
+	}
+	if to < len(text) {
+		snippet += "..."
+	}
+	return snippet, true
+}
+
+// filter of the documents matching a text search on fields, in place of $text:
+// any term, every phrase and none of the excluded words
+func textFilter(s string, fields []string) bson.M {
+	se := parseSearch(s)
+	anyField := func(w string) []interface{} {
+		var or []interface{}
+		for _, f := range fields {
+			or = append(or, bson.M{f: bson.M{"$regex": wordPattern(w)}})
+		}
+		return or
+	}
+
+	var and []interface{}
+	var terms []interface{}
+	for _, w := range se.terms {
+		terms = append(terms, anyField(w)...)
+	}
+	if len(terms) > 0 {
+		and = append(and, bson.M{"$or": terms})
+	}
+	for _, p := range se.phrases {
+		and = append(and, bson.M{"$or": anyField(p)})
+	}
+	for _, w := range se.excluded {
+		and = append(and, bson.M{"$nor": anyField(w)})
+	}
+	// like MongoDB, excluded words alone match nothing
+	if len(se.terms) == 0 && len(se.phrases) == 0 {
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"$and": and}
+}
+
+// relevance of a document for a text search: the words found in each field times the
+// weight of the field
+func textScore(doc bson.M, s string, weights map[string]int) float64 {
+	se := parseSearch(s)
+	score := 0.0
+	for field, weight := range weights {
+		for _, v := range candidates(lookup(doc, field)) {
+			text, ok := v.(string)
+			if !ok {
+				continue
+			}
+			for _, w := range se.words() {
+				n := len(regexp.MustCompile(wordPattern(w)).FindAllStringIndex(text, -1))
+				score += float64(n * weight)
+			}
+		}
+	}
+	return score
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"crypto/rand"
+	"devcamper/utils"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// refresh tokens of a session whose reuse is detected, the older ones are only invalid
+const maxPreviousHashes = 50
+
+// login session of a user, the refresh token is rotated on every use
+type Session struct {
+	mongodm.DocumentBase `json:",inline" bson:",inline"`
+	User                 interface{} `json:"user" bson:"user" model:"User" relation:"11" autosave:"true" required:"true"`
+	TokenHash            string      `json:"-" bson:"tokenHash"`
+	// hashes of the last refresh tokens replaced by rotation (see maxPreviousHashes), presenting one
+	// of them again means the token leaked
+	PreviousHashes []string  `json:"-" bson:"previousHashes"`
+	UserAgent      string    `json:"userAgent" bson:"userAgent"`
+	IP             string    `json:"ip" bson:"ip"`
+	LastUsedAt     time.Time `json:"lastUsedAt" bson:"lastUsedAt"`
+	ExpiredAt      time.Time `json:"expiredAt" bson:"expiredAt"`
+	Revoked        bool      `json:"revoked" bson:"revoked"`
+	RevokedAt      time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// override validate function to aviod check before save
+func (s *Session) Validate(values ...interface{}) (bool, []error) {
+	return true, nil
+}
+
+// check if the session can still be used
+func (s *Session) IsActive() bool {
+	return !s.Revoked && !s.Deleted && s.ExpiredAt.After(time.Now())
+}
+
+// generate a new refresh token, only its hash keyed with secret is kept
+func (s *Session) GenRefreshToken(secret string) string {
+	bs := make([]byte, 32)
+	io.ReadFull(rand.Reader, bs)
+	s.TokenHash = utils.HashToken(secret, bs)
+	s.LastUsedAt = time.Now()
+	return hex.EncodeToString(bs)
+}
+
+func (s *Session) Revoke() {
+	s.Revoked = true
+	s.RevokedAt = time.Now()
+}
+
+// hash of an opaque token (hex encoded), the same way as the reset password token
+func HashToken(secret string, token string) (string, error) {
+	bs, err := hex.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+	return utils.HashToken(secret, bs), nil
+}
+
+// sessions of the store
+type SessionRepo interface {
+	// new session ready to be saved
+	New() *Session
+	FindId(id bson.ObjectId) (*Session, error)
+	FindOne(filter bson.M) (*Session, error)
+	Find(q Query) ([]*Session, error)
+	Save(session *Session) error
+	// rotate the refresh token of the active session with the token hash
+	// the swap is atomic so a refresh token can be used only once
+	Rotate(tokenHash string, newHash string) (*Session, error)
+	// revoke every active session of the user except the one with exceptId (can be empty)
+	RevokeAll(userId bson.ObjectId, exceptId bson.ObjectId) error
+}
+
+type sessionRepo struct {
+	repo
+}
+
+func NewSessionRepo(store Store) SessionRepo {
+	return &sessionRepo{newRepo(store, "Session")}
+}
+
+func (r *sessionRepo) New() *Session {
+	session := &Session{}
+	r.c.Init(session)
+	return session
+}
+
+func (r *sessionRepo) FindId(id bson.ObjectId) (*Session, error) {
+	session := &Session{}
+	err := r.findId(id, session)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *sessionRepo) FindOne(filter bson.M) (*Session, error) {
+	session := &Session{}
+	err := r.c.FindOne(filter, session)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *sessionRepo) Find(q Query) ([]*Session, error) {
+	sessions := []*Session{}
+	err := r.c.Find(q, &sessions)
+	return sessions, err
+}
+
+func (r *sessionRepo) Save(session *Session) error {
+	return r.c.Save(session)
+}
+
+func (r *sessionRepo) Rotate(tokenHash string, newHash string) (*Session, error) {
+	session := &Session{}
+	update := bson.M{
+		"$set": bson.M{
+			"tokenHash":  newHash,
+			"lastUsedAt": time.Now(),
+		},
+		"$push": bson.M{
+			"previousHashes": bson.M{
+				"$each":  []string{tokenHash},
+				"$slice": -maxPreviousHashes,
+			},
+		},
+	}
+	query := bson.M{
+		"tokenHash": tokenHash,
+		"revoked":   false,
+		"deleted":   false,
+		"expiredAt": bson.M{
+			"$gt": time.Now(),
+		},
+	}
+	err := r.c.FindAndModify(query, update, false, session)
+	if err == ErrNotFound {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *sessionRepo) RevokeAll(userId bson.ObjectId, exceptId bson.ObjectId) error {
+	query := bson.M{
+		"user":    userId,
+		"revoked": false,
+	}
+	if exceptId.Valid() {
+		query["_id"] = bson.M{
+			"$ne": exceptId,
+		}
+	}
+	_, err := r.c.UpdateAll(query, bson.M{
+		"$set": bson.M{
+			"revoked":   true,
+			"revokedAt": time.Now(),
+		},
+	})
+	return err
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRotateKeepsLastHashes(t *testing.T) {
+	repo := NewSessionRepo(NewMemoryStore())
+	session := repo.New()
+	session.User = bson.NewObjectId()
+	session.TokenHash = "hash-0"
+	session.ExpiredAt = time.Now().Add(time.Hour)
+	if err := repo.Save(session); err != nil {
+		t.Fatal(err)
+	}
+
+	rotations := maxPreviousHashes + 10
+	for i := 1; i <= rotations; i++ {
+		if _, err := repo.Rotate(fmt.Sprintf("hash-%d", i-1), fmt.Sprintf("hash-%d", i)); err != nil {
+			t.Fatalf("rotation %d: %v", i, err)
+		}
+	}
+	session, err := repo.FindId(session.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.TokenHash != fmt.Sprintf("hash-%d", rotations) {
+		t.Errorf("token hash = %s", session.TokenHash)
+	}
+	if len(session.PreviousHashes) != maxPreviousHashes {
+		t.Fatalf("%d previous hashes, want %d", len(session.PreviousHashes), maxPreviousHashes)
+	}
+	first, last := session.PreviousHashes[0], session.PreviousHashes[maxPreviousHashes-1]
+	if first != fmt.Sprintf("hash-%d", rotations-maxPreviousHashes) || last != fmt.Sprintf("hash-%d", rotations-1) {
+		t.Errorf("previous hashes from %s to %s", first, last)
+	}
+
+	// a recent token is still detected as reused
+	if _, err := repo.FindOne(bson.M{"previousHashes": last}); err != nil {
+		t.Errorf("reused token not found: %v", err)
+	}
+	if _, err := repo.FindOne(bson.M{"previousHashes": "hash-0"}); err != ErrNotFound {
+		t.Errorf("oldest token = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"net/http"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// error of the store, it carries the response status (see utils.ErrorHandler)
+type StoreError struct {
+	message string
+	status  int
+}
+
+func (e *StoreError) Error() string {
+	return e.message
+}
+
+func (e *StoreError) Status() int {
+	return e.status
+}
+
+var (
+	ErrNotFound  = &StoreError{"not found resource", http.StatusBadRequest}
+	ErrDuplicate = &StoreError{"duplicate key", http.StatusBadRequest}
+)
+
+// query on a collection, the filter and the projection use the MongoDB syntax
+type Query struct {
+	Filter bson.M
+	// projection, e.g. {"name": 1}
+	Select bson.M
+	// field names, prefixed with "-" for descending order
+	Sort  []string
+	Skip  int
+	Limit int
+	// one to many relations joined by the repositories ($lookup), the stores ignore them
+	joins []join
+	// fields asked by the select param, Select is their projection in the store
+	projection *projection
+	// words of the text search on the fields text, highlighted in the documents found
+	search string
+	text   []string
+}
+
+// documents of one model
+// filters and updates use the MongoDB syntax so every backend understands the same queries
+type Collection interface {
+	// name of the collection in the pipelines, e.g. the from of $lookup
+	Name() string
+	// prepare a new document so it can be validated and updated (see mongodm.DocumentBase)
+	Init(doc mongodm.IDocumentBase)
+	// find the documents into result, a pointer to a slice of documents
+	Find(q Query, result interface{}) error
+	// find the first document, ErrNotFound when nothing matches
+	FindOne(filter bson.M, doc mongodm.IDocumentBase) error
+	Count(filter bson.M) (int, error)
+	// insert the document when it has no id, replace it otherwise
+	Save(doc mongodm.IDocumentBase) error
+	// update the first matching document atomically, doc receives the updated document
+	FindAndModify(filter bson.M, update bson.M, upsert bool, doc mongodm.IDocumentBase) error
+	UpdateAll(filter bson.M, update bson.M) (int, error)
+	RemoveAll(filter bson.M) (int, error)
+	// run an aggregation pipeline, result is a pointer to a slice
+	Aggregate(pipeline []bson.M, result interface{}) error
+	EnsureIndex(index mgo.Index) error
+}
+
+// collections of the registered models
+type Store interface {
+	// collection of the model, e.g. "Bootcamp"
+	C(model string) Collection
+}
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"crypto/rand"
+	"devcamper/utils"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// status of a transfer
+const (
+	TransferPending = "pending"
+	// accepted, the bootcamp and its courses are being reassigned
+	TransferAccepting = "accepting"
+	TransferCompleted = "completed"
+	TransferDeclined  = "declined"
+	TransferCancelled = "cancelled"
+	TransferExpired   = "expired"
+	// the owner of the bootcamp changed before it was accepted
+	TransferFailed = "failed"
+)
+
+// steps of a transfer recorded in its history
+const (
+	TransferStarted = "started"
+	TransferEmailed = "emailed"
+	// the email could not be sent, the transfer can be cancelled and started again
+	TransferEmailFailed = "emailFailed"
+	TransferAccepted    = "accepted"
+	TransferReassigned  = "reassigned"
+)
+
+var (
+	ErrTransferPending = &StoreError{"a transfer of the bootcamp is pending, cancel it first", http.StatusConflict}
+	ErrTransferClosed  = &StoreError{"the transfer is not pending", http.StatusConflict}
+	ErrTransferOwner   = &StoreError{"the owner of the bootcamp changed since the transfer started", http.StatusConflict}
+)
+
+// lifetime of the token sent to the new owner
+const TransferExpire = 72 * time.Hour
+
+// transfer of a bootcamp and its courses to another publisher, who accepts it with the emailed token
+type Transfer struct {
+	mongodm.DocumentBase `json:",inline" bson:",inline"`
+	Bootcamp             bson.ObjectId `json:"bootcamp" bson:"bootcamp"`
+	// owner when the transfer started and new owner
+	From bson.ObjectId `json:"from" bson:"from"`
+	To   bson.ObjectId `json:"to" bson:"to"`
+	// the owner or an admin
+	StartedBy bson.ObjectId `json:"startedBy" bson:"startedBy"`
+	Status    string        `json:"status" bson:"status"`
+	TokenHash string        `json:"-" bson:"tokenHash"`
+	ExpiredAt time.Time     `json:"expiredAt" bson:"expiredAt"`
+	// audit trail, every step in order
+	History []TransferEvent `json:"history" bson:"history"`
+}
+
+// step of a transfer, by the user who did it (empty when done by the app)
+type TransferEvent struct {
+	Action string        `json:"action" bson:"action"`
+	User   bson.ObjectId `json:"user,omitempty" bson:"user,omitempty"`
+	At     time.Time     `json:"at" bson:"at"`
+	// e.g. why the step failed
+	Note string `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+// override validate function to aviod check before save
+func (t *Transfer) Validate(values ...interface{}) (bool, []error) {
+	return true, nil
+}
+
+// generate the token of the new owner, only its hash keyed with secret is kept
+func (t *Transfer) GenToken(secret string) string {
+	bs := make([]byte, 20)
+	io.ReadFull(rand.Reader, bs)
+	t.TokenHash = utils.HashToken(secret, bs)
+	t.ExpiredAt = time.Now().Add(TransferExpire)
+	return hex.EncodeToString(bs)
+}
+
+// transfers of the store
+type TransferRepo interface {
+	// new transfer ready to be started
+	New() *Transfer
+	FindId(id bson.ObjectId) (*Transfer, error)
+	// transfers of the bootcamp, the newest first
+	FindByBootcamp(bootcampId bson.ObjectId) ([]*Transfer, error)
+	// save the new transfer as pending, ErrTransferPending when the bootcamp has one
+	Start(transfer *Transfer) error
+	// add a step to the history
+	Record(transfer *Transfer, action string, user bson.ObjectId, note string) error
+	// reassign the bootcamp and its courses to the new owner with the hash of the token,
+	// an accepting transfer that was interrupted is resumed, ErrNotFound for a wrong or
+	// expired token, ErrTransferOwner when the bootcamp changed owner
+	Accept(transfer *Transfer, tokenHash string, user bson.ObjectId) error
+	// end a pending transfer with status, ErrTransferClosed when it is not pending
+	Close(transfer *Transfer, status string, user bson.ObjectId, note string) error
+}
+
+type transferRepo struct {
+	repo
+}
+
+func NewTransferRepo(store Store) TransferRepo {
+	return &transferRepo{newRepo(store, "Transfer")}
+}
+
+func (r *transferRepo) New() *Transfer {
+	transfer := &Transfer{}
+	r.c.Init(transfer)
+	return transfer
+}
+
+func (r *transferRepo) FindId(id bson.ObjectId) (*Transfer, error) {
+	transfer := &Transfer{}
+	err := r.findId(id, transfer)
+	if err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+func (r *transferRepo) FindByBootcamp(bootcampId bson.ObjectId) ([]*Transfer, error) {
+	transfers := []*Transfer{}
+	err := r.c.Find(Query{
+		Filter: bson.M{"bootcamp": bootcampId, "deleted": false},
+		Sort:   []string{"-createdAt", "-_id"},
+	}, &transfers)
+	return transfers, err
+}
+
+func (r *transferRepo) Start(transfer *Transfer) error {
+	// the expired transfers do not block a new one
+	filter := openTransfer()
+	filter["bootcamp"] = transfer.Bootcamp
+	n, err := r.c.Count(filter)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrTransferPending
+	}
+	transfer.Status = TransferPending
+	transfer.History = []TransferEvent{{Action: TransferStarted, User: transfer.StartedBy, At: time.Now()}}
+	return r.c.Save(transfer)
+}
+
+func (r *transferRepo) Record(transfer *Transfer, action string, user bson.ObjectId, note string) error {
+	return r.c.FindAndModify(bson.M{"_id": transfer.Id}, bson.M{
+		"$push": bson.M{"history": TransferEvent{Action: action, User: user, At: time.Now(), Note: note}},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}, false, transfer)
+}
+
+func (r *transferRepo) Accept(transfer *Transfer, tokenHash string, user bson.ObjectId) error {
+	// claim the transfer, only one request can accept it
+	filter := openTransfer()
+	filter["_id"] = transfer.Id
+	filter["tokenHash"] = tokenHash
+	err := r.c.FindAndModify(filter, bson.M{
+		"$set":  bson.M{"status": TransferAccepting, "updatedAt": time.Now()},
+		"$push": bson.M{"history": TransferEvent{Action: TransferAccepted, User: user, At: time.Now()}},
+	}, false, transfer)
+	if err != nil {
+		return err
+	}
+
+	// the bootcamp first, only from the owner the transfer started with (or to the new owner
+	// when resumed), then its courses
+	n, err := r.store.C("Bootcamp").UpdateAll(bson.M{
+		"_id":     transfer.Bootcamp,
+		"user":    bson.M{"$in": []bson.ObjectId{transfer.From, transfer.To}},
+		"deleted": false,
+	}, bson.M{
+		"$set": bson.M{"user": transfer.To, "updatedAt": time.Now()},
+		// the bootcamp leaves its organization with its previous owner
+		"$unset": bson.M{"organization": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		err = r.c.FindAndModify(bson.M{"_id": transfer.Id}, bson.M{
+			"$set":  bson.M{"status": TransferFailed, "updatedAt": time.Now()},
+			"$push": bson.M{"history": TransferEvent{Action: TransferFailed, At: time.Now(), Note: ErrTransferOwner.Error()}},
+		}, false, transfer)
+		if err != nil {
+			return err
+		}
+		return ErrTransferOwner
+	}
+	// the deleted courses too, they are restored with the bootcamp
+	_, err = r.store.C("Course").UpdateAll(bson.M{
+		"bootcamp": transfer.Bootcamp,
+		"user":     bson.M{"$ne": transfer.To},
+	}, bson.M{
+		"$set": bson.M{"user": transfer.To, "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.c.FindAndModify(bson.M{"_id": transfer.Id}, bson.M{
+		"$set": bson.M{"status": TransferCompleted, "updatedAt": time.Now()},
+		"$unset": bson.M{
+			"tokenHash": "",
+		},
+		"$push": bson.M{"history": TransferEvent{Action: TransferReassigned, At: time.Now()}},
+	}, false, transfer)
+}
+
+func (r *transferRepo) Close(transfer *Transfer, status string, user bson.ObjectId, note string) error {
+	err := r.c.FindAndModify(bson.M{
+		"_id":    transfer.Id,
+		"status": TransferPending,
+	}, bson.M{
+		"$set":  bson.M{"status": status, "updatedAt": time.Now()},
+		"$push": bson.M{"history": TransferEvent{Action: status, User: user, At: time.Now(), Note: note}},
+	}, false, transfer)
+	if err == ErrNotFound {
+		return ErrTransferClosed
+	}
+	return err
+}
+
+// filter of the transfers that can still be accepted
+func openTransfer() bson.M {
+	return bson.M{
+		"$or": []interface{}{
+			bson.M{"status": TransferPending, "expiredAt": bson.M{"$gt": time.Now()}},
+			bson.M{"status": TransferAccepting},
+		},
+		"deleted": false,
+	}
+}
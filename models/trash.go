@@ -0,0 +1,199 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// when and in which batch a document was soft-deleted, empty for the live documents
+type Deletion struct {
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	// set when the document was deleted with another one, see DeletionBatch
+	DeletionBatch bson.ObjectId `json:"deletionBatch,omitempty" bson:"deletionBatch,omitempty"`
+}
+
+type deletable interface {
+	deletion() *Deletion
+}
+
+func (d *Deletion) deletion() *Deletion {
+	return d
+}
+
+var ErrParentDeleted = &StoreError{"the bootcamp is deleted, restore it first", http.StatusConflict}
+
+// collections of the trash and their models
+var TrashCollections = []string{"bootcamps", "courses", "reviews", "users"}
+
+var trashModels = map[string]string{
+	"bootcamps": "Bootcamp",
+	"courses":   "Course",
+	"reviews":   "Review",
+	"users":     "User",
+}
+
+// documents deleted with the documents of a model
+var cascades = map[string][]cascade{
+	"Bootcamp": bootcampCascade,
+}
+
+// documents restored
+type RestoreReport struct {
+	Documents map[string][]bson.ObjectId `json:"documents"`
+	Counts    map[string]int             `json:"counts"`
+}
+
+// soft-deleted documents of every model
+type TrashRepo interface {
+	// restore the deleted document of a collection (see TrashCollections) with the documents
+	// deleted with it, ErrParentDeleted when it was deleted with a document still deleted
+	Restore(collection string, id bson.ObjectId) (*RestoreReport, error)
+	// hard-delete the documents deleted before the time, the count by collection
+	Purge(before time.Time) (map[string]int, error)
+}
+
+type trashRepo struct {
+	store Store
+}
+
+func NewTrashRepo(store Store) TrashRepo {
+	return &trashRepo{
+		store: store,
+	}
+}
+
+func (r *trashRepo) Restore(collection string, id bson.ObjectId) (*RestoreReport, error) {
+	model, ok := trashModels[collection]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var docs []bson.M
+	err := r.store.C(model).Find(Query{
+		Filter: bson.M{
+			"_id":     id,
+			"deleted": true,
+		},
+	}, &docs)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, ErrNotFound
+	}
+	doc := docs[0]
+
+	// a document does not come back without its parent
+	for parent, children := range cascades {
+		for _, child := range children {
+			parentId, ok := doc[child.field].(bson.ObjectId)
+			if child.model != model || !ok {
+				continue
+			}
+			n, err := r.store.C(parent).Count(bson.M{
+				"_id":     parentId,
+				"deleted": true,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				return nil, ErrParentDeleted
+			}
+		}
+	}
+
+	report := &RestoreReport{
+		Documents: map[string][]bson.ObjectId{collection: {id}},
+		Counts:    map[string]int{collection: 1},
+	}
+	// the children of the same batch first, a failed restore can be run again
+	batch, _ := doc["deletionBatch"].(bson.ObjectId)
+	if batch != "" {
+		for _, child := range cascades[model] {
+			ids, err := r.restore(child.model, bson.M{"deletionBatch": batch})
+			if err != nil {
+				return nil, err
+			}
+			report.Documents[child.key] = ids
+			report.Counts[child.key] = len(ids)
+		}
+	}
+	_, err = r.restore(model, bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+	if batch != "" && len(cascades[model]) > 0 {
+		_, err = r.store.C("DeletionBatch").RemoveAll(bson.M{"_id": batch})
+	}
+	return report, err
+}
+
+// restore the deleted documents of model matching filter, their ids
+func (r *trashRepo) restore(model string, filter bson.M) ([]bson.ObjectId, error) {
+	c := r.store.C(model)
+	filter["deleted"] = true
+	var docs []bson.M
+	err := c.Find(Query{Filter: filter, Select: bson.M{"_id": 1}}, &docs)
+	if err != nil {
+		return nil, err
+	}
+	ids := []bson.ObjectId{}
+	for _, d := range docs {
+		ids = append(ids, d["_id"].(bson.ObjectId))
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	_, err = c.UpdateAll(bson.M{
+		"_id":     bson.M{"$in": ids},
+		"deleted": true,
+	}, bson.M{
+		"$set": bson.M{
+			"deleted":   false,
+			"updatedAt": time.Now(),
+		},
+		"$unset": bson.M{
+			"deletedAt":     "",
+			"deletionBatch": "",
+		},
+	})
+	return ids, err
+}
+
+func (r *trashRepo) Purge(before time.Time) (map[string]int, error) {
+	filter := bson.M{
+		"deleted": true,
+		"$or": []interface{}{
+			bson.M{"deletedAt": bson.M{"$lt": before}},
+			// deleted before the time of the deletion was kept
+			bson.M{
+				"deletedAt": bson.M{"$exists": false},
+				"updatedAt": bson.M{"$lt": before},
+			},
+		},
+	}
+	purged := map[string]int{}
+	for _, collection := range TrashCollections {
+		n, err := r.store.C(trashModels[collection]).RemoveAll(filter)
+		if err != nil {
+			return purged, err
+		}
+		purged[collection] = n
+	}
+	// the batches of the purged documents
+	_, err := r.store.C("DeletionBatch").RemoveAll(bson.M{
+		"createdAt": bson.M{"$lt": before},
+	})
+	return purged, err
+}
+
+// save the document, a live document keeps no deletion
+func (r repo) save(doc mongodm.IDocumentBase) error {
+	if d, ok := doc.(deletable); ok && !doc.IsDeleted() {
+		*d.deletion() = Deletion{}
+	}
+	return r.c.Save(doc)
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"devcamper/utils"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/zebresel-com/mongodm"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var ErrInvalidTwoFactorCode = errors.New("invalid two factor code")
+
+// number of recovery codes given when 2FA is enabled
+const RecoveryCodeCount = 10
+
+// TOTP two factor authentication state of a user
+type TwoFactor struct {
+	Enabled bool   `json:"enabled" bson:"enabled"`
+	Secret  string `json:"-" bson:"secret,omitempty"`
+	// secret waiting for the first code, it replaces Secret once confirmed
+	PendingSecret string `json:"-" bson:"pendingSecret,omitempty"`
+	// hashes of the unused recovery codes
+	RecoveryCodes []string `json:"-" bson:"recoveryCodes,omitempty"`
+	// last accepted TOTP step, a code is accepted only once
+	LastStep int64 `json:"-" bson:"lastStep,omitempty"`
+}
+
+// start the enrollment with a new secret, 2FA is enabled after ConfirmTwoFactor
+func (u *User) SetupTwoFactor() (string, error) {
+	secret, err := utils.GenTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	u.TwoFactor.PendingSecret = secret
+	return secret, nil
+}
+
+// enable 2FA when the code matches the pending secret, return the recovery codes in plain text
+// the recovery codes are hashed with key
+func (u *User) ConfirmTwoFactor(key string, code string) ([]string, error) {
+	if len(u.TwoFactor.PendingSecret) == 0 {
+		return nil, errors.New("please setup two factor authentication first")
+	}
+	step, ok := utils.ValidateTOTP(u.TwoFactor.PendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	u.TwoFactor.Enabled = true
+	u.TwoFactor.Secret = u.TwoFactor.PendingSecret
+	u.TwoFactor.PendingSecret = ""
+	u.TwoFactor.LastStep = step
+	return u.GenRecoveryCodes(key), nil
+}
+
+func (u *User) DisableTwoFactor() {
+	u.TwoFactor = TwoFactor{}
+}
+
+// replace the recovery codes, only their hashes keyed with key are kept
+func (u *User) GenRecoveryCodes(key string) []string {
+	codes := make([]string, RecoveryCodeCount)
+	u.TwoFactor.RecoveryCodes = make([]string, RecoveryCodeCount)
+	for i := range codes {
+		bs := make([]byte, 5)
+		io.ReadFull(rand.Reader, bs)
+		x := hex.EncodeToString(bs)
+		codes[i] = x[:5] + "-" + x[5:]
+		u.TwoFactor.RecoveryCodes[i] = utils.HashToken(key, bs)
+	}
+	return codes
+}
+
+// check the TOTP code, a code is refused when its step was already used
+func (u *User) VerifyTwoFactorCode(code string) bool {
+	if !u.TwoFactor.Enabled {
+		return false
+	}
+	step, ok := utils.ValidateTOTP(u.TwoFactor.Secret, code, time.Now())
+	if !ok || step <= u.TwoFactor.LastStep {
+		return false
+	}
+	u.TwoFactor.LastStep = step
+	return true
+}
+
+// check the recovery code and remove it so it can be used only once
+func (u *User) UseRecoveryCode(key string, code string) bool {
+	hash, err := HashToken(key, strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if err != nil {
+		return false
+	}
+	for i, v := range u.TwoFactor.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(v), []byte(hash)) == 1 {
+			u.TwoFactor.RecoveryCodes = append(u.TwoFactor.RecoveryCodes[:i], u.TwoFactor.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 2FA policy of a role, set by an admin
+type RolePolicy struct {
+	mongodm.DocumentBase `json:",inline" bson:",inline"`
+	Role                 string `json:"role" bson:"role"`
+	RequireTwoFactor     bool   `json:"requireTwoFactor" bson:"requireTwoFactor"`
+}
+
+// 2FA policies of the roles
+type RolePolicyRepo interface {
+	// check if users of the role must enable 2FA
+	IsTwoFactorRequired(role string) (bool, error)
+	// create or update the policy of the role
+	SetTwoFactorRequired(role string, required bool) (*RolePolicy, error)
+}
+
+type rolePolicyRepo struct {
+	repo
+}
+
+func NewRolePolicyRepo(store Store) RolePolicyRepo {
+	return &rolePolicyRepo{newRepo(store, "RolePolicy")}
+}
+
+func (r *rolePolicyRepo) IsTwoFactorRequired(role string) (bool, error) {
+	policy := &RolePolicy{}
+	err := r.c.FindOne(bson.M{"role": role, "deleted": false}, policy)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return policy.RequireTwoFactor, nil
+}
+
+func (r *rolePolicyRepo) SetTwoFactorRequired(role string, required bool) (*RolePolicy, error) {
+	policy := &RolePolicy{}
+	update := bson.M{
+		"$set": bson.M{
+			"requireTwoFactor": required,
+			"updatedAt":        time.Now(),
+		},
+		"$setOnInsert": bson.M{
+			"createdAt": time.Now(),
+			"deleted":   false,
+		},
+	}
+	err := r.c.FindAndModify(bson.M{"role": role}, update, true, policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"devcamper/utils"
+	"testing"
+	"time"
+)
+
+const testKey = "secret"
+
+// user with 2FA enabled on a new secret
+func twoFactorUser(t *testing.T) *User {
+	u := &User{}
+	secret, err := u.SetupTwoFactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now())-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.ConfirmTwoFactor(testKey, code); err != nil {
+		t.Fatalf("ConfirmTwoFactor: %v", err)
+	}
+	return u
+}
+
+func TestConfirmTwoFactor(t *testing.T) {
+	u := &User{}
+	if _, err := u.ConfirmTwoFactor(testKey, "000000"); err == nil {
+		t.Error("confirmed without setup")
+	}
+	if _, err := u.SetupTwoFactor(); err != nil {
+		t.Fatal(err)
+	}
+	code, _ := utils.TOTPCode(u.TwoFactor.PendingSecret, utils.TOTPStep(time.Now())+5)
+	if _, err := u.ConfirmTwoFactor(testKey, code); err != ErrInvalidTwoFactorCode {
+		t.Errorf("ConfirmTwoFactor with a wrong code = %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+	if u.TwoFactor.Enabled {
+		t.Error("enabled with a wrong code")
+	}
+
+	u = twoFactorUser(t)
+	if !u.TwoFactor.Enabled || u.TwoFactor.Secret == "" || u.TwoFactor.PendingSecret != "" {
+		t.Errorf("unexpected state after confirm: %+v", u.TwoFactor)
+	}
+	if len(u.TwoFactor.RecoveryCodes) != RecoveryCodeCount {
+		t.Errorf("%d recovery codes, want %d", len(u.TwoFactor.RecoveryCodes), RecoveryCodeCount)
+	}
+}
+
+func TestVerifyTwoFactorCodeReplay(t *testing.T) {
+	u := twoFactorUser(t)
+	now := utils.TOTPStep(time.Now())
+	code := func(step int64) string {
+		c, err := utils.TOTPCode(u.TwoFactor.Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// the confirm code of the previous step is used
+	if u.VerifyTwoFactorCode(code(now - 1)) {
+		t.Error("accepted the code used to confirm")
+	}
+	if !u.VerifyTwoFactorCode(code(now)) {
+		t.Fatal("refused the code of the current step")
+	}
+	if u.VerifyTwoFactorCode(code(now)) {
+		t.Error("accepted the same code twice")
+	}
+	if u.TwoFactor.LastStep != now {
+		t.Errorf("LastStep = %d, want %d", u.TwoFactor.LastStep, now)
+	}
+	if !u.VerifyTwoFactorCode(code(now + 1)) {
+		t.Error("refused the code of the next step")
+	}
+	if u.VerifyTwoFactorCode(code(now)) {
+		t.Error("accepted a code older than the last one")
+	}
+
+	u.DisableTwoFactor()
+	if u.VerifyTwoFactorCode(code(now + 1)) {
+		t.Error("accepted a code with 2FA disabled")
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	u := &User{}
+	codes := u.GenRecoveryCodes(testKey)
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("%d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	if u.UseRecoveryCode("other key", codes[0]) {
+		t.Error("accepted a code hashed with another key")
+	}
+	if u.UseRecoveryCode(testKey, "zzzzz-zzzzz") {
+		t.Error("accepted an invalid code")
+	}
+	if !u.UseRecoveryCode(testKey, codes[0]) {
+		t.Fatal("refused a recovery code")
+	}
+	if u.UseRecoveryCode(testKey, codes[0]) {
+		t.Error("accepted a recovery code twice")
+	}
+	// with spaces and without the dash
+	if !u.UseRecoveryCode(testKey, " "+codes[1][:5]+codes[1][6:]+" ") {
+		t.Error("refused a recovery code without dash")
+	}
+	if len(u.TwoFactor.RecoveryCodes) != RecoveryCodeCount-2 {
+		t.Errorf("%d codes left, want %d", len(u.TwoFactor.RecoveryCodes), RecoveryCodeCount-2)
+	}
+
+	// new codes replace the old ones
+	old := codes[2]
+	u.GenRecoveryCodes(testKey)
+	if u.UseRecoveryCode(testKey, old) {
+		t.Error("accepted a replaced recovery code")
+	}
+}
@@ -1,19 +1,18 @@
 package models
 
 import (
-	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha256"
+	"devcamper/utils"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/zebresel-com/mongodm"
 	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type User struct {
@@ -25,6 +24,15 @@ type User struct {
 	PasswordHash         string    `json:"-" bson:"password"`
 	ResetPasswordToken   string    `json:"-" bson:"resetPasswordToken,omitempty"`
 	ResetPasswordExpired time.Time `json:"-" bson:"resetPasswordExpired,omitempty"`
+	EmailVerified        bool      `json:"emailVerified" bson:"emailVerified"`
+	VerifyEmailToken     string    `json:"-" bson:"verifyEmailToken,omitempty"`
+	VerifyEmailExpired   time.Time `json:"-" bson:"verifyEmailExpired,omitempty"`
+	TwoFactor            TwoFactor `json:"twoFactor" bson:"twoFactor"`
+
+	// overrides of the quotas of the role set by an admin, see quota.go
+	Quotas map[string]int `json:"quotas,omitempty" bson:"quotas,omitempty"`
+	// when it was deleted, empty for the live users
+	Deletion `json:",inline" bson:",inline"`
 }
 
 // override validate function to aviod check before save (will check explicitly)
@@ -63,22 +71,18 @@ func (u *User) validateBothCreateAndUpdate() []error {
 	var validationErrors []error
 
 	if len(u.Role) == 0 {
-		u.Role = "user"
+		u.Role = RoleUser
 	} else {
 		// check if role in category
-		roles := []string{
-			"user",
-			"publisher",
-		}
 		valid := false
-		for _, v := range roles {
+		for _, v := range Roles {
 			if v == u.Role {
 				valid = true
 				break
 			}
 		}
 		if !valid {
-			u.AppendError(&validationErrors, fmt.Sprintf("Please select minimum skill in [ %s ]", strings.Join(roles, ", ")))
+			u.AppendError(&validationErrors, fmt.Sprintf("Please select role in [ %s ]", strings.Join(Roles, ", ")))
 		}
 	}
 
@@ -105,17 +109,25 @@ func (u *User) MatchPassword(pwdRaw string) bool {
 	return err == nil
 }
 
-func (u *User) GenResetPwdToken() string {
+// generate the reset password token, only its hash keyed with secret is kept
+func (u *User) GenResetPwdToken(secret string) string {
 	bs := make([]byte, 20)
 	io.ReadFull(rand.Reader, bs)
-	h := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
-	h.Write(bs)
-	u.ResetPasswordToken = fmt.Sprintf("%x", h.Sum(nil))
+	u.ResetPasswordToken = utils.HashToken(secret, bs)
 	u.ResetPasswordExpired = time.Now().Add(time.Minute * time.Duration(10))
 
 	return fmt.Sprintf("%x", bs)
 }
 
+func (u *User) GenVerifyEmailToken(secret string) string {
+	bs := make([]byte, 20)
+	io.ReadFull(rand.Reader, bs)
+	u.VerifyEmailToken = utils.HashToken(secret, bs)
+	u.VerifyEmailExpired = time.Now().Add(time.Hour * time.Duration(24))
+
+	return fmt.Sprintf("%x", bs)
+}
+
 func (u *User) IsUserInRoles(roles ...string) bool {
 	for _, v := range roles {
 		if u.Role == v {
@@ -124,3 +136,90 @@ func (u *User) IsUserInRoles(roles ...string) bool {
 	}
 	return false
 }
+
+// users expanded in other documents, only their public fields
+var userRelation = Relation{
+	Model:  "User",
+	Fields: []string{"name"},
+}
+
+// fields of the users usable in the url query, never the secrets
+var userQueryFields = QueryFields{
+	Model: User{},
+	Filter: map[string]FieldType{
+		"name":              StringField,
+		"email":             StringField,
+		"role":              StringField,
+		"emailVerified":     BoolField,
+		"twoFactor.enabled": BoolField,
+		"createdAt":         DateField,
+		"updatedAt":         DateField,
+		"deletedAt":         DateField,
+	},
+	Sort: []string{"name", "email", "role", "createdAt", "updatedAt", "deletedAt"},
+}
+
+// users of the store
+type UserRepo interface {
+	// new user ready to be validated and saved
+	New() *User
+	// find by id, the deleted users included
+	FindId(id bson.ObjectId) (*User, error)
+	FindOne(filter bson.M) (*User, error)
+	Find(q Query) ([]*User, error)
+	Count(filter bson.M) (int, error)
+	// fields usable in the url query
+	QueryFields() QueryFields
+	Save(user *User) error
+	SoftDelete(user *User) error
+}
+
+type userRepo struct {
+	repo
+}
+
+func NewUserRepo(store Store) UserRepo {
+	return &userRepo{newRepo(store, "User")}
+}
+
+func (r *userRepo) New() *User {
+	user := &User{}
+	r.c.Init(user)
+	return user
+}
+
+func (r *userRepo) FindId(id bson.ObjectId) (*User, error) {
+	user := &User{}
+	err := r.findId(id, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *userRepo) FindOne(filter bson.M) (*User, error) {
+	user := &User{}
+	err := r.c.FindOne(filter, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *userRepo) Find(q Query) ([]*User, error) {
+	users := []*User{}
+	err := r.find(q, &users)
+	return users, err
+}
+
+func (r *userRepo) Save(user *User) error {
+	return r.save(user)
+}
+
+func (r *userRepo) SoftDelete(user *User) error {
+	return r.softDelete(user)
+}
+
+func (r *userRepo) QueryFields() QueryFields {
+	return userQueryFields
+}
@@ -3,80 +3,272 @@ package main
 import (
 	"devcamper/config"
 	"devcamper/controllers"
+	"devcamper/middleware"
 	"devcamper/models"
+	"devcamper/utils"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/zebresel-com/mongodm"
 )
 
 func main() {
-	// connect to DB
-	conn := config.ConnDB()
+	// load settings, the app does not start with an invalid config
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	// mount models to DB
-	conn.Register(&models.Bootcamp{}, "bootcamps")
-	conn.Register(&models.Course{}, "courses")
-	conn.Register(&models.User{}, "users")
-	conn.Register(&models.Review{}, "reviews")
+	// documents are kept in DB unless STORE=memory
+	var conn *mongodm.Connection
+	var store models.Store
+	if cfg.Store == "memory" {
+		store = models.NewMemoryStore()
+	} else {
+		// connect to DB
+		conn = config.ConnDB(cfg)
 
+		// mount models to DB
+		models.RegisterModels(conn)
+
+		store = models.NewMongoStore(conn)
+	}
+	repos := models.NewRepos(store)
+
+	// failed login attempts, kept in DB unless LOGIN_ATTEMPT_STORE=memory or STORE=memory
+	var attempts utils.AttemptTracker
+	if cfg.LoginAttemptStore == "memory" || conn == nil {
+		attempts = utils.NewMemoryAttemptTracker(utils.DefaultAttemptPolicy)
+	} else {
+		attempts = models.NewMongoAttemptTracker(conn, utils.DefaultAttemptPolicy)
+	}
+
+	// offline zipcode lookup for radius search
+	zipcodes, err := utils.LoadGazetteer("./config/zipcodes.csv")
+	if err != nil {
+		log.Fatalf("Gazetteer error: %v\n", err)
+	}
+
+	// geocoder for bootcamp address, results are cached in DB
+	var geocoder utils.Geocoder
+	if cfg.GeocoderProvider == "mapquest" {
+		geocoder = utils.NewMapQuest(cfg.GeocoderURL, cfg.GeocoderAPIKey)
+	} else {
+		geocoder, err = utils.LoadOfflineGeocoder("./config/geocodes.json", zipcodes)
+		if err != nil {
+			log.Fatalf("Geocoder error: %v\n", err)
+		}
+	}
+	geocoder = models.NewCachedGeocoder(store, geocoder)
+
+	// storage for uploaded files
+	var storage utils.Storage
+	if cfg.StorageDriver == "s3" {
+		storage, err = utils.NewS3Storage(
+			cfg.S3.Endpoint,
+			cfg.S3.Region,
+			cfg.S3.Bucket,
+			cfg.S3.AccessKey,
+			cfg.S3.SecretKey,
+			cfg.S3.PublicURL,
+		)
+	} else {
+		// public directory is served as static files
+		storage, err = utils.NewLocalStorage(filepath.Join("public", "uploads"), "/uploads")
+	}
+	if err != nil {
+		log.Fatalf("Storage error: %v\n", err)
+	}
+
+	router, jobs := newRouter(cfg, repos, services{
+		zipcodes: zipcodes,
+		geocoder: geocoder,
+		storage:  storage,
+		attempts: attempts,
+	})
+
+	// promote the first admin
+	if email := cfg.AdminEmail; email != "" {
+		if ok, err := models.BootstrapAdmin(repos.Users, email); ok {
+			fmt.Printf("Promoted %s to admin\n", email)
+		} else if err == models.ErrNotFound {
+			log.Printf("Cannot promote %s to admin: no user with this email\n", email)
+		} else if err != nil {
+			log.Printf("Cannot promote %s to admin: %v\n", email, err)
+		}
+	}
+
+	// retry the bootcamps saved while the geocoder was unavailable
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			jobs.bootcamps.RetryPendingGeocodes()
+		}
+	}()
+
+	// unlock the accounts whose lockout is over
+	go func() {
+		for range time.Tick(time.Minute) {
+			jobs.users.ReleaseLockouts()
+		}
+	}()
+
+	// hard-delete the documents deleted for longer than the retention
+	go func() {
+		for range time.Tick(time.Hour) {
+			purged, err := repos.Trash.Purge(time.Now().Add(-cfg.TrashRetention))
+			if err != nil {
+				log.Printf("purge trash: %v\n", err)
+			}
+			for collection, n := range purged {
+				if n > 0 {
+					log.Printf("purged %d deleted %s\n", n, collection)
+				}
+			}
+		}
+	}()
+
+	fmt.Printf("Listening on port %d\n", cfg.Port)
+	log.Fatalln(http.ListenAndServe(cfg.Addr(), router))
+}
+
+// dependencies of the controllers built by main from the config, the tests give their own
+type services struct {
+	zipcodes utils.ZipcodeLookup
+	geocoder utils.Geocoder
+	storage  utils.Storage
+	attempts utils.AttemptTracker
+}
+
+// controllers with work to run in the background
+type jobs struct {
+	bootcamps *controllers.Bootcamp
+	users     *controllers.User
+}
+
+// routes of the API on the repositories
+func newRouter(cfg *config.Config, repos *models.Repos, s services) (http.Handler, *jobs) {
 	r := httprouter.New()
 
 	// serve static files
 	r.NotFound = http.FileServer(http.Dir("public"))
 
+	// authentication required by private routes, permissions of each role are in models/permission.go
+	protect := middleware.NewAuth(repos.Users, repos.Sessions, repos.RolePolicies, cfg).Protect
+	permit := middleware.Permit
+
 	// bootcamp router
-	bc := controllers.NewBootcamp(conn)
+	bc := controllers.NewBootcamp(repos.Bootcamps, repos.Organizations, cfg, s.zipcodes, s.geocoder, s.storage)
 	r.GET("/api/v1/bootcamps", bc.GetBootcamps)
 	r.GET("/api/v1/bootcamps/:id", bc.GetBootcamp)
 	/*
-	 * route's name conflicts
+	 * httprouter does not allow a static segment next to :id,
+	 * so the radius search gets its own prefix
+	 */
+	r.GET("/api/v1/radius/bootcamps", bc.GetBootcampsInRadius)
+	r.POST("/api/v1/bootcamps", protect(permit(models.BootcampCreate)(bc.CreateBootcamp)))
+	r.PUT("/api/v1/bootcamps/:id", protect(permit(models.BootcampUpdate)(bc.UpdateBootcamp)))
+	r.DELETE("/api/v1/bootcamps/:id", protect(permit(models.BootcampDelete)(bc.DeleteBootcamp)))
+	r.PUT("/api/v1/bootcamps/:id/photo", protect(permit(models.BootcampUpdate)(bc.UploadBootcampPhoto)))
+	/*
+	 * the slug route needs a static segment next to :id too,
+	 * it is served before httprouter (see mux below)
 	 */
-	// r.GET("/api/v1/bootcamps/radius/:zipcode/:distance", bc.GetBootcampsInRadius)
-	r.POST("/api/v1/bootcamps", bc.CreateBootcamp)
-	r.PUT("/api/v1/bootcamps/:id", bc.UpdateBootcamp)
-	r.DELETE("/api/v1/bootcamps/:id", bc.DeleteBootcamp)
+	slugPrefix := "/api/v1/bootcamps/slug/"
+	getBootcampBySlug := func(w http.ResponseWriter, req *http.Request) {
+		slug := strings.TrimPrefix(req.URL.Path, slugPrefix)
+		if req.Method != http.MethodGet || slug == "" || strings.Contains(slug, "/") {
+			r.ServeHTTP(w, req)
+			return
+		}
+		bc.GetBootcampBySlug(w, req, httprouter.Params{{Key: "slug", Value: slug}})
+	}
 
 	// course router
-	c := controllers.NewCourse(conn)
+	c := controllers.NewCourse(repos.Courses, repos.Bootcamps, repos.Users, repos.Organizations, cfg)
 	r.GET("/api/v1/courses", c.GetCourses)
 	r.GET("/api/v1/bootcamps/:id/courses", c.GetCoursesInBootcamp)
 	r.GET("/api/v1/courses/:id", c.GetCourse)
-	r.POST("/api/v1/bootcamps/:id/courses", c.AddCourse)
-	r.PUT("/api/v1/courses/:id", c.UpdateCourse)
-	r.DELETE("/api/v1/courses/:id", c.DeleteCourse)
+	r.POST("/api/v1/bootcamps/:id/courses", protect(permit(models.CourseCreate)(c.AddCourse)))
+	r.PUT("/api/v1/courses/:id", protect(permit(models.CourseUpdate)(c.UpdateCourse)))
+	r.DELETE("/api/v1/courses/:id", protect(permit(models.CourseDelete)(c.DeleteCourse)))
 
 	// auth router
-	u := controllers.NewUser(conn)
+	u := controllers.NewUser(repos.Users, repos.Sessions, repos.RolePolicies, cfg, s.attempts)
 	r.POST("/api/v1/auth/register", u.Register)
 	r.POST("/api/v1/auth/login", u.Login)
+	r.POST("/api/v1/auth/login/2fa", u.LoginTwoFactor)
 	r.GET("/api/v1/auth/logout", u.Logout)
-	r.GET("/api/v1/auth/me", u.GetMe)
-	r.PUT("/api/v1/auth/updatedetails", u.UpdateDetails)
-	r.PUT("/api/v1/auth/updatepassword", u.UpdatePassword)
+	r.POST("/api/v1/auth/refresh", u.Refresh)
+	r.GET("/api/v1/auth/sessions", protect(u.GetSessions))
+	r.DELETE("/api/v1/auth/sessions/:id", protect(u.RevokeSession))
+	r.GET("/api/v1/auth/me", protect(u.GetMe))
+	r.PUT("/api/v1/auth/updatedetails", protect(u.UpdateDetails))
+	r.PUT("/api/v1/auth/updatepassword", protect(u.UpdatePassword))
 	r.POST("/api/v1/auth/forgotpassword", u.ForgotPassword)
 	r.PUT("/api/v1/auth/resetpassword/:token", u.ResetPassword)
+	r.GET("/api/v1/auth/verifyemail/:token", u.VerifyEmail)
+	r.POST("/api/v1/auth/verifyemail", protect(u.ResendVerifyEmail))
+	r.POST("/api/v1/auth/2fa/setup", protect(u.SetupTwoFactor))
+	r.POST("/api/v1/auth/2fa/confirm", protect(u.ConfirmTwoFactor))
+	r.POST("/api/v1/auth/2fa/recoverycodes", protect(u.RegenRecoveryCodes))
+	r.DELETE("/api/v1/auth/2fa", protect(u.DisableTwoFactor))
 
 	// admin router
-	r.GET("/api/v1/users", u.GetUsers)
-	r.GET("/api/v1/users/:id", u.GetUser)
-	r.POST("/api/v1/users", u.CreateUser)
-	r.PUT("/api/v1/users/:id", u.UpdateUser)
-	r.DELETE("/api/v1/users/:id", u.DeleteUser)
+	r.GET("/api/v1/users", protect(permit(models.UserRead)(u.GetUsers)))
+	r.GET("/api/v1/users/:id", protect(permit(models.UserRead)(u.GetUser)))
+	r.POST("/api/v1/users", protect(permit(models.UserCreate)(u.CreateUser)))
+	r.PUT("/api/v1/users/:id", protect(permit(models.UserUpdate)(u.UpdateUser)))
+	r.DELETE("/api/v1/users/:id", protect(permit(models.UserDelete)(u.DeleteUser)))
+	r.PUT("/api/v1/users/:id/role", protect(permit(models.RoleAssign)(u.AssignRole)))
+	r.GET("/api/v1/users/:id/quotas", protect(permit(models.UserRead)(u.GetQuotas)))
+	r.PUT("/api/v1/users/:id/quotas", protect(permit(models.QuotaUpdate)(u.SetQuotas)))
+	r.GET("/api/v1/roles/:role/twofactor", protect(permit(models.RolePolicyUpdate)(u.GetTwoFactorPolicy)))
+	r.PUT("/api/v1/roles/:role/twofactor", protect(permit(models.RolePolicyUpdate)(u.SetTwoFactorPolicy)))
+
+	// transfer router, the new owner accepts with the emailed token
+	tr := controllers.NewTransfer(repos.Transfers, repos.Bootcamps, repos.Users, repos.Organizations, cfg)
+	r.GET("/api/v1/bootcamps/:id/transfers", protect(permit(models.BootcampTransfer)(tr.GetTransfers)))
+	r.POST("/api/v1/bootcamps/:id/transfers", protect(permit(models.BootcampTransfer)(tr.StartTransfer)))
+	r.POST("/api/v1/transfers/:id/accept", protect(tr.AcceptTransfer))
+	r.POST("/api/v1/transfers/:id/decline", protect(tr.DeclineTransfer))
+	r.POST("/api/v1/transfers/:id/cancel", protect(permit(models.BootcampTransfer)(tr.CancelTransfer)))
+
+	// organization router, its members edit its bootcamps according to their role
+	o := controllers.NewOrganization(repos.Organizations, repos.Bootcamps, cfg)
+	r.GET("/api/v1/organizations", protect(o.GetOrganizations))
+	r.POST("/api/v1/organizations", protect(permit(models.OrganizationCreate)(o.CreateOrganization)))
+	r.GET("/api/v1/organizations/:id", protect(o.GetOrganization))
+	r.PUT("/api/v1/organizations/:id", protect(o.UpdateOrganization))
+	r.POST("/api/v1/organizations/:id/invites", protect(o.InviteMember))
+	r.POST("/api/v1/organizations/:id/join", protect(o.JoinOrganization))
+	r.PUT("/api/v1/organizations/:id/members/:user", protect(o.SetMemberRole))
+	r.DELETE("/api/v1/organizations/:id/members/:user", protect(o.RemoveMember))
+	r.PUT("/api/v1/bootcamps/:id/organization", protect(permit(models.BootcampTransfer)(o.SetBootcampOrganization)))
 
 	// review router
-	rw := controllers.NewReview(conn)
+	rw := controllers.NewReview(repos.Reviews, repos.Bootcamps, cfg)
 	r.GET("/api/v1/reviews", rw.GetReviews)
 	r.GET("/api/v1/bootcamps/:id/reviews", rw.GetReviewsInBootcamp)
 	r.GET("/api/v1/reviews/:id", rw.GetReview)
-	r.POST("/api/v1/bootcamps/:id/reviews", rw.AddReview)
-	r.PUT("/api/v1/reviews/:id", rw.UpdateReview)
-	r.DELETE("/api/v1/reviews/:id", rw.DeleteReview)
-
-	port := os.Getenv("PORT")
-	port = fmt.Sprint(":", port)
-	fmt.Printf("Listening on port %s\n", port)
-	log.Fatalln(http.ListenAndServe(port, r))
+	r.POST("/api/v1/bootcamps/:id/reviews", protect(permit(models.ReviewCreate)(rw.AddReview)))
+	r.PUT("/api/v1/reviews/:id", protect(permit(models.ReviewUpdate)(rw.UpdateReview)))
+	r.DELETE("/api/v1/reviews/:id", protect(permit(models.ReviewDelete)(rw.DeleteReview)))
+
+	// trash router
+	t := controllers.NewTrash(repos.Trash, repos.Bootcamps, repos.Courses, repos.Reviews, repos.Users)
+	r.GET("/api/v1/trash/:collection", protect(permit(models.TrashRead)(t.GetTrash)))
+	r.POST("/api/v1/trash/:collection/:id/restore", protect(permit(models.TrashRestore)(t.RestoreTrash)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(slugPrefix, getBootcampBySlug)
+	mux.Handle("/", r)
+
+	return mux, &jobs{bootcamps: bc, users: u}
 }
@@ -0,0 +1,1165 @@
+package main
+
+import (
+	"bytes"
+	"devcamper/config"
+	"devcamper/models"
+	"devcamper/utils"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// the app on the memory store, with the offline geocoder and the local storage
+type testApp struct {
+	t       *testing.T
+	handler http.Handler
+	store   models.Store
+	repos   *models.Repos
+	config  *config.Config
+}
+
+// response of the API decoded
+type response struct {
+	status int
+	header http.Header
+	body   map[string]interface{}
+}
+
+// failures are not throttled in the tests but in TestLoginThrottle
+var testAttemptPolicy = utils.AttemptPolicy{
+	FreeAttempts: 1000,
+	MaxAttempts:  1000,
+	Window:       time.Hour,
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	return newTestAppWithPolicy(t, testAttemptPolicy)
+}
+
+func newTestAppWithPolicy(t *testing.T, policy utils.AttemptPolicy) *testApp {
+	t.Helper()
+	cfg := testConfig()
+	store := models.NewMemoryStore()
+	repos := models.NewRepos(store)
+	handler := newTestRouter(t, cfg, store, repos, policy)
+	return &testApp{t: t, handler: handler, store: store, repos: repos, config: cfg}
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Scheme:             "http",
+		Host:               "localhost:5000",
+		Store:              "memory",
+		JWTSecret:          "test secret",
+		JWTExpire:          time.Hour,
+		RefreshTokenExpire: 24 * time.Hour,
+		TOTPIssuer:         "DevCamper",
+		TrashRetention:     24 * time.Hour,
+		MaxFileUpload:      1000000,
+	}
+}
+
+// routes of the app on the store, bootcamps are located offline and uploads go to a temp directory
+func newTestRouter(tb testing.TB, cfg *config.Config, store models.Store, repos *models.Repos, policy utils.AttemptPolicy) http.Handler {
+	tb.Helper()
+	zipcodes, err := utils.LoadGazetteer("./config/zipcodes.csv")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	geocoder, err := utils.LoadOfflineGeocoder("./config/geocodes.json", zipcodes)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	storage, err := utils.NewLocalStorage(tb.TempDir(), "/uploads")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	handler, _ := newRouter(cfg, repos, services{
+		zipcodes: zipcodes,
+		geocoder: models.NewCachedGeocoder(store, geocoder),
+		storage:  storage,
+		attempts: utils.NewMemoryAttemptTracker(policy),
+	})
+	return handler
+}
+
+// send the request with the token as bearer when not empty, body is sent as JSON
+func (a *testApp) do(method string, path string, token string, body interface{}) *response {
+	a.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	default:
+		bs, err := json.Marshal(b)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		r = bytes.NewReader(bs)
+	}
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return a.serve(req)
+}
+
+func (a *testApp) serve(req *http.Request) *response {
+	a.t.Helper()
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, req)
+	res := &response{status: w.Code, header: w.Header(), body: map[string]interface{}{}}
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(w.Body.Bytes(), &res.body); err != nil {
+			a.t.Fatalf("%s %s: invalid JSON %q", req.Method, req.URL, w.Body.String())
+		}
+	}
+	return res
+}
+
+// check the status, the error of the response is shown otherwise
+func (r *response) expect(t *testing.T, status int) *response {
+	t.Helper()
+	if r.status != status {
+		t.Fatalf("status %d, want %d: %v", r.status, status, r.body)
+	}
+	return r
+}
+
+func (r *response) data() map[string]interface{} {
+	d, _ := r.body["data"].(map[string]interface{})
+	return d
+}
+
+func (r *response) list() []interface{} {
+	l, _ := r.body["data"].([]interface{})
+	return l
+}
+
+func (r *response) id() string {
+	id, _ := r.data()["id"].(string)
+	return id
+}
+
+// register through the API, the email is verified, admins are promoted in the store
+func (a *testApp) signup(name string, role string) (string, *models.User) {
+	a.t.Helper()
+	email := strings.ToLower(strings.ReplaceAll(name, " ", "")) + "@gmail.com"
+	registerRole := role
+	if role == models.RoleAdmin {
+		registerRole = models.RoleUser
+	}
+	a.do("POST", "/api/v1/auth/register", "", map[string]string{
+		"name":     name,
+		"email":    email,
+		"password": "123456",
+		"role":     registerRole,
+	}).expect(a.t, http.StatusCreated)
+
+	user, err := a.repos.Users.FindOne(bson.M{"email": email})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	user.EmailVerified = true
+	user.Role = role
+	if err := a.repos.Users.Save(user); err != nil {
+		a.t.Fatal(err)
+	}
+	return a.login(email, "123456"), user
+}
+
+func (a *testApp) login(email string, password string) string {
+	a.t.Helper()
+	res := a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": email, "password": password}).expect(a.t, http.StatusCreated)
+	return res.body["token"].(string)
+}
+
+// bootcamps by their slug
+const slugPath = "/api/v1/bootcamps/slug/"
+
+var testBootcamp = map[string]interface{}{
+	"name":          "Devworks Bootcamp",
+	"description":   "Devworks is a full stack JavaScript Bootcamp located in the heart of Boston",
+	"website":       "https://devworks.com",
+	"phone":         "(111) 111-1111",
+	"email":         "enroll@devworks.com",
+	"address":       "233 Bay State Rd Boston MA 02215",
+	"careers":       []string{"Web Development", "UI/UX", "Business"},
+	"housing":       true,
+	"jobAssistance": true,
+}
+
+// bootcamp with the fields of testBootcamp and the name
+func (a *testApp) createBootcamp(token string, name string) string {
+	a.t.Helper()
+	b := map[string]interface{}{}
+	for k, v := range testBootcamp {
+		b[k] = v
+	}
+	b["name"] = name
+	return a.do("POST", "/api/v1/bootcamps", token, b).expect(a.t, http.StatusCreated).id()
+}
+
+func (a *testApp) createBootcampStatus(token string, status int) *response {
+	a.t.Helper()
+	return a.do("POST", "/api/v1/bootcamps", token, testBootcamp).expect(a.t, status)
+}
+
+func (a *testApp) addCourse(token string, bootcampId string, title string, tuition int) string {
+	a.t.Helper()
+	return a.do("POST", "/api/v1/bootcamps/"+bootcampId+"/courses", token, map[string]interface{}{
+		"title":        title,
+		"description":  "Learn " + title,
+		"weeks":        8,
+		"tuition":      tuition,
+		"minimumSkill": "beginner",
+	}).expect(a.t, http.StatusCreated).id()
+}
+
+func TestAuth(t *testing.T) {
+	a := newTestApp(t)
+
+	t.Run("register", func(t *testing.T) {
+		a.do("POST", "/api/v1/auth/register", "", map[string]string{"name": "John", "email": "john@gmail.com", "password": "123456", "role": "admin"}).expect(t, http.StatusBadRequest)
+		a.do("POST", "/api/v1/auth/register", "", map[string]string{"name": "John", "email": "not an email", "password": "123456"}).expect(t, http.StatusBadRequest)
+		res := a.do("POST", "/api/v1/auth/register", "", map[string]string{"name": "John", "email": "john@gmail.com", "password": "123456"}).expect(t, http.StatusCreated)
+		if res.body["token"] == "" || res.body["refreshToken"] == "" {
+			t.Errorf("no tokens: %v", res.body)
+		}
+		a.do("POST", "/api/v1/auth/register", "", map[string]string{"name": "John", "email": "john@gmail.com", "password": "123456"}).expect(t, http.StatusBadRequest)
+	})
+
+	t.Run("login", func(t *testing.T) {
+		a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "john@gmail.com"}).expect(t, http.StatusBadRequest)
+		a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "john@gmail.com", "password": "wrong"}).expect(t, http.StatusUnauthorized)
+		a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "nobody@gmail.com", "password": "123456"}).expect(t, http.StatusUnauthorized)
+		token := a.login("john@gmail.com", "123456")
+
+		me := a.do("GET", "/api/v1/auth/me", token, nil).expect(t, http.StatusOK).data()
+		if me["email"] != "john@gmail.com" || me["role"] != models.RoleUser || me["emailVerified"] != false {
+			t.Errorf("me = %v", me)
+		}
+		if _, ok := me["password"]; ok {
+			t.Error("the password is sent")
+		}
+		a.do("GET", "/api/v1/auth/me", "", nil).expect(t, http.StatusUnauthorized)
+		a.do("GET", "/api/v1/auth/me", "invalid", nil).expect(t, http.StatusUnauthorized)
+	})
+
+	t.Run("verify email", func(t *testing.T) {
+		token := a.login("john@gmail.com", "123456")
+		// there is no SMTP server in the tests, the email cannot be sent
+		a.do("POST", "/api/v1/auth/verifyemail", token, nil).expect(t, http.StatusInternalServerError)
+
+		user, _ := a.repos.Users.FindOne(bson.M{"email": "john@gmail.com"})
+		verify := user.GenVerifyEmailToken(a.config.JWTSecret)
+		a.repos.Users.Save(user)
+		a.do("GET", "/api/v1/auth/verifyemail/"+"00"+verify[2:], "", nil).expect(t, http.StatusBadRequest)
+		a.do("GET", "/api/v1/auth/verifyemail/"+verify, "", nil).expect(t, http.StatusOK)
+		if me := a.do("GET", "/api/v1/auth/me", token, nil).data(); me["emailVerified"] != true {
+			t.Errorf("email not verified: %v", me)
+		}
+		a.do("POST", "/api/v1/auth/verifyemail", token, nil).expect(t, http.StatusBadRequest)
+	})
+
+	t.Run("update details and password", func(t *testing.T) {
+		token := a.login("john@gmail.com", "123456")
+		res := a.do("PUT", "/api/v1/auth/updatedetails", token, map[string]string{"name": "John Doe"}).expect(t, http.StatusOK)
+		if res.data()["name"] != "John Doe" {
+			t.Errorf("name not updated: %v", res.data())
+		}
+		a.do("PUT", "/api/v1/auth/updatepassword", token, map[string]string{"currentPassword": "wrong", "newPassword": "654321"}).expect(t, http.StatusForbidden)
+		a.do("PUT", "/api/v1/auth/updatepassword", token, map[string]string{"currentPassword": "123456", "newPassword": "654321"}).expect(t, http.StatusOK)
+		a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "john@gmail.com", "password": "123456"}).expect(t, http.StatusUnauthorized)
+		a.login("john@gmail.com", "654321")
+	})
+
+	t.Run("forgot and reset password", func(t *testing.T) {
+		// the same answer whether the email exists or not
+		a.do("POST", "/api/v1/auth/forgotpassword", "", map[string]string{"email": "nobody@gmail.com"}).expect(t, http.StatusOK)
+		a.do("POST", "/api/v1/auth/forgotpassword", "", map[string]string{"email": "john@gmail.com"}).expect(t, http.StatusOK)
+
+		user, _ := a.repos.Users.FindOne(bson.M{"email": "john@gmail.com"})
+		reset := user.GenResetPwdToken(a.config.JWTSecret)
+		a.repos.Users.Save(user)
+		a.do("PUT", "/api/v1/auth/resetpassword/"+reset, "", map[string]string{"password": "abcdef"}).expect(t, http.StatusOK)
+		a.do("PUT", "/api/v1/auth/resetpassword/"+reset, "", map[string]string{"password": "abcdef"}).expect(t, http.StatusBadRequest)
+		a.login("john@gmail.com", "abcdef")
+	})
+}
+
+func TestLoginThrottle(t *testing.T) {
+	a := newTestAppWithPolicy(t, utils.DefaultAttemptPolicy)
+	a.signup("Jane", models.RoleUser)
+
+	for i := 0; i < utils.DefaultAttemptPolicy.FreeAttempts; i++ {
+		a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "jane@gmail.com", "password": "wrong"}).expect(t, http.StatusUnauthorized)
+	}
+	a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "jane@gmail.com", "password": "wrong"}).expect(t, http.StatusUnauthorized)
+	// delayed even with the right password
+	res := a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "jane@gmail.com", "password": "123456"}).expect(t, http.StatusTooManyRequests)
+	if res.header.Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	a := newTestApp(t)
+	a.signup("Jane", models.RoleUser)
+
+	first := a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "jane@gmail.com", "password": "123456"}).expect(t, http.StatusCreated)
+	token, refresh := first.body["token"].(string), first.body["refreshToken"].(string)
+
+	sessions := a.do("GET", "/api/v1/auth/sessions", token, nil).expect(t, http.StatusOK).list()
+	if len(sessions) != 3 {
+		t.Fatalf("%d sessions, want 3 (register, signup login and login)", len(sessions))
+	}
+
+	// the refresh token is rotated, the old one revokes the session when used again
+	rotated := a.do("POST", "/api/v1/auth/refresh", "", map[string]string{"refreshToken": refresh}).expect(t, http.StatusOK)
+	if rotated.body["refreshToken"] == refresh {
+		t.Error("the refresh token is not rotated")
+	}
+	a.do("POST", "/api/v1/auth/refresh", "", map[string]string{"refreshToken": refresh}).expect(t, http.StatusUnauthorized)
+	a.do("POST", "/api/v1/auth/refresh", "", map[string]string{"refreshToken": rotated.body["refreshToken"].(string)}).expect(t, http.StatusUnauthorized)
+	a.do("GET", "/api/v1/auth/me", token, nil).expect(t, http.StatusUnauthorized)
+
+	// revoke another session, the session id is in the token
+	token = a.login("jane@gmail.com", "123456")
+	other := a.login("jane@gmail.com", "123456")
+	payload, err := utils.NewJWT(a.config.JWTSecret, a.config.JWTExpire).Parse(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.do("DELETE", "/api/v1/auth/sessions/invalid", token, nil).expect(t, http.StatusBadRequest)
+	a.do("DELETE", "/api/v1/auth/sessions/"+payload.SessionId, token, nil).expect(t, http.StatusOK)
+	a.do("DELETE", "/api/v1/auth/sessions/"+payload.SessionId, token, nil).expect(t, http.StatusNotFound)
+	a.do("GET", "/api/v1/auth/me", other, nil).expect(t, http.StatusUnauthorized)
+	a.do("GET", "/api/v1/auth/me", token, nil).expect(t, http.StatusOK)
+
+	// logout revokes the session of the token
+	a.do("GET", "/api/v1/auth/logout", token, nil).expect(t, http.StatusOK)
+	a.do("GET", "/api/v1/auth/me", token, nil).expect(t, http.StatusUnauthorized)
+}
+
+// current code of the secret, the step used before is forgotten so the code is not a replay
+func (a *testApp) totp(user *models.User, secret string) string {
+	a.t.Helper()
+	u, err := a.repos.Users.FindOne(bson.M{"_id": user.Id})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	u.TwoFactor.LastStep = 0
+	if err := a.repos.Users.Save(u); err != nil {
+		a.t.Fatal(err)
+	}
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return code
+}
+
+func TestTwoFactor(t *testing.T) {
+	a := newTestApp(t)
+	token, user := a.signup("Jane", models.RoleUser)
+
+	a.do("POST", "/api/v1/auth/2fa/confirm", token, map[string]string{"code": "000000"}).expect(t, http.StatusBadRequest)
+	setup := a.do("POST", "/api/v1/auth/2fa/setup", token, nil).expect(t, http.StatusOK).data()
+	secret := setup["secret"].(string)
+	if !strings.HasPrefix(setup["uri"].(string), "otpauth://totp/") {
+		t.Errorf("uri = %v", setup["uri"])
+	}
+	a.do("POST", "/api/v1/auth/2fa/confirm", token, map[string]string{}).expect(t, http.StatusBadRequest)
+	confirm := a.do("POST", "/api/v1/auth/2fa/confirm", token, map[string]string{"code": a.totp(user, secret)}).expect(t, http.StatusOK).data()
+	recoveryCodes := confirm["recoveryCodes"].([]interface{})
+	if len(recoveryCodes) == 0 {
+		t.Fatal("no recovery codes")
+	}
+	a.do("POST", "/api/v1/auth/2fa/setup", token, nil).expect(t, http.StatusBadRequest)
+
+	// password then code
+	login := a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "jane@gmail.com", "password": "123456"}).expect(t, http.StatusOK)
+	if login.body["twoFactorRequired"] != true || login.body["token"] != nil {
+		t.Fatalf("login = %v", login.body)
+	}
+	challenge := login.body["challengeToken"].(string)
+	a.do("GET", "/api/v1/auth/me", challenge, nil).expect(t, http.StatusUnauthorized)
+	a.do("POST", "/api/v1/auth/login/2fa", "", map[string]string{"challengeToken": challenge}).expect(t, http.StatusBadRequest)
+	a.do("POST", "/api/v1/auth/login/2fa", "", map[string]string{"challengeToken": token, "code": "000000"}).expect(t, http.StatusUnauthorized)
+	a.do("POST", "/api/v1/auth/login/2fa", "", map[string]string{"challengeToken": challenge, "code": "000000"}).expect(t, http.StatusUnauthorized)
+	code := a.totp(user, secret)
+	res := a.do("POST", "/api/v1/auth/login/2fa", "", map[string]string{"challengeToken": challenge, "code": code}).expect(t, http.StatusCreated)
+	token = res.body["token"].(string)
+	// the code is used once
+	a.do("POST", "/api/v1/auth/login/2fa", "", map[string]string{"challengeToken": challenge, "code": code}).expect(t, http.StatusUnauthorized)
+
+	// recovery code instead of the code, used once
+	recovery := recoveryCodes[0].(string)
+	a.do("POST", "/api/v1/auth/login/2fa", "", map[string]string{"challengeToken": challenge, "recoveryCode": recovery}).expect(t, http.StatusCreated)
+	a.do("POST", "/api/v1/auth/login/2fa", "", map[string]string{"challengeToken": challenge, "recoveryCode": recovery}).expect(t, http.StatusUnauthorized)
+
+	// new recovery codes replace the old ones
+	a.do("POST", "/api/v1/auth/2fa/recoverycodes", token, map[string]string{"code": "000000"}).expect(t, http.StatusBadRequest)
+	regen := a.do("POST", "/api/v1/auth/2fa/recoverycodes", token, map[string]string{"code": a.totp(user, secret)}).expect(t, http.StatusOK).data()
+	if regen["recoveryCodes"].([]interface{})[0] == recoveryCodes[1] {
+		t.Error("recovery codes not replaced")
+	}
+	a.do("POST", "/api/v1/auth/login/2fa", "", map[string]string{"challengeToken": challenge, "recoveryCode": recoveryCodes[1].(string)}).expect(t, http.StatusUnauthorized)
+
+	a.do("DELETE", "/api/v1/auth/2fa", token, map[string]string{"password": "wrong", "code": a.totp(user, secret)}).expect(t, http.StatusForbidden)
+	a.do("DELETE", "/api/v1/auth/2fa", token, map[string]string{"password": "123456", "code": a.totp(user, secret)}).expect(t, http.StatusOK)
+	a.do("DELETE", "/api/v1/auth/2fa", token, map[string]string{"password": "123456"}).expect(t, http.StatusBadRequest)
+	a.login("jane@gmail.com", "123456")
+}
+
+func TestTwoFactorPolicy(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	publisher, user := a.signup("Publisher", models.RolePublisher)
+
+	a.do("GET", "/api/v1/roles/publisher/twofactor", publisher, nil).expect(t, http.StatusForbidden)
+	a.do("GET", "/api/v1/roles/nobody/twofactor", admin, nil).expect(t, http.StatusNotFound)
+	a.do("PUT", "/api/v1/roles/publisher/twofactor", admin, map[string]string{}).expect(t, http.StatusBadRequest)
+	a.do("PUT", "/api/v1/roles/publisher/twofactor", admin, map[string]bool{"requireTwoFactor": true}).expect(t, http.StatusOK)
+	policy := a.do("GET", "/api/v1/roles/publisher/twofactor", admin, nil).expect(t, http.StatusOK).data()
+	if policy["requireTwoFactor"] != true {
+		t.Errorf("policy = %v", policy)
+	}
+
+	// the publisher can only enroll until 2FA is enabled
+	a.createBootcampStatus(publisher, http.StatusForbidden)
+	a.do("GET", "/api/v1/auth/me", publisher, nil).expect(t, http.StatusOK)
+	secret := a.do("POST", "/api/v1/auth/2fa/setup", publisher, nil).expect(t, http.StatusOK).data()["secret"].(string)
+	a.do("POST", "/api/v1/auth/2fa/confirm", publisher, map[string]string{"code": a.totp(user, secret)}).expect(t, http.StatusOK)
+	a.createBootcampStatus(publisher, http.StatusCreated)
+
+	// and cannot disable it while required
+	a.do("DELETE", "/api/v1/auth/2fa", publisher, map[string]string{"password": "123456", "code": a.totp(user, secret)}).expect(t, http.StatusForbidden)
+	a.do("PUT", "/api/v1/roles/publisher/twofactor", admin, map[string]bool{"requireTwoFactor": false}).expect(t, http.StatusOK)
+	a.do("DELETE", "/api/v1/auth/2fa", publisher, map[string]string{"password": "123456", "code": a.totp(user, secret)}).expect(t, http.StatusOK)
+}
+
+func TestUsers(t *testing.T) {
+	a := newTestApp(t)
+	admin, adminUser := a.signup("Admin", models.RoleAdmin)
+	user, _ := a.signup("Jane", models.RoleUser)
+
+	a.do("GET", "/api/v1/users", user, nil).expect(t, http.StatusForbidden)
+	a.do("GET", "/api/v1/users", "", nil).expect(t, http.StatusUnauthorized)
+	res := a.do("GET", "/api/v1/users?sort=name", admin, nil).expect(t, http.StatusOK)
+	if res.body["count"] != 2.0 || res.list()[0].(map[string]interface{})["name"] != "Admin" {
+		t.Errorf("users = %v", res.body)
+	}
+
+	created := a.do("POST", "/api/v1/users", admin, map[string]string{"name": "John", "email": "john@gmail.com", "password": "123456", "role": "publisher"}).expect(t, http.StatusCreated)
+	id := created.id()
+	a.do("POST", "/api/v1/users", admin, map[string]string{"name": "John", "email": "john@gmail.com", "password": "123456"}).expect(t, http.StatusBadRequest)
+	a.do("POST", "/api/v1/users", admin, map[string]string{"name": "John"}).expect(t, http.StatusBadRequest)
+	a.login("john@gmail.com", "123456")
+
+	a.do("GET", "/api/v1/users/invalid", admin, nil).expect(t, http.StatusBadRequest)
+	a.do("GET", "/api/v1/users/"+bson.NewObjectId().Hex(), admin, nil).expect(t, http.StatusNotFound)
+	if got := a.do("GET", "/api/v1/users/"+id, admin, nil).expect(t, http.StatusOK).data(); got["email"] != "john@gmail.com" {
+		t.Errorf("user = %v", got)
+	}
+
+	updated := a.do("PUT", "/api/v1/users/"+id, admin, map[string]string{"name": "John Doe"}).expect(t, http.StatusOK).data()
+	if updated["name"] != "John Doe" || updated["email"] != "john@gmail.com" {
+		t.Errorf("updated = %v", updated)
+	}
+	a.do("PUT", "/api/v1/users/"+id, admin, map[string]string{"email": "jane@gmail.com"}).expect(t, http.StatusBadRequest)
+
+	// role
+	a.do("PUT", "/api/v1/users/"+adminUser.Id.Hex()+"/role", admin, map[string]string{"role": "user"}).expect(t, http.StatusBadRequest)
+	a.do("PUT", "/api/v1/users/"+id+"/role", admin, map[string]string{}).expect(t, http.StatusBadRequest)
+	a.do("PUT", "/api/v1/users/"+id+"/role", admin, map[string]string{"role": "king"}).expect(t, http.StatusBadRequest)
+	a.do("PUT", "/api/v1/users/"+id+"/role", user, map[string]string{"role": "admin"}).expect(t, http.StatusForbidden)
+	if got := a.do("PUT", "/api/v1/users/"+id+"/role", admin, map[string]string{"role": "user"}).expect(t, http.StatusOK).data(); got["role"] != "user" {
+		t.Errorf("role = %v", got["role"])
+	}
+
+	// deleted users go to the trash and can be restored
+	a.do("DELETE", "/api/v1/users/"+id, admin, nil).expect(t, http.StatusOK)
+	a.do("GET", "/api/v1/users/"+id, admin, nil).expect(t, http.StatusNotFound)
+	a.do("POST", "/api/v1/auth/login", "", map[string]string{"email": "john@gmail.com", "password": "123456"}).expect(t, http.StatusUnauthorized)
+	trash := a.do("GET", "/api/v1/trash/users", admin, nil).expect(t, http.StatusOK)
+	if trash.body["count"] != 1.0 || trash.list()[0].(map[string]interface{})["id"] != id {
+		t.Errorf("trash = %v", trash.body)
+	}
+	a.do("POST", "/api/v1/trash/users/"+id+"/restore", admin, nil).expect(t, http.StatusOK)
+	a.do("POST", "/api/v1/trash/users/"+id+"/restore", admin, nil).expect(t, http.StatusNotFound)
+	a.do("GET", "/api/v1/users/"+id, admin, nil).expect(t, http.StatusOK)
+}
+
+func TestQuotas(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	publisher, user := a.signup("Publisher", models.RolePublisher)
+	id := user.Id.Hex()
+
+	quotas := a.do("GET", "/api/v1/users/"+id+"/quotas", admin, nil).expect(t, http.StatusOK).data()
+	if limits := quotas["limits"].(map[string]interface{}); limits[models.QuotaBootcamps] != 1.0 {
+		t.Errorf("limits = %v", limits)
+	}
+
+	a.createBootcamp(publisher, "First Bootcamp")
+	res := a.createBootcampStatus(publisher, http.StatusForbidden)
+	if q := res.body["quota"].(map[string]interface{}); q["quota"] != models.QuotaBootcamps || q["limit"] != 1.0 || q["used"] != 1.0 {
+		t.Errorf("quota = %v", res.body)
+	}
+
+	a.do("PUT", "/api/v1/users/"+id+"/quotas", publisher, map[string]int{models.QuotaBootcamps: 2}).expect(t, http.StatusForbidden)
+	a.do("PUT", "/api/v1/users/"+id+"/quotas", admin, map[string]int{}).expect(t, http.StatusBadRequest)
+	a.do("PUT", "/api/v1/users/"+id+"/quotas", admin, map[string]int{"planets": 2}).expect(t, http.StatusBadRequest)
+	a.do("PUT", "/api/v1/users/"+id+"/quotas", admin, map[string]int{models.QuotaBootcamps: -2}).expect(t, http.StatusBadRequest)
+	a.do("PUT", "/api/v1/users/"+id+"/quotas", admin, map[string]int{models.QuotaBootcamps: models.Unlimited}).expect(t, http.StatusOK)
+	a.createBootcamp(publisher, "Second Bootcamp")
+	a.createBootcamp(publisher, "Third Bootcamp")
+
+	// null gives back the quota of the role
+	quotas = a.do("PUT", "/api/v1/users/"+id+"/quotas", admin, map[string]interface{}{models.QuotaBootcamps: nil}).expect(t, http.StatusOK).data()
+	if overrides, _ := quotas["overrides"].(map[string]interface{}); len(overrides) != 0 {
+		t.Errorf("overrides = %v", overrides)
+	}
+	a.createBootcampStatus(publisher, http.StatusForbidden)
+}
+
+func TestRestoreQuotas(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	publisher, user := a.signup("Publisher", models.RolePublisher)
+	jane, _ := a.signup("Jane", models.RoleUser)
+	quotasPath := "/api/v1/users/" + user.Id.Hex() + "/quotas"
+	expectQuota := func(res *response, quota string, limit float64, used float64) {
+		t.Helper()
+		if q, _ := res.body["quota"].(map[string]interface{}); q["quota"] != quota || q["limit"] != limit || q["used"] != used {
+			t.Errorf("quota = %v, want %s limited to %v with %v used", res.body, quota, limit, used)
+		}
+	}
+
+	// a bootcamp deleted with its courses, then another one in its place
+	first := a.createBootcamp(publisher, "First Bootcamp")
+	a.addCourse(publisher, first, "Front End", 8000)
+	a.addCourse(publisher, first, "Back End", 9000)
+	a.do("DELETE", "/api/v1/bootcamps/"+first, publisher, nil).expect(t, http.StatusOK)
+	second := a.createBootcamp(publisher, "Second Bootcamp")
+
+	restorePath := "/api/v1/trash/bootcamps/" + first + "/restore"
+	expectQuota(a.do("POST", restorePath, admin, nil).expect(t, http.StatusForbidden), models.QuotaBootcamps, 1, 1)
+	a.do("PUT", quotasPath, admin, map[string]int{models.QuotaBootcamps: 2, models.QuotaCoursesPerBootcamp: 1}).expect(t, http.StatusOK)
+	expectQuota(a.do("POST", restorePath, admin, nil).expect(t, http.StatusForbidden), models.QuotaCoursesPerBootcamp, 1, 0)
+	a.do("GET", "/api/v1/bootcamps/"+first, "", nil).expect(t, http.StatusNotFound)
+	a.do("PUT", quotasPath, admin, map[string]int{models.QuotaCoursesPerBootcamp: 2}).expect(t, http.StatusOK)
+	a.do("POST", restorePath, admin, nil).expect(t, http.StatusOK)
+
+	// a deleted course replaced by another one
+	course := a.addCourse(publisher, second, "Data Science", 10000)
+	a.addCourse(publisher, second, "Machine Learning", 11000)
+	a.do("DELETE", "/api/v1/courses/"+course, publisher, nil).expect(t, http.StatusOK)
+	a.addCourse(publisher, second, "Statistics", 7000)
+	expectQuota(a.do("POST", "/api/v1/trash/courses/"+course+"/restore", admin, nil).expect(t, http.StatusForbidden), models.QuotaCoursesPerBootcamp, 2, 2)
+
+	// a deleted review replaced by another one
+	path := "/api/v1/bootcamps/" + second + "/reviews"
+	review := map[string]interface{}{"title": "Learned a ton!", "text": "I learned a lot", "rating": 8}
+	id := a.do("POST", path, jane, review).expect(t, http.StatusCreated).id()
+	a.do("DELETE", "/api/v1/reviews/"+id, jane, nil).expect(t, http.StatusOK)
+	a.do("POST", path, jane, review).expect(t, http.StatusCreated)
+	expectQuota(a.do("POST", "/api/v1/trash/reviews/"+id+"/restore", admin, nil).expect(t, http.StatusForbidden), models.QuotaReviewsPerBootcamp, 1, 1)
+
+	// a live document is not in the trash
+	a.do("POST", "/api/v1/trash/bootcamps/"+second+"/restore", admin, nil).expect(t, http.StatusNotFound)
+}
+
+// multipart request with the file, as sent by a browser form
+func (a *testApp) upload(path string, token string, filename string, data []byte) *response {
+	a.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if filename != "" {
+		fw, err := mw.CreateFormFile("file", filename)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	mw.Close()
+	req := httptest.NewRequest("PUT", path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return a.serve(req)
+}
+
+func names(list []interface{}) []string {
+	s := []string{}
+	for _, v := range list {
+		s = append(s, v.(map[string]interface{})["name"].(string))
+	}
+	return s
+}
+
+func TestBootcamps(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	publisher, _ := a.signup("Publisher", models.RolePublisher)
+	other, _ := a.signup("Other", models.RolePublisher)
+	user, _ := a.signup("Jane", models.RoleUser)
+
+	t.Run("create", func(t *testing.T) {
+		a.createBootcampStatus("", http.StatusUnauthorized)
+		a.createBootcampStatus(user, http.StatusForbidden)
+		a.do("POST", "/api/v1/bootcamps", publisher, map[string]string{"name": "No Description"}).expect(t, http.StatusBadRequest)
+		a.do("POST", "/api/v1/bootcamps", publisher, "{").expect(t, http.StatusBadRequest)
+
+		res := a.createBootcampStatus(publisher, http.StatusCreated).data()
+		location := res["location"].(map[string]interface{})
+		if res["slug"] != "devworks-bootcamp" || res["photo"] != "no-photo.jpg" || location["city"] != "Boston" || res["address"] != nil {
+			t.Errorf("bootcamp = %v", res)
+		}
+
+		lowell := map[string]interface{}{}
+		for k, v := range testBootcamp {
+			lowell[k] = v
+		}
+		lowell["name"] = "ModernTech Bootcamp"
+		lowell["address"] = "220 Pawtucket St, Lowell, MA 01854"
+		lowell["careers"] = []string{"UI/UX", "Mobile Development"}
+		lowell["housing"] = false
+		a.do("POST", "/api/v1/bootcamps", other, lowell).expect(t, http.StatusCreated)
+
+		lowell["address"] = "nowhere"
+		a.do("POST", "/api/v1/bootcamps", admin, lowell).expect(t, http.StatusBadRequest)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		res := a.do("GET", "/api/v1/bootcamps?sort=name", "", nil).expect(t, http.StatusOK)
+		if got := names(res.list()); !equalNames(got, "Devworks Bootcamp", "ModernTech Bootcamp") {
+			t.Errorf("bootcamps = %v", got)
+		}
+		if res.header.Get("X-Total-Count") != "2" {
+			t.Errorf("X-Total-Count = %q", res.header.Get("X-Total-Count"))
+		}
+		res = a.do("GET", "/api/v1/bootcamps?housing=true&careers[in]=UI/UX,Business", "", nil).expect(t, http.StatusOK)
+		if got := names(res.list()); !equalNames(got, "Devworks Bootcamp") {
+			t.Errorf("filtered bootcamps = %v", got)
+		}
+		res = a.do("GET", "/api/v1/bootcamps?select=id,name&sort=-name&limit=1", "", nil).expect(t, http.StatusOK)
+		first := res.list()[0].(map[string]interface{})
+		if first["name"] != "ModernTech Bootcamp" || first["description"] != nil || res.header.Get("Link") == "" {
+			t.Errorf("selected = %v, Link %q", first, res.header.Get("Link"))
+		}
+		a.do("GET", "/api/v1/bootcamps?planet=mars", "", nil).expect(t, http.StatusBadRequest)
+		a.do("GET", "/api/v1/bootcamps?sort=password", "", nil).expect(t, http.StatusBadRequest)
+
+		id := res.list()[0].(map[string]interface{})["id"].(string)
+		got := a.do("GET", "/api/v1/bootcamps/"+id+"?expand=user", "", nil).expect(t, http.StatusOK).data()
+		if owner, _ := got["user"].(map[string]interface{}); owner["name"] != "Other" || owner["email"] != nil {
+			t.Errorf("expanded user = %v", got["user"])
+		}
+		a.do("GET", "/api/v1/bootcamps/invalid", "", nil).expect(t, http.StatusBadRequest)
+		// an unknown id is a bad request since the first version of the API
+		a.do("GET", "/api/v1/bootcamps/"+bson.NewObjectId().Hex(), "", nil).expect(t, http.StatusBadRequest)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		res := a.do("GET", "/api/v1/bootcamps?q=mobile", "", nil).expect(t, http.StatusOK)
+		if got := names(res.list()); !equalNames(got, "ModernTech Bootcamp") {
+			t.Errorf("search = %v", got)
+		}
+	})
+
+	t.Run("radius", func(t *testing.T) {
+		res := a.do("GET", "/api/v1/radius/bootcamps?zipcode=02108&distance=10", "", nil).expect(t, http.StatusOK)
+		if got := names(res.list()); !equalNames(got, "Devworks Bootcamp") {
+			t.Errorf("10mi of Boston = %v", got)
+		}
+		res = a.do("GET", "/api/v1/radius/bootcamps?zipcode=02108&distance=50&unit=km&sort=name", "", nil).expect(t, http.StatusOK)
+		if got := names(res.list()); !equalNames(got, "Devworks Bootcamp", "ModernTech Bootcamp") {
+			t.Errorf("50km of Boston = %v", got)
+		}
+		a.do("GET", "/api/v1/radius/bootcamps?distance=10", "", nil).expect(t, http.StatusBadRequest)
+		a.do("GET", "/api/v1/radius/bootcamps?zipcode=02108&distance=-1", "", nil).expect(t, http.StatusBadRequest)
+		a.do("GET", "/api/v1/radius/bootcamps?zipcode=02108&distance=10&unit=ly", "", nil).expect(t, http.StatusBadRequest)
+		a.do("GET", "/api/v1/radius/bootcamps?zipcode=99999&distance=10", "", nil).expect(t, http.StatusNotFound)
+	})
+
+	id := a.do("GET", "/api/v1/bootcamps?name=Devworks+Bootcamp", "", nil).list()[0].(map[string]interface{})["id"].(string)
+
+	t.Run("update", func(t *testing.T) {
+		a.do("PUT", "/api/v1/bootcamps/"+id, other, map[string]string{"phone": "(222) 222-2222"}).expect(t, http.StatusForbidden)
+		a.do("PUT", "/api/v1/bootcamps/"+id, user, map[string]string{"phone": "(222) 222-2222"}).expect(t, http.StatusForbidden)
+		res := a.do("PUT", "/api/v1/bootcamps/"+id, publisher, map[string]string{"name": "Devworks Academy"}).expect(t, http.StatusOK).data()
+		if res["name"] != "Devworks Academy" || res["slug"] != "devworks-academy" {
+			t.Errorf("updated = %v", res)
+		}
+		res = a.do("PUT", "/api/v1/bootcamps/"+id, admin, map[string]string{"phone": "(222) 222-2222"}).expect(t, http.StatusOK).data()
+		if res["phone"] != "(222) 222-2222" || res["name"] != "Devworks Academy" {
+			t.Errorf("updated by admin = %v", res)
+		}
+	})
+
+	t.Run("slug", func(t *testing.T) {
+		res := a.do("GET", slugPath+"devworks-academy", "", nil).expect(t, http.StatusOK)
+		if res.data()["id"] != id {
+			t.Errorf("bootcamp = %v", res.data())
+		}
+		// the old slug leads to the new one
+		res = a.do("GET", slugPath+"devworks-bootcamp?expand=user", "", nil).expect(t, http.StatusMovedPermanently)
+		if loc := res.header.Get("Location"); loc != slugPath+"devworks-academy?expand=user" {
+			t.Errorf("Location = %q", loc)
+		}
+		a.do("GET", slugPath+"nothing", "", nil).expect(t, http.StatusNotFound)
+	})
+
+	t.Run("photo", func(t *testing.T) {
+		png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+		a.upload("/api/v1/bootcamps/"+id+"/photo", other, "photo.png", png).expect(t, http.StatusForbidden)
+		a.upload("/api/v1/bootcamps/"+id+"/photo", publisher, "", nil).expect(t, http.StatusBadRequest)
+		a.upload("/api/v1/bootcamps/"+id+"/photo", publisher, "photo.png", []byte("not an image")).expect(t, http.StatusBadRequest)
+		a.upload("/api/v1/bootcamps/"+id+"/photo", publisher, "photo.png", make([]byte, a.config.MaxFileUpload+1)).expect(t, http.StatusBadRequest)
+		res := a.upload("/api/v1/bootcamps/"+id+"/photo", publisher, "photo.jpg", png).expect(t, http.StatusOK)
+		if photo := res.body["data"].(string); photo != "/uploads/photo_"+id+".png" {
+			t.Errorf("photo = %s", photo)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		a.addCourse(publisher, id, "Front End Web Development", 8000)
+		a.do("DELETE", "/api/v1/bootcamps/"+id+"?dryRun=maybe", publisher, nil).expect(t, http.StatusBadRequest)
+		a.do("DELETE", "/api/v1/bootcamps/"+id, other, nil).expect(t, http.StatusForbidden)
+
+		report := a.do("DELETE", "/api/v1/bootcamps/"+id+"?dryRun=true", publisher, nil).expect(t, http.StatusOK).data()
+		if counts := report["counts"].(map[string]interface{}); report["dryRun"] != true || counts["bootcamps"] != 1.0 || counts["courses"] != 1.0 {
+			t.Errorf("dry run = %v", report)
+		}
+		a.do("GET", "/api/v1/bootcamps/"+id, "", nil).expect(t, http.StatusOK)
+
+		report = a.do("DELETE", "/api/v1/bootcamps/"+id, publisher, nil).expect(t, http.StatusOK).data()
+		if report["dryRun"] != false || report["batch"] == nil {
+			t.Errorf("report = %v", report)
+		}
+		a.do("GET", "/api/v1/bootcamps/"+id, "", nil).expect(t, http.StatusNotFound)
+		a.do("DELETE", "/api/v1/bootcamps/"+id, publisher, nil).expect(t, http.StatusNotFound)
+		if res := a.do("GET", "/api/v1/courses", "", nil).expect(t, http.StatusOK); res.body["count"] != 0.0 {
+			t.Errorf("courses of the deleted bootcamp = %v", res.body)
+		}
+
+		// restored with its courses
+		a.do("GET", "/api/v1/trash/bootcamps", publisher, nil).expect(t, http.StatusForbidden)
+		a.do("GET", "/api/v1/trash/planets", admin, nil).expect(t, http.StatusNotFound)
+		if trash := a.do("GET", "/api/v1/trash/courses", admin, nil).expect(t, http.StatusOK); trash.body["count"] != 1.0 {
+			t.Errorf("trash = %v", trash.body)
+		}
+		restored := a.do("POST", "/api/v1/trash/bootcamps/"+id+"/restore", admin, nil).expect(t, http.StatusOK).data()
+		if counts := restored["counts"].(map[string]interface{}); counts["courses"] != 1.0 {
+			t.Errorf("restored = %v", restored)
+		}
+		if got := a.do("GET", "/api/v1/bootcamps/"+id, "", nil).expect(t, http.StatusOK).data(); got["averageCost"] != 8000.0 {
+			t.Errorf("average cost = %v", got["averageCost"])
+		}
+	})
+}
+
+func equalNames(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCourses(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	publisher, _ := a.signup("Publisher", models.RolePublisher)
+	other, _ := a.signup("Other", models.RolePublisher)
+	user, _ := a.signup("Jane", models.RoleUser)
+	bootcampId := a.createBootcamp(publisher, "Devworks Bootcamp")
+
+	path := "/api/v1/bootcamps/" + bootcampId + "/courses"
+	course := map[string]interface{}{"title": "Front End Web Development", "description": "HTML, CSS and JavaScript", "weeks": 8, "tuition": 8000, "minimumSkill": "beginner"}
+	a.do("POST", path, user, course).expect(t, http.StatusForbidden)
+	a.do("POST", path, other, course).expect(t, http.StatusForbidden)
+	a.do("POST", path, publisher, map[string]string{"title": "No Description"}).expect(t, http.StatusBadRequest)
+	a.do("POST", "/api/v1/bootcamps/invalid/courses", publisher, course).expect(t, http.StatusBadRequest)
+	id := a.do("POST", path, publisher, course).expect(t, http.StatusCreated).id()
+	a.addCourse(admin, bootcampId, "Full Stack Web Development", 12000)
+
+	if got := a.do("GET", "/api/v1/bootcamps/"+bootcampId, "", nil).data(); got["averageCost"] != 10000.0 {
+		t.Errorf("average cost = %v", got["averageCost"])
+	}
+	res := a.do("GET", path, "", nil).expect(t, http.StatusOK)
+	if res.body["count"] != 2.0 {
+		t.Errorf("courses of bootcamp = %v", res.body)
+	}
+	res = a.do("GET", "/api/v1/courses?tuition[gt]=9000&expand=bootcamp", "", nil).expect(t, http.StatusOK)
+	if list := res.list(); len(list) != 1 || list[0].(map[string]interface{})["bootcamp"].(map[string]interface{})["name"] != "Devworks Bootcamp" {
+		t.Errorf("courses = %v", res.body)
+	}
+	// only the public fields of the bootcamp
+	for field := range res.list()[0].(map[string]interface{})["bootcamp"].(map[string]interface{}) {
+		switch field {
+		case "id", "name", "slug", "description", "website", "phone", "email", "location", "careers",
+			"averageRating", "averageCost", "photo", "housing", "jobAssistance", "jobGuarantee",
+			"acceptGi", "user", "organization":
+		default:
+			t.Errorf("expanded bootcamp has %s", field)
+		}
+	}
+	a.do("GET", "/api/v1/courses?expand=bootcamp(previousSlugs)", "", nil).expect(t, http.StatusBadRequest)
+	if got := a.do("GET", "/api/v1/courses/"+id, "", nil).expect(t, http.StatusOK).data(); got["title"] != "Front End Web Development" {
+		t.Errorf("course = %v", got)
+	}
+	a.do("GET", "/api/v1/courses/invalid", "", nil).expect(t, http.StatusBadRequest)
+
+	a.do("PUT", "/api/v1/courses/"+id, other, map[string]int{"tuition": 1000}).expect(t, http.StatusForbidden)
+	if got := a.do("PUT", "/api/v1/courses/"+id, publisher, map[string]int{"tuition": 4000}).expect(t, http.StatusOK).data(); got["tuition"] != 4000.0 {
+		t.Errorf("updated course = %v", got)
+	}
+	if got := a.do("GET", "/api/v1/bootcamps/"+bootcampId, "", nil).data(); got["averageCost"] != 8000.0 {
+		t.Errorf("average cost after update = %v", got["averageCost"])
+	}
+
+	a.do("DELETE", "/api/v1/courses/"+id, other, nil).expect(t, http.StatusForbidden)
+	a.do("DELETE", "/api/v1/courses/"+id, publisher, nil).expect(t, http.StatusOK)
+	a.do("DELETE", "/api/v1/courses/"+id, publisher, nil).expect(t, http.StatusNotFound)
+	a.do("GET", "/api/v1/courses/"+id, "", nil).expect(t, http.StatusNotFound)
+	if got := a.do("GET", "/api/v1/bootcamps/"+bootcampId, "", nil).data(); got["averageCost"] != 12000.0 {
+		t.Errorf("average cost after delete = %v", got["averageCost"])
+	}
+	a.do("POST", "/api/v1/trash/courses/"+id+"/restore", admin, nil).expect(t, http.StatusOK)
+	if got := a.do("GET", "/api/v1/bootcamps/"+bootcampId, "", nil).data(); got["averageCost"] != 8000.0 {
+		t.Errorf("average cost after restore = %v", got["averageCost"])
+	}
+}
+
+func TestReviews(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	publisher, _ := a.signup("Publisher", models.RolePublisher)
+	jane, _ := a.signup("Jane", models.RoleUser)
+	john, _ := a.signup("John", models.RoleUser)
+	bootcampId := a.createBootcamp(publisher, "Devworks Bootcamp")
+
+	path := "/api/v1/bootcamps/" + bootcampId + "/reviews"
+	review := map[string]interface{}{"title": "Learned a ton!", "text": "I learned a lot", "rating": 8}
+	a.do("POST", path, publisher, review).expect(t, http.StatusForbidden)
+	a.do("POST", path, jane, map[string]string{"title": "No text"}).expect(t, http.StatusBadRequest)
+	id := a.do("POST", path, jane, review).expect(t, http.StatusCreated).id()
+	// one review of a bootcamp by user
+	a.do("POST", path, jane, review).expect(t, http.StatusForbidden)
+	review["rating"] = 4
+	a.do("POST", path, john, review).expect(t, http.StatusCreated)
+
+	if got := a.do("GET", "/api/v1/bootcamps/"+bootcampId, "", nil).data(); got["averageRating"] != 6.0 {
+		t.Errorf("average rating = %v", got["averageRating"])
+	}
+	if res := a.do("GET", path, "", nil).expect(t, http.StatusOK); res.body["count"] != 2.0 {
+		t.Errorf("reviews of bootcamp = %v", res.body)
+	}
+	if res := a.do("GET", "/api/v1/reviews?rating[gte]=5", "", nil).expect(t, http.StatusOK); len(res.list()) != 1 {
+		t.Errorf("reviews = %v", res.body)
+	}
+	if got := a.do("GET", "/api/v1/reviews/"+id, "", nil).expect(t, http.StatusOK).data(); got["title"] != "Learned a ton!" {
+		t.Errorf("review = %v", got)
+	}
+	a.do("GET", "/api/v1/reviews/invalid", "", nil).expect(t, http.StatusBadRequest)
+
+	a.do("PUT", "/api/v1/reviews/"+id, john, map[string]int{"rating": 1}).expect(t, http.StatusForbidden)
+	a.do("PUT", "/api/v1/reviews/"+id, jane, map[string]int{"rating": 10}).expect(t, http.StatusOK)
+	if got := a.do("GET", "/api/v1/bootcamps/"+bootcampId, "", nil).data(); got["averageRating"] != 7.0 {
+		t.Errorf("average rating after update = %v", got["averageRating"])
+	}
+
+	a.do("DELETE", "/api/v1/reviews/"+id, john, nil).expect(t, http.StatusForbidden)
+	a.do("DELETE", "/api/v1/reviews/"+id, admin, nil).expect(t, http.StatusOK)
+	a.do("GET", "/api/v1/reviews/"+id, "", nil).expect(t, http.StatusNotFound)
+	if got := a.do("GET", "/api/v1/bootcamps/"+bootcampId, "", nil).data(); got["averageRating"] != 4.0 {
+		t.Errorf("average rating after delete = %v", got["averageRating"])
+	}
+	// the quota counts the live reviews only
+	a.do("POST", path, jane, review).expect(t, http.StatusCreated)
+}
+
+// new token of the transfer, as the emailed one is not known
+func (a *testApp) transferToken(id string) string {
+	a.t.Helper()
+	transfer := &models.Transfer{}
+	token := transfer.GenToken(a.config.JWTSecret)
+	_, err := a.store.C("Transfer").UpdateAll(bson.M{"_id": bson.ObjectIdHex(id)}, bson.M{"$set": bson.M{"tokenHash": transfer.TokenHash}})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return token
+}
+
+func TestTransfers(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	publisher, owner := a.signup("Publisher", models.RolePublisher)
+	other, newOwner := a.signup("Other", models.RolePublisher)
+	user, _ := a.signup("Jane", models.RoleUser)
+	bootcampId := a.createBootcamp(publisher, "Devworks Bootcamp")
+	courseId := a.addCourse(publisher, bootcampId, "Front End Web Development", 8000)
+
+	path := "/api/v1/bootcamps/" + bootcampId + "/transfers"
+	a.do("POST", path, other, map[string]string{"email": "other@gmail.com"}).expect(t, http.StatusForbidden)
+	a.do("POST", path, publisher, map[string]string{}).expect(t, http.StatusBadRequest)
+	a.do("POST", path, publisher, map[string]string{"email": "nobody@gmail.com"}).expect(t, http.StatusNotFound)
+	a.do("POST", path, publisher, map[string]string{"email": "publisher@gmail.com"}).expect(t, http.StatusBadRequest)
+
+	// declined
+	id := a.do("POST", path, publisher, map[string]string{"email": "other@gmail.com"}).expect(t, http.StatusCreated).id()
+	a.do("POST", path, publisher, map[string]string{"email": "other@gmail.com"}).expect(t, http.StatusConflict)
+	a.do("POST", "/api/v1/transfers/"+id+"/decline", user, nil).expect(t, http.StatusNotFound)
+	if got := a.do("POST", "/api/v1/transfers/"+id+"/decline", other, nil).expect(t, http.StatusOK).data(); got["status"] != models.TransferDeclined {
+		t.Errorf("declined = %v", got)
+	}
+	a.do("POST", "/api/v1/transfers/"+id+"/accept", other, map[string]string{"token": a.transferToken(id)}).expect(t, http.StatusConflict)
+
+	// cancelled
+	id = a.do("POST", path, publisher, map[string]string{"email": "other@gmail.com"}).expect(t, http.StatusCreated).id()
+	a.do("POST", "/api/v1/transfers/"+id+"/cancel", other, nil).expect(t, http.StatusForbidden)
+	if got := a.do("POST", "/api/v1/transfers/"+id+"/cancel", publisher, nil).expect(t, http.StatusOK).data(); got["status"] != models.TransferCancelled {
+		t.Errorf("cancelled = %v", got)
+	}
+
+	// accepted, the bootcamp and its courses change owner
+	id = a.do("POST", path, publisher, map[string]string{"email": "other@gmail.com"}).expect(t, http.StatusCreated).id()
+	token := a.transferToken(id)
+	a.do("POST", "/api/v1/transfers/"+id+"/accept", other, map[string]string{}).expect(t, http.StatusBadRequest)
+	a.do("POST", "/api/v1/transfers/"+id+"/accept", other, map[string]string{"token": "00" + token[2:]}).expect(t, http.StatusBadRequest)
+	a.do("POST", "/api/v1/transfers/"+id+"/accept", publisher, map[string]string{"token": token}).expect(t, http.StatusNotFound)
+	if got := a.do("POST", "/api/v1/transfers/"+id+"/accept", other, map[string]string{"token": token}).expect(t, http.StatusOK).data(); got["status"] != models.TransferCompleted {
+		t.Errorf("accepted = %v", got)
+	}
+	if got := a.do("GET", "/api/v1/bootcamps/"+bootcampId, "", nil).data(); got["user"] != newOwner.Id.Hex() {
+		t.Errorf("owner = %v, want %s", got["user"], newOwner.Id.Hex())
+	}
+	if got := a.do("GET", "/api/v1/courses/"+courseId, "", nil).data(); got["user"] != newOwner.Id.Hex() {
+		t.Errorf("course owner = %v, want %s", got["user"], newOwner.Id.Hex())
+	}
+	a.do("PUT", "/api/v1/bootcamps/"+bootcampId, publisher, map[string]string{"phone": "(222) 222-2222"}).expect(t, http.StatusForbidden)
+	a.do("PUT", "/api/v1/bootcamps/"+bootcampId, other, map[string]string{"phone": "(222) 222-2222"}).expect(t, http.StatusOK)
+
+	// history, newest first
+	a.do("GET", path, publisher, nil).expect(t, http.StatusForbidden)
+	transfers := a.do("GET", path, admin, nil).expect(t, http.StatusOK).list()
+	if len(transfers) != 3 || transfers[0].(map[string]interface{})["from"] != owner.Id.Hex() || transfers[0].(map[string]interface{})["status"] != models.TransferCompleted {
+		t.Errorf("transfers = %v", transfers)
+	}
+}
+
+func TestOrganizations(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	publisher, owner := a.signup("Publisher", models.RolePublisher)
+	editor, editorUser := a.signup("Editor", models.RolePublisher)
+	viewer, viewerUser := a.signup("Viewer", models.RoleUser)
+	bootcampId := a.createBootcamp(publisher, "Devworks Bootcamp")
+
+	a.do("POST", "/api/v1/organizations", viewer, map[string]string{"name": "Devworks"}).expect(t, http.StatusForbidden)
+	a.do("POST", "/api/v1/organizations", publisher, map[string]string{}).expect(t, http.StatusBadRequest)
+	id := a.do("POST", "/api/v1/organizations", publisher, map[string]string{"name": "Devworks"}).expect(t, http.StatusCreated).id()
+	path := "/api/v1/organizations/" + id
+
+	// the organizations of the others are not found
+	a.do("GET", path, editor, nil).expect(t, http.StatusNotFound)
+	a.do("GET", path, admin, nil).expect(t, http.StatusOK)
+	if got := a.do("GET", "/api/v1/organizations", publisher, nil).expect(t, http.StatusOK).list(); len(got) != 1 {
+		t.Errorf("organizations = %v", got)
+	}
+	if got := a.do("PUT", path, publisher, map[string]string{"name": "Devworks Inc"}).expect(t, http.StatusOK).data(); got["name"] != "Devworks Inc" {
+		t.Errorf("renamed = %v", got)
+	}
+
+	// invites, the emailed token is given again by the store
+	a.do("POST", path+"/invites", editor, map[string]string{"email": "editor@gmail.com", "role": models.OrgEditor}).expect(t, http.StatusNotFound)
+	a.do("POST", path+"/invites", publisher, map[string]string{"role": models.OrgEditor}).expect(t, http.StatusBadRequest)
+	a.do("POST", path+"/invites", publisher, map[string]string{"email": "editor@gmail.com", "role": "king"}).expect(t, http.StatusBadRequest)
+	a.do("POST", path+"/invites", publisher, map[string]string{"email": "editor@gmail.com", "role": models.OrgEditor}).expect(t, http.StatusCreated)
+	invite := func(email string, role string) string {
+		org, err := a.repos.Organizations.FindId(bson.ObjectIdHex(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := org.Invite(email, role, owner.Id, a.config.JWTSecret)
+		if err := a.repos.Organizations.Save(org); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	token := invite("editor@gmail.com", models.OrgEditor)
+	a.do("POST", path+"/join", viewer, map[string]string{"token": token}).expect(t, http.StatusBadRequest)
+	a.do("POST", path+"/join", editor, map[string]string{}).expect(t, http.StatusBadRequest)
+	a.do("POST", path+"/join", editor, map[string]string{"token": token}).expect(t, http.StatusOK)
+	a.do("POST", path+"/join", editor, map[string]string{"token": token}).expect(t, http.StatusBadRequest)
+	a.do("POST", path+"/join", viewer, map[string]string{"token": invite("viewer@gmail.com", models.OrgViewer)}).expect(t, http.StatusOK)
+	if members := a.do("GET", path, viewer, nil).expect(t, http.StatusOK).data()["members"].([]interface{}); len(members) != 3 {
+		t.Errorf("members = %v", members)
+	}
+
+	// the members edit the bootcamps of the organization by their role
+	a.do("PUT", "/api/v1/bootcamps/"+bootcampId, editor, map[string]string{"phone": "(222) 222-2222"}).expect(t, http.StatusForbidden)
+	a.do("PUT", "/api/v1/bootcamps/"+bootcampId+"/organization", editor, map[string]string{"organization": id}).expect(t, http.StatusForbidden)
+	a.do("PUT", "/api/v1/bootcamps/"+bootcampId+"/organization", publisher, map[string]string{"organization": bson.NewObjectId().Hex()}).expect(t, http.StatusNotFound)
+	if got := a.do("PUT", "/api/v1/bootcamps/"+bootcampId+"/organization", publisher, map[string]string{"organization": id}).expect(t, http.StatusOK).data(); got["organization"] != id {
+		t.Errorf("bootcamp = %v", got)
+	}
+	a.do("PUT", "/api/v1/bootcamps/"+bootcampId, editor, map[string]string{"phone": "(222) 222-2222"}).expect(t, http.StatusOK)
+	a.addCourse(editor, bootcampId, "Front End Web Development", 8000)
+	a.do("DELETE", "/api/v1/bootcamps/"+bootcampId+"?dryRun=true", editor, nil).expect(t, http.StatusForbidden)
+	a.do("PUT", "/api/v1/bootcamps/"+bootcampId, viewer, map[string]string{"phone": "(333) 333-3333"}).expect(t, http.StatusForbidden)
+
+	// member roles
+	memberPath := path + "/members/"
+	a.do("PUT", memberPath+viewerUser.Id.Hex(), editor, map[string]string{"role": models.OrgEditor}).expect(t, http.StatusForbidden)
+	a.do("PUT", memberPath+viewerUser.Id.Hex(), publisher, map[string]string{"role": "king"}).expect(t, http.StatusBadRequest)
+	a.do("PUT", memberPath+bson.NewObjectId().Hex(), publisher, map[string]string{"role": models.OrgEditor}).expect(t, http.StatusNotFound)
+	a.do("PUT", memberPath+owner.Id.Hex(), publisher, map[string]string{"role": models.OrgViewer}).expect(t, http.StatusBadRequest)
+	a.do("PUT", memberPath+editorUser.Id.Hex(), publisher, map[string]string{"role": models.OrgViewer}).expect(t, http.StatusOK)
+	a.do("PUT", "/api/v1/bootcamps/"+bootcampId, editor, map[string]string{"phone": "(333) 333-3333"}).expect(t, http.StatusForbidden)
+
+	// a member leaves by itself, the others are removed by an owner
+	a.do("DELETE", memberPath+editorUser.Id.Hex(), viewer, nil).expect(t, http.StatusForbidden)
+	a.do("DELETE", memberPath+viewerUser.Id.Hex(), viewer, nil).expect(t, http.StatusOK)
+	a.do("GET", path, viewer, nil).expect(t, http.StatusNotFound)
+	a.do("DELETE", memberPath+editorUser.Id.Hex(), publisher, nil).expect(t, http.StatusOK)
+	a.do("DELETE", memberPath+owner.Id.Hex(), publisher, nil).expect(t, http.StatusBadRequest)
+
+	// out of the organization
+	if got := a.do("PUT", "/api/v1/bootcamps/"+bootcampId+"/organization", publisher, map[string]string{}).expect(t, http.StatusOK).data(); got["organization"] != nil {
+		t.Errorf("bootcamp = %v", got)
+	}
+}
+
+func TestBootcampsExpandCourses(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	courses := map[string][]string{}
+	for _, name := range []string{"Bootcamp A", "Bootcamp B", "Bootcamp C", "Bootcamp D", "Bootcamp E"} {
+		id := a.createBootcamp(admin, name)
+		for i := 0; i < len(courses)%3; i++ {
+			title := fmt.Sprintf("%s course %d", name, i)
+			a.addCourse(admin, id, title, 1000)
+			courses[id] = append(courses[id], title)
+		}
+		if len(courses[id]) == 0 {
+			courses[id] = nil
+		}
+	}
+	// the deleted courses are not attached
+	deleted := a.addCourse(admin, a.createBootcamp(admin, "Bootcamp F"), "Deleted course", 1000)
+	a.do("DELETE", "/api/v1/courses/"+deleted, admin, nil).expect(t, http.StatusOK)
+
+	for _, query := range []string{"sort=name&limit=2", "sort=name&limit=2&page=2", "sort=-name&limit=4&select=id,name,courses", "limit=3&housing=true"} {
+		plain := a.do("GET", "/api/v1/bootcamps?"+query, "", nil).expect(t, http.StatusOK)
+		expanded := a.do("GET", "/api/v1/bootcamps?"+query+"&expand=courses", "", nil).expect(t, http.StatusOK)
+
+		// the same page
+		if !equalNames(names(expanded.list()), names(plain.list())...) || expanded.body["count"] != plain.body["count"] {
+			t.Errorf("%s: expanded %v, want %v", query, names(expanded.list()), names(plain.list()))
+		}
+		pagination := func(r *response) interface{} {
+			p := r.body["pagination"].(map[string]interface{})
+			delete(p["next"].(map[string]interface{}), "cursor")
+			delete(p["prev"].(map[string]interface{}), "cursor")
+			return p
+		}
+		if got, want := pagination(expanded), pagination(plain); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: pagination %v, want %v", query, got, want)
+		}
+		if got, want := expanded.header.Get("X-Total-Count"), plain.header.Get("X-Total-Count"); got != want {
+			t.Errorf("%s: X-Total-Count %s, want %s", query, got, want)
+		}
+
+		// with the courses attached
+		for i, v := range expanded.list() {
+			bootcamp := v.(map[string]interface{})
+			var titles []string
+			list, _ := bootcamp["courses"].([]interface{})
+			for _, c := range list {
+				c := c.(map[string]interface{})
+				if c["bootcamp"] != bootcamp["id"] {
+					t.Errorf("%s: course %v attached to bootcamp %s", query, c, bootcamp["id"])
+				}
+				titles = append(titles, c["title"].(string))
+			}
+			sort.Strings(titles)
+			if !reflect.DeepEqual(titles, courses[bootcamp["id"].(string)]) {
+				t.Errorf("%s: courses of %s = %v, want %v", query, bootcamp["name"], titles, courses[bootcamp["id"].(string)])
+			}
+			if _, ok := plain.list()[i].(map[string]interface{})["courses"]; ok {
+				t.Errorf("%s: courses without expand", query)
+			}
+		}
+	}
+
+	// the cursor of an expanded page leads to the same next page
+	plain := a.do("GET", "/api/v1/bootcamps?sort=name&limit=2", "", nil)
+	expanded := a.do("GET", "/api/v1/bootcamps?sort=name&limit=2&expand=courses", "", nil)
+	next := func(r *response) string {
+		return r.body["pagination"].(map[string]interface{})["next"].(map[string]interface{})["cursor"].(string)
+	}
+	plainNext := a.do("GET", "/api/v1/bootcamps?sort=name&limit=2&cursor="+url.QueryEscape(next(plain)), "", nil).expect(t, http.StatusOK)
+	expandedNext := a.do("GET", "/api/v1/bootcamps?sort=name&limit=2&expand=courses&cursor="+url.QueryEscape(next(expanded)), "", nil).expect(t, http.StatusOK)
+	if got, want := names(expandedNext.list()), names(plainNext.list()); !equalNames(got, want...) || !equalNames(want, "Bootcamp C", "Bootcamp D") {
+		t.Errorf("next page expanded %v, want %v", got, want)
+	}
+}
+
+func TestCourseQuotaOfOwner(t *testing.T) {
+	a := newTestApp(t)
+	admin, _ := a.signup("Admin", models.RoleAdmin)
+	publisher, owner := a.signup("Publisher", models.RolePublisher)
+	editor, editorUser := a.signup("Editor", models.RolePublisher)
+	bootcampId := a.createBootcamp(publisher, "Devworks Bootcamp")
+
+	// the editor has no limit, the owner of the bootcamp 2 courses
+	a.do("PUT", "/api/v1/users/"+owner.Id.Hex()+"/quotas", admin, map[string]int{models.QuotaCoursesPerBootcamp: 2}).expect(t, http.StatusOK)
+	a.do("PUT", "/api/v1/users/"+editorUser.Id.Hex()+"/quotas", admin, map[string]int{models.QuotaCoursesPerBootcamp: models.Unlimited}).expect(t, http.StatusOK)
+	orgId := a.do("POST", "/api/v1/organizations", publisher, map[string]string{"name": "Devworks"}).expect(t, http.StatusCreated).id()
+	org, _ := a.repos.Organizations.FindId(bson.ObjectIdHex(orgId))
+	org.SetMember(editorUser.Id, models.OrgEditor)
+	if err := a.repos.Organizations.Save(org); err != nil {
+		t.Fatal(err)
+	}
+	a.do("PUT", "/api/v1/bootcamps/"+bootcampId+"/organization", publisher, map[string]string{"organization": orgId}).expect(t, http.StatusOK)
+
+	// the courses added by an admin or a member are theirs, and count for the owner
+	for _, token := range []string{admin, editor} {
+		id := a.addCourse(token, bootcampId, "Course", 1000)
+		me := a.do("GET", "/api/v1/auth/me", token, nil).id()
+		if got := a.do("GET", "/api/v1/courses/"+id, "", nil).data(); got["user"] != me {
+			t.Errorf("course user = %v, want the author %s", got["user"], me)
+		}
+	}
+
+	course := map[string]interface{}{"title": "Course", "description": "Learn", "weeks": 8, "tuition": 1000, "minimumSkill": "beginner"}
+	for _, token := range []string{admin, editor, publisher} {
+		res := a.do("POST", "/api/v1/bootcamps/"+bootcampId+"/courses", token, course).expect(t, http.StatusForbidden)
+		if q := res.body["quota"].(map[string]interface{}); q["quota"] != models.QuotaCoursesPerBootcamp || q["limit"] != 2.0 || q["used"] != 2.0 {
+			t.Errorf("quota = %v", res.body)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// failed attempts of a key (e.g. an email or an ip address)
+type LoginAttempt struct {
+	Key          string    `json:"key" bson:"key"`
+	Failures     int       `json:"failures" bson:"failures"`
+	LastFailedAt time.Time `json:"lastFailedAt" bson:"lastFailedAt"`
+	// no attempt is allowed before this time
+	BlockedUntil time.Time `json:"blockedUntil" bson:"blockedUntil"`
+	// blocked for the whole lockout, not only the backoff delay
+	Locked bool `json:"locked" bson:"locked"`
+}
+
+// time to wait before the next attempt
+func (a *LoginAttempt) RetryAfter(now time.Time) time.Duration {
+	if a == nil || !a.BlockedUntil.After(now) {
+		return 0
+	}
+	return a.BlockedUntil.Sub(now)
+}
+
+// when attempts are delayed and locked out
+type AttemptPolicy struct {
+	// failures allowed without delay
+	FreeAttempts int
+	// delay after the first extra failure, doubled on each next one
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// failures before the lockout
+	MaxAttempts  int
+	LockDuration time.Duration
+	// failures older than this are forgotten
+	Window time.Duration
+}
+
+var DefaultAttemptPolicy = AttemptPolicy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	MaxAttempts:  10,
+	LockDuration: 15 * time.Minute,
+	Window:       time.Hour,
+}
+
+// block time after the number of failures, locked is true once MaxAttempts is reached
+func (p AttemptPolicy) Block(failures int, now time.Time) (time.Time, bool) {
+	if failures >= p.MaxAttempts {
+		return now.Add(p.LockDuration), true
+	}
+	if failures <= p.FreeAttempts {
+		return time.Time{}, false
+	}
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(failures-p.FreeAttempts-1)))
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	return now.Add(delay), false
+}
+
+// keep failed attempts to slow down password guessing
+type AttemptTracker interface {
+	// attempts of the key, nil when there is no recent failure
+	Get(key string) (*LoginAttempt, error)
+	// record a failure, locked is true when this failure starts a lockout
+	Fail(key string) (attempt *LoginAttempt, locked bool, err error)
+	// forget the failures after a success
+	Reset(key string) error
+	// end the lockouts that are over and return them
+	ReleaseExpired() ([]*LoginAttempt, error)
+}
+
+// tracker kept in memory, attempts are not shared between instances and lost on restart
+type MemoryAttemptTracker struct {
+	policy   AttemptPolicy
+	mu       sync.Mutex
+	attempts map[string]*LoginAttempt
+}
+
+func NewMemoryAttemptTracker(policy AttemptPolicy) *MemoryAttemptTracker {
+	return &MemoryAttemptTracker{
+		policy:   policy,
+		attempts: map[string]*LoginAttempt{},
+	}
+}
+
+func (t *MemoryAttemptTracker) Get(key string) (*LoginAttempt, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.attempts[key]
+	if !ok || t.stale(a, time.Now()) {
+		return nil, nil
+	}
+	copied := *a
+	return &copied, nil
+}
+
+func (t *MemoryAttemptTracker) Fail(key string) (*LoginAttempt, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	a, ok := t.attempts[key]
+	// a lockout that is over starts again from zero
+	if !ok || t.stale(a, now) || (a.Locked && !a.BlockedUntil.After(now)) {
+		a = &LoginAttempt{Key: key}
+		t.attempts[key] = a
+	}
+	wasLocked := a.Locked
+	a.Failures++
+	a.LastFailedAt = now
+	a.BlockedUntil, a.Locked = t.policy.Block(a.Failures, now)
+	copied := *a
+	return &copied, a.Locked && !wasLocked, nil
+}
+
+func (t *MemoryAttemptTracker) Reset(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, key)
+	return nil
+}
+
+func (t *MemoryAttemptTracker) ReleaseExpired() ([]*LoginAttempt, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	released := []*LoginAttempt{}
+	for key, a := range t.attempts {
+		if a.Locked && !a.BlockedUntil.After(now) {
+			copied := *a
+			released = append(released, &copied)
+			delete(t.attempts, key)
+		} else if t.stale(a, now) {
+			// nothing to remember anymore
+			delete(t.attempts, key)
+		}
+	}
+	return released, nil
+}
+
+// the failures are too old to count, a running lockout is kept until released
+func (t *MemoryAttemptTracker) stale(a *LoginAttempt, now time.Time) bool {
+	return !a.Locked && now.Sub(a.LastFailedAt) > t.policy.Window
+}
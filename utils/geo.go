@@ -2,14 +2,45 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"net/http"
-	"os"
+	"net/url"
+	"regexp"
 	"strings"
+	"time"
 )
 
-type Location struct {
+var ErrLocationNotFound = errors.New("location not found")
+
+type GeoResult struct {
+	Lat              float64 `json:"lat" bson:"lat"`
+	Lng              float64 `json:"lng" bson:"lng"`
+	FormattedAddress string  `json:"formattedAddress" bson:"formattedAddress"`
+	Street           string  `json:"street" bson:"street"`
+	City             string  `json:"city" bson:"city"`
+	State            string  `json:"state" bson:"state"`
+	Zipcode          string  `json:"zipcode" bson:"zipcode"`
+	Country          string  `json:"country" bson:"country"`
+}
+
+// resolve an address to its location
+type Geocoder interface {
+	Geocode(address string) (*GeoResult, error)
+}
+
+// lower case the address and collapse commas and spaces so the same address always give the same key
+func NormalizeAddress(address string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(strings.ToLower(address), ",", " ")), " ")
+}
+
+// response of mapquest geocoding api
+type mapQuestResponse struct {
+	Info struct {
+		StatusCode int      `json:"statuscode"`
+		Messages   []string `json:"messages"`
+	} `json:"info"`
 	Results []struct {
 		Locations []struct {
 			Street  string `json:"street"`
@@ -25,29 +56,111 @@ type Location struct {
 	} `json:"results"`
 }
 
-func GetLocation(add string) *Location {
-	b := os.Getenv("GEOCODER_URL")
-	k := os.Getenv("GEOCODER_API_KEY")
-	ss := strings.Split(add, " ")
-	var addTrim string
-	for _, v := range ss {
-		if v != " " {
-			addTrim += v + "%20"
-		}
+type MapQuest struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func NewMapQuest(baseURL string, apiKey string) *MapQuest {
+	return &MapQuest{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
 	}
-	uri := fmt.Sprintf("%s?key=%s&location=%s", b, k, addTrim)
-	resp, err := http.Get(uri)
+}
+
+func (m *MapQuest) Geocode(address string) (*GeoResult, error) {
+	q := url.Values{}
+	q.Set("key", m.apiKey)
+	q.Set("location", address)
+	resp, err := m.client.Get(fmt.Sprintf("%s?%s", m.baseURL, q.Encode()))
 	if err != nil {
-		// handle error
-		log.Println(err)
+		return nil, fmt.Errorf("cannot reach geocoder: %w", err)
 	}
 	defer resp.Body.Close()
-	var loc Location
-	json.NewDecoder(resp.Body).Decode(&loc)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("geocoder responded with status %d", resp.StatusCode)
+	}
+
+	var loc mapQuestResponse
+	err = json.NewDecoder(resp.Body).Decode(&loc)
+	if err != nil {
+		return nil, fmt.Errorf("decode geocoder response: %w", err)
+	}
+	if loc.Info.StatusCode != 0 {
+		return nil, fmt.Errorf("geocoder error %d: %s", loc.Info.StatusCode, strings.Join(loc.Info.Messages, ", "))
+	}
+	if len(loc.Results) == 0 || len(loc.Results[0].Locations) == 0 {
+		return nil, ErrLocationNotFound
+	}
+
+	tmp := loc.Results[0].Locations[0]
+	return &GeoResult{
+		Lat:              tmp.LatLng.Lat,
+		Lng:              tmp.LatLng.Lng,
+		FormattedAddress: fmt.Sprintf("%s, %s, %s %s, %s", tmp.Street, tmp.City, tmp.State, tmp.Zipcode, tmp.Country),
+		Street:           tmp.Street,
+		City:             tmp.City,
+		State:            tmp.State,
+		Zipcode:          tmp.Zipcode,
+		Country:          tmp.Country,
+	}, nil
+}
+
+// offline geocoder for development and tests
+// known addresses are loaded from a json file (address -> result),
+// other addresses fall back to the zipcode found at the end of the address
+type OfflineGeocoder struct {
+	addresses map[string]GeoResult
+	zipcodes  ZipcodeLookup
+}
+
+var trailingZipcode = regexp.MustCompile(`(\d{5})(-\d{4})?$`)
+
+func LoadOfflineGeocoder(path string, zipcodes ZipcodeLookup) (*OfflineGeocoder, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var addresses map[string]GeoResult
+	err = json.Unmarshal(file, &addresses)
 	if err != nil {
-		log.Println("Decode bad data: ", err)
+		return nil, fmt.Errorf("cannot decode geocodes: %w", err)
+	}
+
+	g := &OfflineGeocoder{
+		addresses: map[string]GeoResult{},
+		zipcodes:  zipcodes,
+	}
+	for k, v := range addresses {
+		g.addresses[NormalizeAddress(k)] = v
 	}
+	return g, nil
+}
 
-	return &loc
+func (g *OfflineGeocoder) Geocode(address string) (*GeoResult, error) {
+	key := NormalizeAddress(address)
+	if res, ok := g.addresses[key]; ok {
+		return &res, nil
+	}
 
+	zipcode := trailingZipcode.FindStringSubmatch(key)
+	if zipcode == nil || g.zipcodes == nil {
+		return nil, ErrLocationNotFound
+	}
+	coord, err := g.zipcodes.Lookup(zipcode[1])
+	if err == ErrZipcodeNotFound {
+		return nil, ErrLocationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &GeoResult{
+		Lat:              coord.Lat,
+		Lng:              coord.Lng,
+		FormattedAddress: address,
+		Zipcode:          zipcode[1],
+	}, nil
 }
@@ -1,10 +1,10 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"errors"
 	"fmt"
-	"os"
-	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -12,35 +12,101 @@ import (
 
 type Payload struct {
 	Id string
+	// login session the token belongs to
+	SessionId string `json:"sid,omitempty"`
+	// set on tokens that are not access tokens, e.g. the 2FA login challenge
+	Purpose string `json:"pur,omitempty"`
 	jwt.StandardClaims
 }
 
-func GetJwt(id string) (string, error) {
-	tokenDuration, _ := strconv.Atoi(os.Getenv("JWT_EXPIRE"))
-	payload := Payload{
-		Id: id,
+// purpose of the token given after password check when 2FA is enabled
+const ChallengePurpose = "2fa"
+
+// sign and parse the HS256 tokens of the app
+type JWT struct {
+	secret []byte
+	expire time.Duration
+}
+
+func NewJWT(secret string, expire time.Duration) *JWT {
+	return &JWT{
+		secret: []byte(secret),
+		expire: expire,
+	}
+}
+
+// access token of the user in the login session
+func (j *JWT) Sign(id string, sessionId string) (string, error) {
+	return j.sign(Payload{
+		Id:        id,
+		SessionId: sessionId,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Minute * time.Duration(tokenDuration)).Unix(),
+			ExpiresAt: time.Now().Add(j.expire).Unix(),
 		},
-	}
+	})
+}
+
+// short-lived token proving the password was checked, exchanged with a 2FA code for an access token
+func (j *JWT) SignChallenge(id string) (string, error) {
+	return j.sign(Payload{
+		Id:      id,
+		Purpose: ChallengePurpose,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Minute * 5).Unix(),
+		},
+	})
+}
+
+func (j *JWT) sign(payload Payload) (string, error) {
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, &payload)
-	ss, err := t.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	ss, err := t.SignedString(j.secret)
 	if err != nil {
 		return "", fmt.Errorf("cannot signed token: %w", err)
 	}
 	return ss, nil
 }
 
-func ParseJwt(ss string) (jwt.Claims, error) {
-	t, err := jwt.ParseWithClaims(ss, &Payload{}, func(t *jwt.Token) (interface{}, error) {
-		if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-			return []byte{}, errors.New("signed algo not match")
-		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+func (j *JWT) Parse(ss string) (*Payload, error) {
+	payload := &Payload{}
+	t, err := jwt.ParseWithClaims(ss, payload, j.key)
 	if err == nil && t.Valid {
-		return t.Claims, nil
+		return payload, nil
 	} else {
 		return nil, errors.New("not valid token")
 	}
 }
+
+func (j *JWT) ParseChallenge(ss string) (*Payload, error) {
+	payload, err := j.Parse(ss)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Purpose != ChallengePurpose {
+		return nil, errors.New("not valid token")
+	}
+	return payload, nil
+}
+
+// parse token with a valid signature even if it is expired, used to find the session on logout
+func (j *JWT) ParseExpired(ss string) (*Payload, error) {
+	payload := &Payload{}
+	_, err := jwt.ParseWithClaims(ss, payload, j.key)
+	if verr, ok := err.(*jwt.ValidationError); err != nil && (!ok || verr.Errors != jwt.ValidationErrorExpired) {
+		return nil, errors.New("not valid token")
+	}
+	return payload, nil
+}
+
+func (j *JWT) key(t *jwt.Token) (interface{}, error) {
+	if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+		return []byte{}, errors.New("signed algo not match")
+	}
+	return j.secret, nil
+}
+
+// hash of an opaque token (reset password, refresh token, etc.) keyed with the app secret
+func HashToken(secret string, bs []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(bs)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ip address of the client, X-Forwarded-For is used only when the app runs behind a trusted proxy
+func ClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -33,8 +33,37 @@ func ErrorResponse(w http.ResponseWriter, status int, err ...error) {
 	SendJSON(w, status, data)
 }
 
+// error that knows its response status, e.g. the errors of the models store
+type StatusError interface {
+	error
+	Status() int
+}
+
+// error with more fields for the client, e.g. the limit of a quota error
+type DetailedError interface {
+	StatusError
+	Details() map[string]interface{}
+}
+
+// error response with the details added to its fields
+func ErrorDetails(w http.ResponseWriter, status int, err error, details map[string]interface{}) {
+	data := map[string]interface{}{
+		"success": false,
+		"error":   err.Error(),
+		"data":    nil,
+	}
+	for k, v := range details {
+		data[k] = v
+	}
+	SendJSON(w, status, data)
+}
+
 func ErrorHandler(w http.ResponseWriter, err error) {
-	if _, ok := err.(*mongodm.NotFoundError); ok {
+	if v, ok := err.(DetailedError); ok {
+		ErrorDetails(w, v.Status(), v, v.Details())
+	} else if v, ok := err.(StatusError); ok {
+		ErrorResponse(w, v.Status(), v)
+	} else if _, ok := err.(*mongodm.NotFoundError); ok {
 		ErrorResponse(w, http.StatusBadRequest, errors.New("not found resource"))
 	} else if v, ok := err.(*mongodm.ValidationError); ok {
 		ErrorResponse(w, http.StatusBadRequest, v)
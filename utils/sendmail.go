@@ -1,23 +1,50 @@
 package utils
 
 import (
+	"fmt"
+	"net/mail"
 	"net/smtp"
-	"os"
 )
 
-func SendMail(to string, subj string, body string) bool {
+// send emails through a SMTP server
+type Mailer struct {
+	host     string
+	port     int
+	user     string
+	password string
+	from     string
+	// shown with the address in the From header
+	fromName string
+}
+
+func NewMailer(host string, port int, user string, password string, from string, fromName string) *Mailer {
+	return &Mailer{
+		host:     host,
+		port:     port,
+		user:     user,
+		password: password,
+		from:     from,
+		fromName: fromName,
+	}
+}
+
+func (m *Mailer) SendMail(to string, subj string, body string) bool {
 	// Set up authentication information.
-	auth := smtp.PlainAuth("", os.Getenv("SMTP_EMAIL"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_HOST"))
+	auth := smtp.PlainAuth("", m.user, m.password, m.host)
 
 	// Connect to the server, authenticate, set the sender and recipient,
 	// and send the email all in one step.
-	msg := []byte("From: " + os.Getenv("FROM_EMAIL") + "\r\n" +
+	err := smtp.SendMail(fmt.Sprintf("%s:%d", m.host, m.port), auth, m.from, []string{to}, m.message(to, subj, body))
+
+	return err == nil
+}
+
+// email with its headers, the name of the sender is quoted or encoded when needed
+func (m *Mailer) message(to string, subj string, body string) []byte {
+	from := (&mail.Address{Name: m.fromName, Address: m.from}).String()
+	return []byte("From: " + from + "\r\n" +
 		"To: " + to + "\r\n" +
 		"Subject: " + subj + "\r\n" +
 		"\r\n" +
 		body + "\r\n")
-
-	err := smtp.SendMail(os.Getenv("SMTP_HOST")+":"+os.Getenv("SMTP_PORT"), auth, os.Getenv("FROM_EMAIL"), []string{to}, msg)
-
-	return err == nil
 }
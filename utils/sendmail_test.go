@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMailerFromHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"devcamper", "From: \"devcamper\" <noreply@devcamper.io>\r\n"},
+		{"", "From: <noreply@devcamper.io>\r\n"},
+		{"Dev Camper, Inc.", "From: \"Dev Camper, Inc.\" <noreply@devcamper.io>\r\n"},
+		{"Développeur", "From: =?utf-8?q?D=C3=A9veloppeur?= <noreply@devcamper.io>\r\n"},
+	}
+	for _, tt := range tests {
+		m := NewMailer("localhost", 25, "user", "password", "noreply@devcamper.io", tt.name)
+		msg := string(m.message("to@gmail.com", "Subject", "body"))
+		if !strings.HasPrefix(msg, tt.want) {
+			t.Errorf("name %q: message starts with %q, want %q", tt.name, strings.SplitN(msg, "\n", 2)[0], tt.want)
+		}
+		if !strings.Contains(msg, "To: to@gmail.com\r\nSubject: Subject\r\n\r\nbody\r\n") {
+			t.Errorf("name %q: unexpected message %q", tt.name, msg)
+		}
+	}
+}
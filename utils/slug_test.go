@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Devworks Bootcamp", "devworks-bootcamp"},
+		{"  --Hello,   World!--  ", "hello-world"},
+		{"Codemasters 2024", "codemasters-2024"},
+		{"Joe's Coding School", "joes-coding-school"},
+		{"Joe’s Coding School", "joes-coding-school"},
+		{"UI/UX + Design", "ui-ux-plus-design"},
+		{"Code @ Night", "code-at-night"},
+		// transliteration
+		{"Café & Code: São Paulo", "cafe-and-code-sao-paulo"},
+		{"Straße Ærø Œuvre", "strasse-aero-oeuvre"},
+		{"Łódź Kraków", "lodz-krakow"},
+		{"Αθήνα Code", "athina-code"},
+		{"Москва Школа", "moskva-shkola"},
+		{"Київ", "kiyiv"},
+		// the combining accents are dropped, not the letters
+		{"Cafe\u0301 Code", "cafe-code"},
+		// nothing to spell in ascii
+		{"東京", ""},
+		{"東京 Code", "code"},
+		{"", ""},
+		{"!!!", ""},
+	}
+	for _, tt := range tests {
+		if got := Slugify(tt.name); got != tt.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSlugifyTruncate(t *testing.T) {
+	word := "abcdefghij"
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		// cut between the words: 5 words are 54 runes, 6 would be 65
+		{"between words", strings.Repeat(word+" ", 7), strings.TrimSuffix(strings.Repeat(word+"-", 5), "-")},
+		{"exactly the limit", strings.Repeat("a", 60), strings.Repeat("a", 60)},
+		{"joined to the limit", strings.Repeat("a", 29) + " " + strings.Repeat("b", 30), strings.Repeat("a", 29) + "-" + strings.Repeat("b", 30)},
+		// a first word longer than the limit is cut
+		{"long word", strings.Repeat("a", 70) + " code", strings.Repeat("a", 60)},
+		// the transliteration counts, not the letters of the name
+		{"transliterated", strings.Repeat("щ", 20), strings.Repeat("shch", 15)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Slugify(tt.in)
+			if got != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if len(got) > maxSlugLength {
+				t.Errorf("len(Slugify(%q)) = %d, more than %d", tt.in, len(got), maxSlugLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// where uploaded files are kept
+type Storage interface {
+	// store the file and return the url it is served from
+	Put(name string, contentType string, data []byte) (string, error)
+	Delete(name string) error
+}
+
+// store files on the local disk, the directory is expected to be served at baseURL
+type LocalStorage struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocalStorage(dir string, baseURL string) (*LocalStorage, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create upload directory: %w", err)
+	}
+	return &LocalStorage{
+		dir:     dir,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}, nil
+}
+
+func (s *LocalStorage) Put(name string, contentType string, data []byte) (string, error) {
+	name = filepath.Base(name)
+	err := ioutil.WriteFile(filepath.Join(s.dir, name), data, 0644)
+	if err != nil {
+		return "", err
+	}
+	return s.baseURL + "/" + name, nil
+}
+
+func (s *LocalStorage) Delete(name string) error {
+	err := os.Remove(filepath.Join(s.dir, filepath.Base(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// store files in a S3 compatible bucket (AWS S3, MinIO, etc.)
+// requests use path-style urls (endpoint/bucket/key) signed with AWS signature version 4
+type S3Storage struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	publicURL string
+	client    *http.Client
+}
+
+func NewS3Storage(endpoint string, region string, bucket string, accessKey string, secretKey string, publicURL string) (*S3Storage, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if publicURL == "" {
+		publicURL = fmt.Sprintf("%s://%s/%s", u.Scheme, u.Host, bucket)
+	}
+	return &S3Storage{
+		endpoint:  u,
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		publicURL: strings.TrimRight(publicURL, "/"),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+func (s *S3Storage) Put(name string, contentType string, data []byte) (string, error) {
+	name = path.Base(name)
+	err := s.do(http.MethodPut, name, contentType, data)
+	if err != nil {
+		return "", err
+	}
+	return s.publicURL + "/" + name, nil
+}
+
+func (s *S3Storage) Delete(name string) error {
+	return s.do(http.MethodDelete, path.Base(name), "", nil)
+}
+
+func (s *S3Storage) do(method string, key string, contentType string, data []byte) error {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket + "/" + key
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, data, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach s3: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("s3 %s %s: status %d: %s", method, key, resp.StatusCode, body)
+	}
+	return nil
+}
+
+// sign the request with AWS signature version 4
+// see https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func (s *S3Storage) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		signedHeaders = append([]string{"content-type"}, signedHeaders...)
+	}
+	var canonicalHeaders string
+	for _, h := range signedHeaders {
+		canonicalHeaders += h + ":" + strings.TrimSpace(req.Header.Get(h)) + "\n"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
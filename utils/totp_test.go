@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// secret of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B, SHA1 mode, the codes are the last 6 of the 8 digits of the RFC
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{"current step", step, true},
+		{"previous step", step - 1, true},
+		{"next step", step + 1, true},
+		{"two steps before", step - 2, false},
+		{"two steps after", step + 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := TOTPCode(rfcSecret, tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := ValidateTOTP(rfcSecret, code, now)
+			if ok != tt.valid {
+				t.Fatalf("ValidateTOTP = %v, want %v", ok, tt.valid)
+			}
+			if ok && got != tt.step {
+				t.Errorf("matched step %d, want %d", got, tt.step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPFormat(t *testing.T) {
+	now := time.Unix(59, 0)
+	tests := []struct {
+		code  string
+		valid bool
+	}{
+		{"287082", true},
+		{" 287 082 ", true},
+		{"28708", false},
+		{"2870821", false},
+		{"287083", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if _, ok := ValidateTOTP(rfcSecret, tt.code, now); ok != tt.valid {
+			t.Errorf("ValidateTOTP(%q) = %v, want %v", tt.code, ok, tt.valid)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", now); ok {
+		t.Error("ValidateTOTP accepted an invalid secret")
+	}
+}
+
+func TestGenTOTPSecret(t *testing.T) {
+	a, err := GenTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+	key, err := totpEncoding.DecodeString(a)
+	if err != nil {
+		t.Fatalf("secret %s is not base32: %v", a, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(key))
+	}
+	if _, err := TOTPCode(a, 1); err != nil {
+		t.Errorf("TOTPCode with a generated secret: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrZipcodeNotFound = errors.New("zipcode not found")
+
+type Coordinate struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// resolve a zipcode to its coordinate
+type ZipcodeLookup interface {
+	Lookup(zipcode string) (*Coordinate, error)
+}
+
+// offline zipcode lookup loaded from a csv file (zipcode,city,state,lat,lng)
+type Gazetteer struct {
+	entries map[string]Coordinate
+}
+
+func LoadGazetteer(path string) (*Gazetteer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read gazetteer: %w", err)
+	}
+
+	g := &Gazetteer{
+		entries: map[string]Coordinate{},
+	}
+	for i, row := range rows {
+		// skip header
+		if i == 0 && row[0] == "zipcode" {
+			continue
+		}
+		if len(row) != 5 {
+			return nil, fmt.Errorf("gazetteer line %d: expected 5 columns", i+1)
+		}
+		lat, err := strconv.ParseFloat(row[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gazetteer line %d: bad latitude", i+1)
+		}
+		lng, err := strconv.ParseFloat(row[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gazetteer line %d: bad longitude", i+1)
+		}
+		g.entries[row[0]] = Coordinate{Lat: lat, Lng: lng}
+	}
+	return g, nil
+}
+
+func (g *Gazetteer) Lookup(zipcode string) (*Coordinate, error) {
+	// ignore the +4 part of a zip code
+	zipcode = strings.SplitN(strings.TrimSpace(zipcode), "-", 2)[0]
+	c, ok := g.entries[zipcode]
+	if !ok {
+		return nil, ErrZipcodeNotFound
+	}
+	return &c, nil
+}